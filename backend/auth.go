@@ -7,6 +7,7 @@ import (
 	"go-initiative-tracker/dao"
 	"log"
 	"net/http"
+	"slices"
 
 	"golang.org/x/oauth2"
 )
@@ -34,6 +35,66 @@ func apiMeHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// apiPreferencesHandler returns the caller's preferences: the ruleset new
+// encounters play.
+func apiPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	discordID := getDiscordIDFromRequest(r)
+	if discordID == "" {
+		writeJSONError(w, http.StatusUnauthorized, "You must be logged in")
+		return
+	}
+
+	ruleset, err := userDAO.GetRuleset(discordID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load preferences")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"ruleset": ruleset})
+}
+
+// apiSavePreferencesHandler updates the caller's preferences. Changing the
+// ruleset only affects encounters created afterwards.
+func apiSavePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		Ruleset string `json:"ruleset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if !slices.Contains(dao.ValidRulesets, req.Ruleset) {
+		writeJSONError(w, http.StatusBadRequest, "Ruleset must be 2014 or 2024")
+		return
+	}
+	discordID := getDiscordIDFromRequest(r)
+	if discordID == "" {
+		writeJSONError(w, http.StatusUnauthorized, "You must be logged in")
+		return
+	}
+
+	updated, err := userDAO.SetRuleset(discordID, req.Ruleset)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to save preferences")
+		return
+	}
+	if !updated {
+		writeJSONError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
 func generateState() string {
 	b := make([]byte, 16)
 	_, err := rand.Read(b)
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"go-initiative-tracker/dao"
 	"net/http"
 )
 
@@ -44,29 +45,36 @@ func requireEncounterOwner(w http.ResponseWriter, r *http.Request, encounterID i
 // `return` immediately when it is false. Logged-out callers ("") only match
 // logged-out encounters and are never members.
 func requireEncounterAccess(w http.ResponseWriter, r *http.Request, encounterID int) bool {
+	_, ok := loadAccessibleEncounter(w, r, encounterID)
+	return ok
+}
+
+// loadAccessibleEncounter is requireEncounterAccess for handlers that also
+// need the encounter itself, such as its ruleset.
+func loadAccessibleEncounter(w http.ResponseWriter, r *http.Request, encounterID int) (dao.Encounter, bool) {
 	enc, err := encounterDAO.GetByID(encounterID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			writeJSONError(w, http.StatusNotFound, "Encounter not found")
-			return false
+			return dao.Encounter{}, false
 		}
 		writeJSONError(w, http.StatusInternalServerError, "Failed to load encounter")
-		return false
+		return dao.Encounter{}, false
 	}
 	discordID := getDiscordIDFromRequest(r)
 	if enc.OwnerID == discordID {
-		return true
+		return enc, true
 	}
 	if discordID != "" {
 		isMember, err := encounterDAO.IsMember(encounterID, discordID)
 		if err != nil {
 			writeJSONError(w, http.StatusInternalServerError, "Failed to check encounter access")
-			return false
+			return dao.Encounter{}, false
 		}
 		if isMember {
-			return true
+			return enc, true
 		}
 	}
 	writeJSONError(w, http.StatusForbidden, "You do not have access to this encounter")
-	return false
+	return dao.Encounter{}, false
 }
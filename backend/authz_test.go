@@ -31,8 +31,18 @@ func newEncounterMock(t *testing.T) (sqlmock.Sqlmock, func()) {
 }
 
 func encounterRow(id int, owner string) *sqlmock.Rows {
-	return sqlmock.NewRows([]string{"id", "name", "owner_id", "description"}).
-		AddRow(id, "Test Encounter", owner, "")
+	return sqlmock.NewRows([]string{"id", "name", "owner_id", "description", "round", "turn_index", "combat_started", "skip_policy", "ruleset"}).
+		AddRow(id, "Test Encounter", owner, "", 0, 0, false, "none", "2014")
+}
+
+// turnOrderRows is the combat DAO's turn-order query result for ids, with
+// strictly descending initiative so the rows sort into the order given.
+func turnOrderRows(ids ...int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"character_id", "initiative", "turn_rank", "dex_modifier", "tiebreak_order", "is_npc", "current_hp", "disabled", "held", "event_id", "group_id", "surprised"})
+	for i, id := range ids {
+		rows.AddRow(id, len(ids)-i, 0, 0, nil, false, 10, false, false, 0, 0, false)
+	}
+	return rows
 }
 
 func postJSON(path, body string) (*httptest.ResponseRecorder, *http.Request) {
@@ -84,12 +94,20 @@ func TestStartCombatAllowsOwner(t *testing.T) {
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
 	m.ExpectBegin()
-	m.ExpectQuery("SELECT character_id FROM encounter_characters").WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"character_id"}).AddRow(7))
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(0, 0, false))
+	m.ExpectExec("SET held = NULL").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	m.ExpectQuery("SELECT ec.character_id, COALESCE").WithArgs(1).
+		WillReturnRows(turnOrderRows(7))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = FALSE").WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = TRUE").WithArgs(1, 7).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec("UPDATE encounters SET round").WithArgs(1, 0, true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec("DELETE FROM encounter_turn_history").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 	m.ExpectCommit()
 
 	rr, req := postJSON("/encounters/combat/start", `{"encounter_id":1}`)
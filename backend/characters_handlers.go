@@ -53,10 +53,21 @@ func apiCharactersHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch characters")
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	// An encounter roster also carries its initiative events (lair actions and
+	// the like), interleaved in turn order and told apart by their Kind.
+	if encounterID > 0 {
+		events, err := initiativeEventDAO.ListByEncounter(encounterID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch characters")
+			return
+		}
+		json.NewEncoder(w).Encode(dao.MergeTurnOrder(characters, events))
+		return
+	}
 	if characters == nil {
 		characters = []dao.Character{}
 	}
-	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(characters)
 }
 
@@ -102,6 +113,14 @@ func apiSaveLibraryCharacterHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "Invalid max HP value")
 		return
 	}
+	if err := char.DamageDefenses.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unknown damage type in resistances, vulnerabilities or immunities")
+		return
+	}
+	if err := char.Resources.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid resources: "+err.Error())
+		return
+	}
 	// Ownership is always the authenticated caller; never trust an owner_id
 	// supplied in the request body. Logged-out visitors may not create or edit
 	// characters — they only get a read-only sample.
@@ -189,6 +208,10 @@ func saveCharacterHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "Invalid max HP value")
 		return
 	}
+	if err := char.DamageDefenses.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unknown damage type in resistances, vulnerabilities or immunities")
+		return
+	}
 	if char.CurrentHP < 0 {
 		char.CurrentHP = 0
 	}
@@ -345,5 +368,7 @@ func removeCharacterFromEncounterHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	events.publish(encounterID, "character")
+	// Timed effects ticking on the combatant's turn were removed with it.
+	events.publish(encounterID, "effects")
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
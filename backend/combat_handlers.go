@@ -2,11 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go-initiative-tracker/dao"
 	"net/http"
+	"slices"
 	"strings"
 )
 
+// rollD20 rolls a d20 with rollDie, so tests have a single roller to stub.
+func rollD20() int { return rollDie(20) }
+
 func encounterIDFromRequest(r *http.Request) (int, error) {
 	var req struct {
 		EncounterID int `json:"encounter_id"`
@@ -20,6 +26,82 @@ func encounterIDFromRequest(r *http.Request) (int, error) {
 	return req.EncounterID, nil
 }
 
+// turnRequest is the body of next-turn and previous-turn. ExpectedActiveID,
+// when sent, is the active character id the client last saw (0 for none); a
+// mismatch is answered with writeTurnConflict instead of changing the turn.
+type turnRequest struct {
+	EncounterID      int  `json:"encounter_id"`
+	ExpectedActiveID *int `json:"expected_active_character_id"`
+}
+
+func turnRequestFromRequest(r *http.Request) (turnRequest, error) {
+	var req turnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, err
+	}
+	if req.EncounterID <= 0 {
+		return req, fmt.Errorf("invalid encounter id")
+	}
+	return req, nil
+}
+
+// turnResponse is the success envelope shared by the turn-changing endpoints:
+// who holds the turn now, plus the round and slot so clients can render
+// "Round 3, Goblin's turn" without re-fetching the encounter, and anyone the
+// skip policy passed over. active_event_id is added when an initiative event,
+// such as a lair action, holds the turn.
+func turnResponse(turn dao.TurnResult) map[string]any {
+	resp := map[string]any{
+		"status":              "success",
+		"active_character_id": turn.ActiveCharacterID,
+		"round":               turn.Round,
+		"turn_index":          turn.TurnIndex,
+	}
+	if turn.ActiveEventID != 0 {
+		resp["active_event_id"] = turn.ActiveEventID
+	}
+	if len(turn.Skipped) > 0 {
+		resp["skipped"] = turn.Skipped
+	}
+	if len(turn.Recharges) > 0 {
+		resp["recharges"] = turn.Recharges
+	}
+	if len(turn.ExpiredEffects) > 0 {
+		resp["expired_effects"] = turn.ExpiredEffects
+	}
+	return resp
+}
+
+// publishTurn announces a turn change, and an "effects" event as well when
+// encounter effects ticked or were put back.
+func publishTurn(encounterID int, turn dao.TurnResult) {
+	events.publish(encounterID, "combat")
+	if turn.EffectsChanged {
+		events.publish(encounterID, "effects")
+	}
+}
+
+// writeTurnConflict answers a turn change made against a stale view of combat:
+// the caller's expected_active_character_id no longer holds the turn, usually
+// because a co-DM got there first. The 409 carries the current turn in the
+// turnResponse shape so the client can resync without another request.
+// Returns false when err is not a conflict.
+func writeTurnConflict(w http.ResponseWriter, err error) bool {
+	var conflict *dao.TurnConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	resp := turnResponse(conflict.Current)
+	resp["status"] = "error"
+	resp["message"] = "The turn has changed since you last saw it"
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(resp)
+	return true
+}
+
+// apiStartCombatHandler opens round 1. Character ids listed in surprised lose
+// their first turn; the response's skipped lists any surprised combatants
+// passed over before the first active one.
 func apiStartCombatHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodPost {
@@ -27,27 +109,42 @@ func apiStartCombatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	encounterID, err := encounterIDFromRequest(r)
-	if err != nil {
+	var req struct {
+		EncounterID int   `json:"encounter_id"`
+		Surprised   []int `json:"surprised"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.EncounterID <= 0 {
 		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
-	if !requireEncounterAccess(w, r, encounterID) {
+	seen := make(map[int]bool, len(req.Surprised))
+	for _, id := range req.Surprised {
+		if id <= 0 || seen[id] {
+			writeJSONError(w, http.StatusBadRequest, "Invalid or duplicate character id")
+			return
+		}
+		seen[id] = true
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
 		return
 	}
 
-	activeCharacterID, err := encounterCharacterDAO.StartCombat(encounterID)
+	turn, err := encounterCharacterDAO.StartCombat(req.EncounterID, req.Surprised)
 	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "no characters") {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "no characters"):
 			writeJSONError(w, http.StatusBadRequest, "Encounter has no characters")
-			return
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to start combat")
 		}
-		writeJSONError(w, http.StatusInternalServerError, "Failed to start combat")
 		return
 	}
 
-	events.publish(encounterID, "combat")
-	json.NewEncoder(w).Encode(map[string]any{"status": "success", "active_character_id": activeCharacterID})
+	publishTurn(req.EncounterID, turn)
+	json.NewEncoder(w).Encode(turnResponse(turn))
 }
 
 func apiSetActiveHandler(w http.ResponseWriter, r *http.Request) {
@@ -58,8 +155,9 @@ func apiSetActiveHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		EncounterID int `json:"encounter_id"`
-		CharacterID int `json:"character_id"`
+		EncounterID      int  `json:"encounter_id"`
+		CharacterID      int  `json:"character_id"`
+		ExpectedActiveID *int `json:"expected_active_character_id"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
@@ -73,7 +171,10 @@ func apiSetActiveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := encounterCharacterDAO.SetActiveCharacter(req.EncounterID, req.CharacterID); err != nil {
+	if err := encounterCharacterDAO.SetActiveCharacter(req.EncounterID, req.CharacterID, req.ExpectedActiveID); err != nil {
+		if writeTurnConflict(w, err) {
+			return
+		}
 		if strings.Contains(strings.ToLower(err.Error()), "not in encounter") {
 			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
 			return
@@ -118,25 +219,357 @@ func apiNextTurnHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	encounterID, err := encounterIDFromRequest(r)
+	req, err := turnRequestFromRequest(r)
 	if err != nil {
 		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
-	if !requireEncounterAccess(w, r, encounterID) {
+	if !requireEncounterAccess(w, r, req.EncounterID) {
 		return
 	}
 
-	activeCharacterID, err := encounterCharacterDAO.AdvanceTurn(encounterID)
+	turn, err := encounterCharacterDAO.AdvanceTurn(req.EncounterID, req.ExpectedActiveID, rollDie)
 	if err != nil {
+		if writeTurnConflict(w, err) {
+			return
+		}
 		if strings.Contains(strings.ToLower(err.Error()), "no characters") {
 			writeJSONError(w, http.StatusBadRequest, "Encounter has no characters")
 			return
 		}
+		if strings.Contains(strings.ToLower(err.Error()), "everyone is holding") {
+			writeJSONError(w, http.StatusConflict, "Every combatant is delaying or readying")
+			return
+		}
 		writeJSONError(w, http.StatusInternalServerError, "Failed to advance turn")
 		return
 	}
 
-	events.publish(encounterID, "combat")
-	json.NewEncoder(w).Encode(map[string]any{"status": "success", "active_character_id": activeCharacterID})
+	publishTurn(req.EncounterID, turn)
+	json.NewEncoder(w).Encode(turnResponse(turn))
+}
+
+// apiPreviousTurnHandler rewinds the most recent next-turn (or delay, ready or
+// rejoin), for when the DM clicks it by mistake: the previous combatant is
+// active again, and the condition durations and initiative slots the change
+// touched are restored.
+func apiPreviousTurnHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	req, err := turnRequestFromRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	turn, err := encounterCharacterDAO.PreviousTurn(req.EncounterID, req.ExpectedActiveID)
+	if err != nil {
+		if writeTurnConflict(w, err) {
+			return
+		}
+		if strings.Contains(strings.ToLower(err.Error()), "no previous turn") {
+			writeJSONError(w, http.StatusConflict, "There is no turn to rewind")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to rewind turn")
+		return
+	}
+
+	publishTurn(req.EncounterID, turn)
+	json.NewEncoder(w).Encode(turnResponse(turn))
+}
+
+// apiTiebreakHandler stores the DM's order for combatants still tied after the
+// Dexterity comparison. character_ids lists them first to last; an empty list
+// clears the manual order.
+func apiTiebreakHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID  int   `json:"encounter_id"`
+		CharacterIDs []int `json:"character_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter id")
+		return
+	}
+	seen := make(map[int]bool, len(req.CharacterIDs))
+	for _, id := range req.CharacterIDs {
+		if id <= 0 || seen[id] {
+			writeJSONError(w, http.StatusBadRequest, "Invalid or duplicate character id")
+			return
+		}
+		seen[id] = true
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	if err := encounterCharacterDAO.SetTiebreakOrder(req.EncounterID, req.CharacterIDs); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not in encounter") {
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to set tiebreak order")
+		return
+	}
+
+	events.publish(req.EncounterID, "combat")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// apiRollInitiativeHandler rolls initiative server-side: d20 + Dexterity
+// modifier for every combatant, only NPCs, or only those with no initiative
+// yet (scope "all", "npcs" or "unset"; default "all"). Character ids listed in
+// advantage or disadvantage roll two d20s and keep the higher or lower.
+func apiRollInitiativeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID  int    `json:"encounter_id"`
+		Scope        string `json:"scope"`
+		Advantage    []int  `json:"advantage"`
+		Disadvantage []int  `json:"disadvantage"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter id")
+		return
+	}
+	scope := strings.ToLower(strings.TrimSpace(req.Scope))
+	if scope == "" {
+		scope = dao.InitiativeScopeAll
+	}
+	if !slices.Contains(dao.ValidInitiativeScopes, scope) {
+		writeJSONError(w, http.StatusBadRequest, "Scope must be all, npcs or unset")
+		return
+	}
+	modes, ok := rollModes(req.Advantage, req.Disadvantage)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "A combatant cannot have both advantage and disadvantage")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	rolls, err := encounterCharacterDAO.RollInitiative(req.EncounterID, dao.InitiativeRollOptions{
+		Scope:   scope,
+		Modes:   modes,
+		RollD20: rollD20,
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "no combatants") {
+			writeJSONError(w, http.StatusBadRequest, "No combatants to roll initiative for")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to roll initiative")
+		return
+	}
+
+	events.publish(req.EncounterID, "combat")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "rolls": rolls})
+}
+
+// apiDelayHandler takes a combatant out of the turn rotation to delay; see
+// handleHold.
+func apiDelayHandler(w http.ResponseWriter, r *http.Request) {
+	handleHold(w, r, dao.HoldDelay)
+}
+
+// apiReadyHandler takes a combatant out of the turn rotation with a readied
+// action; see handleHold.
+func apiReadyHandler(w http.ResponseWriter, r *http.Request) {
+	handleHold(w, r, dao.HoldReady)
+}
+
+// apiInitiativeGroupHandler turns group initiative on or off for the NPCs in
+// an encounter spawned from one template: grouped, they share one initiative
+// and take a single turn, each keeping its own HP and conditions.
+func apiInitiativeGroupHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int  `json:"encounter_id"`
+		TemplateID  int  `json:"npc_template_id"`
+		Grouped     bool `json:"grouped"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.TemplateID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or template id")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	if err := encounterCharacterDAO.SetInitiativeGroup(req.EncounterID, req.TemplateID, req.Grouped); err != nil {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "no npcs"):
+			writeJSONError(w, http.StatusNotFound, "No NPCs from that template in this encounter")
+		case strings.Contains(msg, "holding"):
+			writeJSONError(w, http.StatusConflict, "A group member is delaying or readying")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update initiative group")
+		}
+		return
+	}
+
+	events.publish(req.EncounterID, "combat")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// handleHold serves the delay and ready endpoints. The combatant stays out of
+// the rotation until /encounters/combat/rejoin; on their own turn, the turn
+// passes to the next combatant.
+func handleHold(w http.ResponseWriter, r *http.Request, hold string) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID      int  `json:"encounter_id"`
+		CharacterID      int  `json:"character_id"`
+		ExpectedActiveID *int `json:"expected_active_character_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	turn, err := encounterCharacterDAO.Hold(req.EncounterID, req.CharacterID, hold, req.ExpectedActiveID, rollDie)
+	if err != nil {
+		if writeTurnConflict(w, err) {
+			return
+		}
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+		case strings.Contains(msg, "already holding"):
+			writeJSONError(w, http.StatusConflict, "Character is already delaying or readying")
+		case strings.Contains(msg, "initiative group"):
+			writeJSONError(w, http.StatusConflict, "Grouped combatants act with their group")
+		case strings.Contains(msg, "everyone is holding"):
+			writeJSONError(w, http.StatusConflict, "No one else can take the turn")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to hold turn")
+		}
+		return
+	}
+
+	publishTurn(req.EncounterID, turn)
+	json.NewEncoder(w).Encode(turnResponse(turn))
+}
+
+// apiRejoinHandler brings a delaying or readying combatant back into the
+// rotation just before ("before", acting now) or after ("after") the current
+// actor.
+func apiRejoinHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID      int    `json:"encounter_id"`
+		CharacterID      int    `json:"character_id"`
+		Position         string `json:"position"`
+		ExpectedActiveID *int   `json:"expected_active_character_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	if req.Position != dao.RejoinBefore && req.Position != dao.RejoinAfter {
+		writeJSONError(w, http.StatusBadRequest, "Position must be before or after")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	turn, err := encounterCharacterDAO.Rejoin(req.EncounterID, req.CharacterID, req.Position, req.ExpectedActiveID, rollDie)
+	if err != nil {
+		if writeTurnConflict(w, err) {
+			return
+		}
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+		case strings.Contains(msg, "not holding"):
+			writeJSONError(w, http.StatusConflict, "Character is not delaying or readying")
+		case strings.Contains(msg, "no one holds the turn"):
+			writeJSONError(w, http.StatusConflict, "Combat is not under way")
+		case strings.Contains(msg, "initiative event holds the turn"):
+			writeJSONError(w, http.StatusConflict, "Cannot rejoin around an initiative event")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to rejoin initiative")
+		}
+		return
+	}
+
+	publishTurn(req.EncounterID, turn)
+	json.NewEncoder(w).Encode(turnResponse(turn))
+}
+
+// rollModes builds per-combatant roll modes from lists of ids rolling with
+// advantage and disadvantage, reporting false if an id is in both.
+func rollModes(advantage, disadvantage []int) (map[int]dao.RollMode, bool) {
+	modes := make(map[int]dao.RollMode, len(advantage)+len(disadvantage))
+	for _, id := range advantage {
+		modes[id] = dao.RollAdvantage
+	}
+	for _, id := range disadvantage {
+		if modes[id] == dao.RollAdvantage {
+			return nil, false
+		}
+		modes[id] = dao.RollDisadvantage
+	}
+	return modes, true
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"go-initiative-tracker/dao"
+	"net/http"
+	"strings"
+)
+
+// apiSetConcentrationHandler marks a combatant as concentrating on a spell,
+// optionally naming the condition rows (on other creatures) that the spell
+// sustains. Any earlier concentration ends first, taking its conditions with
+// it.
+func apiSetConcentrationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID  int    `json:"encounter_id"`
+		CharacterID  int    `json:"character_id"`
+		Spell        string `json:"spell"`
+		ConditionIDs []int  `json:"condition_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	spell := strings.TrimSpace(req.Spell)
+	if spell == "" {
+		writeJSONError(w, http.StatusBadRequest, "Spell is required")
+		return
+	}
+	if !uniquePositiveIDs(req.ConditionIDs) {
+		writeJSONError(w, http.StatusBadRequest, "Condition ids must be positive and unique")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	change, entry, err := encounterCharacterDAO.SetConcentration(req.EncounterID, req.CharacterID, dao.Concentration{
+		Spell:        spell,
+		ConditionIDs: req.ConditionIDs,
+	})
+	if err != nil {
+		writeConcentrationError(w, err)
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "concentration": change, "entry": entry})
+}
+
+// apiEndConcentrationHandler ends a combatant's concentration by choice,
+// removing the conditions the spell sustained.
+func apiEndConcentrationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int `json:"encounter_id"`
+		CharacterID int `json:"character_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	change, entry, err := encounterCharacterDAO.EndConcentration(req.EncounterID, req.CharacterID)
+	if err != nil {
+		writeConcentrationError(w, err)
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "concentration": change, "entry": entry})
+}
+
+// writeConcentrationError maps a concentration DAO error to a response.
+func writeConcentrationError(w http.ResponseWriter, err error) {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "not in encounter"):
+		writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+	case strings.Contains(msg, "unknown condition"):
+		writeJSONError(w, http.StatusBadRequest, "Condition not in encounter")
+	case strings.Contains(msg, "not concentrating"):
+		writeJSONError(w, http.StatusConflict, "Character is not concentrating")
+	default:
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update concentration")
+	}
+}
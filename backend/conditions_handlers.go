@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"go-initiative-tracker/dao"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -35,7 +37,7 @@ func apiAddConditionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	req.Condition = strings.TrimSpace(req.Condition)
-	if !dao.IsValidCondition(req.Condition) {
+	if req.Condition == "" {
 		writeJSONError(w, http.StatusBadRequest, "Unknown condition")
 		return
 	}
@@ -45,19 +47,26 @@ func apiAddConditionHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "Duration must be greater than 0")
 		return
 	}
-	// Leveled conditions (Exhaustion) require a level in range; every other
-	// condition must not carry one, so a stray level can't be stored and then
-	// rendered as "Prone 4".
-	if !dao.IsValidConditionLevel(req.Condition, req.Level) {
-		if max := dao.ConditionMaxLevel(req.Condition); max > 0 {
-			writeJSONError(w, http.StatusBadRequest,
-				fmt.Sprintf("%s requires a level between 1 and %d", req.Condition, max))
-		} else {
-			writeJSONError(w, http.StatusBadRequest, req.Condition+" does not have levels")
-		}
+	enc, ok := loadAccessibleEncounter(w, r, req.EncounterID)
+	if !ok {
 		return
 	}
-	if !requireEncounterAccess(w, r, req.EncounterID) {
+	// A canonical condition follows the encounter's ruleset; anything else
+	// must be one of the encounter owner's homebrew conditions.
+	info, canonical := dao.LookupCondition(enc.Ruleset, req.Condition)
+	if !canonical {
+		var err error
+		info, err = customConditionDAO.FindForEncounter(req.EncounterID, req.Condition)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				writeJSONError(w, http.StatusBadRequest, "Unknown condition")
+			} else {
+				writeJSONError(w, http.StatusInternalServerError, "Failed to look up condition")
+			}
+			return
+		}
+	}
+	if !checkConditionLevel(w, info, req.Level) {
 		return
 	}
 
@@ -77,6 +86,23 @@ func apiAddConditionHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
 
+// checkConditionLevel writes a 400 and returns false unless level suits the
+// condition. Leveled conditions (Exhaustion) require a level in range; every
+// other condition must not carry one, so a stray level can't be stored and
+// then rendered as "Prone 4".
+func checkConditionLevel(w http.ResponseWriter, info dao.ConditionInfo, level *int) bool {
+	if info.IsValidLevel(level) {
+		return true
+	}
+	if info.MaxLevel > 0 {
+		writeJSONError(w, http.StatusBadRequest,
+			fmt.Sprintf("%s requires a level between 1 and %d", info.Name, info.MaxLevel))
+	} else {
+		writeJSONError(w, http.StatusBadRequest, info.Name+" does not have levels")
+	}
+	return false
+}
+
 // apiRemoveConditionHandler clears a single condition by id, scoped to its
 // encounter so a stale id cannot delete a condition from another fight.
 func apiRemoveConditionHandler(w http.ResponseWriter, r *http.Request) {
@@ -118,12 +144,165 @@ func apiRemoveConditionHandler(w http.ResponseWriter, r *http.Request) {
 
 // apiConditionCatalogHandler returns the canonical set of conditions — name plus
 // level metadata — so the frontend can populate its picker, and know to prompt
-// for a level on Exhaustion, without hardcoding the list.
+// for a level on Exhaustion, without hardcoding the list. The caller's
+// homebrew conditions follow, and with ?encounter_id= so do those of the
+// encounter's owner, which are the ones apiAddConditionHandler accepts there.
+// ?ruleset= picks the 2014 or 2024 rules; without it the catalog follows the
+// encounter, or else the caller's preference.
 func apiConditionCatalogHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
 		return
 	}
+	ruleset := r.URL.Query().Get("ruleset")
+	if ruleset != "" && !slices.Contains(dao.ValidRulesets, ruleset) {
+		writeJSONError(w, http.StatusBadRequest, "Ruleset must be 2014 or 2024")
+		return
+	}
+	discordID := getDiscordIDFromRequest(r)
+	encounterID := 0
+	if raw := r.URL.Query().Get("encounter_id"); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid encounter id")
+			return
+		}
+		enc, ok := loadAccessibleEncounter(w, r, id)
+		if !ok {
+			return
+		}
+		encounterID = id
+		if ruleset == "" {
+			ruleset = enc.Ruleset
+		}
+	}
+	if ruleset == "" && discordID != "" {
+		preferred, err := userDAO.GetRuleset(discordID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load preferences")
+			return
+		}
+		ruleset = preferred
+	}
+
+	homebrew, err := customConditionDAO.ListVisible(encounterID, discordID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load homebrew conditions")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dao.MergeConditionCatalog(ruleset, homebrew))
+}
+
+// apiCustomConditionsHandler lists the caller's own homebrew conditions.
+func apiCustomConditionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	discordID := getDiscordIDFromRequest(r)
+	if discordID == "" {
+		writeJSONError(w, http.StatusUnauthorized, "You must be signed in to use homebrew conditions")
+		return
+	}
+
+	conditions, err := customConditionDAO.ListVisible(0, discordID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load homebrew conditions")
+		return
+	}
+	json.NewEncoder(w).Encode(conditions)
+}
+
+// apiSaveCustomConditionHandler creates a homebrew condition, or updates one
+// the caller owns when the body carries its ID.
+func apiSaveCustomConditionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var cond dao.CustomCondition
+	if err := json.NewDecoder(r.Body).Decode(&cond); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	cond.Name = strings.TrimSpace(cond.Name)
+	cond.Description = strings.TrimSpace(cond.Description)
+	if err := cond.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Ownership is always the authenticated caller; never trust a body value.
+	discordID := getDiscordIDFromRequest(r)
+	if discordID == "" {
+		writeJSONError(w, http.StatusUnauthorized, "You must be signed in to save homebrew conditions")
+		return
+	}
+	cond.OwnerID = discordID
+
+	if cond.ID > 0 {
+		updated, err := customConditionDAO.UpdateByOwner(cond, discordID)
+		if err != nil {
+			writeCustomConditionSaveError(w, err)
+			return
+		}
+		if !updated {
+			writeJSONError(w, http.StatusForbidden, "Condition not found or not owned by you")
+			return
+		}
+	} else {
+		id, err := customConditionDAO.Create(cond)
+		if err != nil {
+			writeCustomConditionSaveError(w, err)
+			return
+		}
+		cond.ID = id
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "condition": cond})
+}
+
+// writeCustomConditionSaveError maps a failed save, turning a clash with
+// another of the caller's conditions into a 409.
+func writeCustomConditionSaveError(w http.ResponseWriter, err error) {
+	if strings.Contains(err.Error(), "duplicate key") {
+		writeJSONError(w, http.StatusConflict, "You already have a condition with that name")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, "Failed to save condition")
+}
+
+// apiDeleteCustomConditionHandler deletes one of the caller's homebrew
+// conditions.
+func apiDeleteCustomConditionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(dao.ConditionCatalog())
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.ID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid condition id")
+		return
+	}
+
+	deleted, err := customConditionDAO.DeleteByOwner(req.ID, getDiscordIDFromRequest(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete condition")
+		return
+	}
+	if !deleted {
+		writeJSONError(w, http.StatusForbidden, "Condition not found or not owned by you")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"go-initiative-tracker/dao"
 	"net/http"
 	"testing"
@@ -8,24 +9,31 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
-// newConditionMock swaps encounterDAO and encounterConditionDAO for DAOs backed
-// by a shared sqlmock connection so an ownership check and a follow-on condition
-// write line up in order.
+// newConditionMock swaps encounterDAO, encounterConditionDAO and
+// customConditionDAO for DAOs backed by a shared sqlmock connection so an
+// ownership check and a follow-on condition write line up in order.
 func newConditionMock(t *testing.T) (sqlmock.Sqlmock, func()) {
 	t.Helper()
 	mockDB, m, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("failed to open mock database: %v", err)
 	}
-	prevEnc, prevCond := encounterDAO, encounterConditionDAO
+	prevEnc, prevCond, prevCustom := encounterDAO, encounterConditionDAO, customConditionDAO
 	encounterDAO = dao.NewEncounterDAO(mockDB)
 	encounterConditionDAO = dao.NewEncounterConditionDAO(mockDB)
+	customConditionDAO = dao.NewCustomConditionDAO(mockDB)
 	return m, func() {
-		encounterDAO, encounterConditionDAO = prevEnc, prevCond
+		encounterDAO, encounterConditionDAO, customConditionDAO = prevEnc, prevCond, prevCustom
 		mockDB.Close()
 	}
 }
 
+// customConditionRow is a homebrew condition as FindForEncounter reads it.
+func customConditionRow(name string, maxLevel int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "description", "max_level", "level_effects"}).
+		AddRow(name, "", maxLevel, "{}")
+}
+
 func TestAddConditionAllowsOwner(t *testing.T) {
 	m, restore := newConditionMock(t)
 	defer restore()
@@ -45,9 +53,13 @@ func TestAddConditionAllowsOwner(t *testing.T) {
 }
 
 func TestAddConditionRejectsUnknownCondition(t *testing.T) {
-	// Validation happens before any DB access, so no mock expectations are set.
+	// Neither canonical nor one of the encounter owner's homebrew conditions.
 	m, restore := newConditionMock(t)
 	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("FROM custom_conditions").WithArgs(1, "Sleepy").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "description", "max_level", "level_effects"}))
 
 	rr, req := postJSON("/encounters/conditions/add",
 		`{"encounter_id":1,"character_id":5,"condition":"Sleepy"}`)
@@ -58,6 +70,43 @@ func TestAddConditionRejectsUnknownCondition(t *testing.T) {
 	assertMet(t, m)
 }
 
+func TestAddConditionAcceptsOwnersHomebrew(t *testing.T) {
+	m, restore := newConditionMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("FROM custom_conditions").WithArgs(1, "Bleeding").
+		WillReturnRows(customConditionRow("Bleeding", 3))
+	m.ExpectExec("INSERT INTO encounter_character_conditions").
+		WithArgs(1, 5, "Bleeding", nil, 2, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr, req := postJSON("/encounters/conditions/add",
+		`{"encounter_id":1,"character_id":5,"condition":"Bleeding","level":2}`)
+	authed(req, "dm1")
+	apiAddConditionHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestAddConditionChecksHomebrewLevels(t *testing.T) {
+	m, restore := newConditionMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("FROM custom_conditions").WithArgs(1, "Marked").
+		WillReturnRows(customConditionRow("Marked", 0))
+
+	rr, req := postJSON("/encounters/conditions/add",
+		`{"encounter_id":1,"character_id":5,"condition":"Marked","level":1}`)
+	authed(req, "dm1")
+	apiAddConditionHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+	assertMet(t, m)
+}
+
 func TestAddConditionRejectsNonPositiveDuration(t *testing.T) {
 	m, restore := newConditionMock(t)
 	defer restore()
@@ -108,6 +157,9 @@ func TestAddExhaustionStoresLevel(t *testing.T) {
 func TestAddExhaustionRequiresLevel(t *testing.T) {
 	m, restore := newConditionMock(t)
 	defer restore()
+	// Levels follow the encounter's ruleset, so the encounter is loaded first.
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
 
 	rr, req := postJSON("/encounters/conditions/add",
 		`{"encounter_id":1,"character_id":5,"condition":"Exhaustion"}`)
@@ -126,6 +178,8 @@ func TestAddExhaustionRejectsOutOfRangeLevel(t *testing.T) {
 		`{"encounter_id":1,"character_id":5,"condition":"Exhaustion","level":0}`,
 		`{"encounter_id":1,"character_id":5,"condition":"Exhaustion","level":7}`,
 	} {
+		m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+			WillReturnRows(encounterRow(1, "dm1"))
 		rr, req := postJSON("/encounters/conditions/add", body)
 		authed(req, "dm1")
 		apiAddConditionHandler(rr, req)
@@ -139,6 +193,9 @@ func TestAddExhaustionRejectsOutOfRangeLevel(t *testing.T) {
 func TestAddConditionRejectsLevelOnBinaryCondition(t *testing.T) {
 	m, restore := newConditionMock(t)
 	defer restore()
+	// Levels follow the encounter's ruleset, so the encounter is loaded first.
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
 
 	rr, req := postJSON("/encounters/conditions/add",
 		`{"encounter_id":1,"character_id":5,"condition":"Prone","level":2}`)
@@ -184,10 +241,101 @@ func TestRemoveConditionNotFound(t *testing.T) {
 }
 
 func TestConditionCatalogReturnsList(t *testing.T) {
+	m, restore := newConditionMock(t)
+	defer restore()
+	m.ExpectQuery("FROM custom_conditions").WithArgs(0, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "name", "description", "max_level", "level_effects"}))
+
 	rr, req := getReq("/encounters/conditions/catalog")
 	apiConditionCatalogHandler(rr, req)
 	assertStatus(t, rr, http.StatusOK)
 	if len(dao.ValidConditions) == 0 {
 		t.Fatal("expected a non-empty condition catalog")
 	}
+	assertMet(t, m)
+}
+
+func TestConditionCatalogMergesEncounterOwnersHomebrew(t *testing.T) {
+	m, restore := newConditionMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("FROM custom_conditions").WithArgs(1, "dm1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "name", "description", "max_level", "level_effects"}).
+			AddRow(3, "dm1", "Marked", "Hunter's quarry", 0, "{}"))
+
+	rr, req := getReq("/encounters/conditions/catalog?encounter_id=1")
+	authed(req, "dm1")
+	apiConditionCatalogHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	var catalog []dao.ConditionInfo
+	if err := json.NewDecoder(rr.Body).Decode(&catalog); err != nil {
+		t.Fatalf("decode catalog: %v", err)
+	}
+	last := catalog[len(catalog)-1]
+	if len(catalog) != len(dao.ValidConditions)+1 || last.Name != "Marked" || !last.Homebrew {
+		t.Errorf("catalog ends with %+v (%d entries), want homebrew Marked appended", last, len(catalog))
+	}
+	assertMet(t, m)
+}
+
+func TestSaveCustomConditionRejectsCanonicalName(t *testing.T) {
+	rr, req := postJSON("/conditions/custom/save", `{"Name":"prone"}`)
+	authed(req, "dm1")
+	apiSaveCustomConditionHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSaveCustomConditionRequiresLogin(t *testing.T) {
+	rr, req := postJSON("/conditions/custom/save", `{"Name":"Marked"}`)
+	apiSaveCustomConditionHandler(rr, req)
+	assertStatus(t, rr, http.StatusUnauthorized)
+}
+
+func TestAddExhaustionUsesEncounterRuleset(t *testing.T) {
+	m, restore := newConditionMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "owner_id", "description", "round", "turn_index", "combat_started", "skip_policy", "ruleset"}).
+			AddRow(1, "Test Encounter", "dm1", "", 0, 0, false, "none", "2024"))
+	m.ExpectExec("INSERT INTO encounter_character_conditions").
+		WithArgs(1, 5, "Exhaustion", nil, 6, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr, req := postJSON("/encounters/conditions/add",
+		`{"encounter_id":1,"character_id":5,"condition":"Exhaustion","level":6}`)
+	authed(req, "dm1")
+	apiAddConditionHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestConditionCatalogTakesRuleset(t *testing.T) {
+	m, restore := newConditionMock(t)
+	defer restore()
+	m.ExpectQuery("FROM custom_conditions").WithArgs(0, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "name", "description", "max_level", "level_effects"}))
+
+	rr, req := getReq("/encounters/conditions/catalog?ruleset=2024")
+	apiConditionCatalogHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	var catalog []dao.ConditionInfo
+	if err := json.NewDecoder(rr.Body).Decode(&catalog); err != nil {
+		t.Fatalf("decode catalog: %v", err)
+	}
+	for _, info := range catalog {
+		if info.Name == "Exhaustion" && info.LevelMechanics[0].D20Penalty != 2 {
+			t.Errorf("2024 exhaustion level 1 = %+v, want a -2 d20 penalty", info.LevelMechanics[0])
+		}
+	}
+	assertMet(t, m)
+}
+
+func TestConditionCatalogRejectsUnknownRuleset(t *testing.T) {
+	rr, req := getReq("/encounters/conditions/catalog?ruleset=3.5")
+	apiConditionCatalogHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
 }
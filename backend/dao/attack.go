@@ -0,0 +1,171 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go-initiative-tracker/dice"
+)
+
+// LedgerAttack is the action type of an attack's ledger entry.
+const LedgerAttack = "attack"
+
+// Attack is one attack roll against each of TargetIDs: d20 + the attacker's
+// to-hit modifier against the target's armor class, then Damage on a hit.
+// RollD20 and RollDamage are the random sources, injected like
+// InitiativeRollOptions.RollD20; ConcentrationD20 is as for HPChange.
+type Attack struct {
+	EncounterID      int
+	AttackerID       int
+	TargetIDs        []int
+	Mode             RollMode
+	Damage           dice.Expression
+	DamageType       string // optional, one of ValidDamageTypes
+	RollD20          func() int
+	RollDamage       dice.Roller
+	ConcentrationD20 func() int
+}
+
+// AttackRoll is how an attack against one target went, and doubles as its
+// ledger entry's details. Dice holds both d20s when rolled with advantage or
+// disadvantage; Natural is the one that counted. Damage and Applied are only
+// set on a hit: the damage as rolled, and what the target's defenses and
+// temporary HP made of it.
+type AttackRoll struct {
+	Dice       []int          `json:"dice"`
+	Natural    int            `json:"natural"`
+	Modifier   int            `json:"modifier"`
+	Total      int            `json:"total"`
+	ArmorClass int            `json:"armor_class"`
+	Hit        bool           `json:"hit"`
+	Critical   bool           `json:"critical,omitempty"`
+	Damage     *dice.Result   `json:"damage,omitempty"`
+	Applied    *DamageDetails `json:"applied,omitempty"`
+}
+
+// AttackResult is one target's attack with its hit points either side of it
+// and the ledger entry that recorded it. Concentration is as for HPResult.
+type AttackResult struct {
+	TargetID   int    `json:"target_id"`
+	TargetName string `json:"target_name"`
+	AttackRoll
+	Before        int                  `json:"before"`
+	After         int                  `json:"after"`
+	TempBefore    int                  `json:"temp_before"`
+	TempAfter     int                  `json:"temp_after"`
+	Entry         EncounterLedgerEntry `json:"entry"`
+	Concentration *ConcentrationCheck  `json:"concentration,omitempty"`
+}
+
+// Attack resolves an attack against every target in one transaction. Each
+// target gets its own d20 and damage roll, following the 5e rules: a natural
+// 20 always hits and is a critical hit, rolling the damage dice twice; a
+// natural 1 always misses; otherwise the attack hits when the total meets the
+// target's AC. Damage lands like ApplyHP damage, through defenses and
+// temporary HP (checking the target's concentration), and every attack, hit or
+// miss, writes one ledger entry.
+// Targets are locked and resolved in id order, so two overlapping attacks
+// cannot deadlock.
+func (dao *encounterCharacterDAOImpl) Attack(attack Attack) ([]AttackResult, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var attackerName string
+	var modifier int
+	err = tx.QueryRow(
+		"SELECT c.name, c.to_hit_modifier FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND ec.character_id = $2",
+		attack.EncounterID, attack.AttackerID,
+	).Scan(&attackerName, &modifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("attacker not in encounter")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	targetIDs := slices.Clone(attack.TargetIDs)
+	slices.Sort(targetIDs)
+	results := make([]AttackResult, 0, len(targetIDs))
+	for _, targetID := range targetIDs {
+		target, err := lockHPTarget(tx, attack.EncounterID, targetID)
+		if err != nil {
+			return nil, err
+		}
+		roll := AttackRoll{Modifier: modifier, ArmorClass: target.armorClass}
+		roll.Dice, roll.Natural = rollD20Test(attack.Mode, attack.RollD20)
+		roll.Total = roll.Natural + modifier
+		roll.Critical = roll.Natural == 20
+		roll.Hit = roll.Critical || (roll.Natural != 1 && roll.Total >= target.armorClass)
+
+		description := describeAttackRoll(attackerName, target.name, roll)
+		if roll.Hit {
+			expr := attack.Damage
+			if roll.Critical {
+				expr = expr.Critical()
+			}
+			damage := expr.Roll(attack.RollDamage)
+			applied := target.takeDamage(damage.Total, attack.DamageType, roll.Critical)
+			roll.Damage, roll.Applied = &damage, &applied
+			description += ": " + describeDamage(target.name, applied, target.result)
+			if err := target.save(tx, attack.EncounterID); err != nil {
+				return nil, err
+			}
+		}
+
+		hp := target.result
+		entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+			EncounterID: attack.EncounterID,
+			ActorID:     attack.AttackerID,
+			TargetID:    targetID,
+			ActionType:  LedgerAttack,
+			HPChange:    hp.After - hp.Before,
+			Description: description,
+			Details:     roll,
+		})
+		if err != nil {
+			return nil, err
+		}
+		damageTaken := 0
+		if roll.Applied != nil {
+			damageTaken = roll.Applied.FinalDamage
+		}
+		concentration, err := checkConcentration(tx, attack.EncounterID, target, damageTaken, attack.ConcentrationD20)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, AttackResult{
+			TargetID:      targetID,
+			TargetName:    target.name,
+			AttackRoll:    roll,
+			Before:        hp.Before,
+			After:         hp.After,
+			TempBefore:    hp.TempBefore,
+			TempAfter:     hp.TempAfter,
+			Entry:         entry,
+			Concentration: concentration,
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// describeAttackRoll renders the to-hit half of an attack's ledger entry, e.g.
+// "Hero hits Goblin (17 vs AC 15)" or "Hero crits Goblin (natural 20)".
+func describeAttackRoll(attacker, target string, roll AttackRoll) string {
+	switch {
+	case roll.Critical:
+		return fmt.Sprintf("%s crits %s (natural 20)", attacker, target)
+	case roll.Natural == 1:
+		return fmt.Sprintf("%s misses %s (natural 1)", attacker, target)
+	case roll.Hit:
+		return fmt.Sprintf("%s hits %s (%d vs AC %d)", attacker, target, roll.Total, roll.ArmorClass)
+	}
+	return fmt.Sprintf("%s misses %s (%d vs AC %d)", attacker, target, roll.Total, roll.ArmorClass)
+}
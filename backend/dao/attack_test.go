@@ -0,0 +1,128 @@
+package dao
+
+import (
+	"testing"
+
+	"go-initiative-tracker/dice"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const selectAttackerQ = "SELECT c.name, c.to_hit_modifier FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND ec.character_id = $2"
+
+func attackerRow(name string, modifier int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "to_hit_modifier"}).AddRow(name, modifier)
+}
+
+func TestAttack_MissAndCriticalHitInTargetIDOrder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Target 5 comes first despite being listed second. With advantage it
+	// keeps a 6: 11 against AC 12 misses. Target 9 keeps a natural 20, so the
+	// 1d6+2 rolls as 2d6+2 and fire resistance halves the 11.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectAttackerQ)).WithArgs(7, 2).WillReturnRows(attackerRow("Hero", 5))
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(hpRow("Aragorn", 30, 20, 0))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 5, LedgerAttack, 0, "Hero misses Aragorn (11 vs AC 12)",
+			`{"dice":[3,6],"natural":6,"modifier":5,"total":11,"armor_class":12,"hit":false}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 9).
+		WillReturnRows(hpRowWithDefenses("Fire Elemental", 102, 60, 0, "{Fire}", "{}", "{}"))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(55, 0, 0, 0, false, false, 7, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 9, LedgerAttack, -5,
+			"Hero crits Fire Elemental (natural 20): Fire Elemental takes 5 fire damage (11 before resistance) (60 → 55)",
+			`{"dice":[20,1],"natural":20,"modifier":5,"total":25,"armor_class":12,"hit":true,"critical":true,`+
+				`"damage":{"expression":"2d6+2","terms":[{"term":"2d6","dice":[{"sides":6,"value":4,"kept":true},{"sides":6,"value":5,"kept":true}],"subtotal":9},{"term":"2","subtotal":2}],"total":11},`+
+				`"applied":{"damage_type":"Fire","raw_damage":11,"final_damage":5,"defenses":["resistant"]}}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	damage, err := dice.Parse("1d6+2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	damageRolls := []int{4, 5}
+	results, err := dao.Attack(Attack{
+		EncounterID: 7,
+		AttackerID:  2,
+		TargetIDs:   []int{9, 5},
+		Mode:        RollAdvantage,
+		Damage:      damage,
+		DamageType:  "Fire",
+		RollD20:     fixedD20(t, 3, 6, 20, 1),
+		RollDamage: func(int) int {
+			v := damageRolls[0]
+			damageRolls = damageRolls[1:]
+			return v
+		},
+	})
+	if err != nil {
+		t.Fatalf("Attack returned error: %v", err)
+	}
+	if len(results) != 2 || results[0].Hit || !results[1].Critical || results[1].After != 55 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAttack_NaturalOneMissesWhateverTheTotal(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectAttackerQ)).WithArgs(7, 2).WillReturnRows(attackerRow("Hero", 15))
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(hpRow("Goblin", 7, 7, 0))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 5, LedgerAttack, 0, "Hero misses Goblin (natural 1)", sqlmock.AnyArg()).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	damage, _ := dice.Parse("1d8")
+	results, err := dao.Attack(Attack{
+		EncounterID: 7, AttackerID: 2, TargetIDs: []int{5}, Damage: damage,
+		RollD20:    fixedD20(t, 1),
+		RollDamage: func(int) int { t.Fatal("a miss must not roll damage"); return 0 },
+	})
+	if err != nil {
+		t.Fatalf("Attack returned error: %v", err)
+	}
+	if results[0].Hit || results[0].After != 7 {
+		t.Errorf("unexpected result: %+v", results[0])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAttack_AttackerNotInEncounterRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectAttackerQ)).WithArgs(7, 99).WillReturnRows(sqlmock.NewRows([]string{"name", "to_hit_modifier"}))
+	mock.ExpectRollback()
+
+	if _, err := dao.Attack(Attack{EncounterID: 7, AttackerID: 99, TargetIDs: []int{5}}); err == nil {
+		t.Fatal("expected an error for an attacker outside the encounter")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package dao
 import (
 	"database/sql"
 	"errors"
+
+	"github.com/lib/pq"
 )
 
 type Character struct {
@@ -12,6 +14,9 @@ type Character struct {
 	ToHitModifier int
 	MaxHP         int
 	CurrentHP     int
+	// TempHP is the character's temporary hit points in an encounter; only set
+	// by GetCharactersByEncounterID.
+	TempHP        int `json:",omitempty"`
 	Initiative    int
 	IsActive      bool
 	OwnerID       string
@@ -21,6 +26,42 @@ type Character struct {
 	// Only populated by GetCharactersByEncounterID; nil (and omitted from JSON)
 	// for library/global queries that have no encounter scope.
 	Conditions []Condition `json:",omitempty"`
+	// Modifiers is the effective state those conditions put the character in,
+	// implied conditions included (see EffectiveConditions); nil without any.
+	Modifiers *ConditionModifiers `json:",omitempty"`
+	// Combat mirrors the encounter's round and turn position so the roster alone
+	// is enough to render "Round 3, Goblin's turn". Like Conditions it is only
+	// set by GetCharactersByEncounterID.
+	Combat *CombatState `json:",omitempty"`
+	// TurnRank, DexModifier and TiebreakOrder are the initiative tie-breakers
+	// (see compareTurnOrder); encounter-scoped like Combat.
+	TurnRank      int
+	DexModifier   int
+	TiebreakOrder *int `json:",omitempty"`
+	// Held is HoldDelay or HoldReady while the character is out of the turn
+	// rotation, empty otherwise.
+	Held string `json:",omitempty"`
+	// Surprised is set while the character is waiting out a surprise round.
+	Surprised bool `json:",omitempty"`
+	// InitiativeGroup is the NPC template id of the initiative group the
+	// character acts with in the encounter, 0 when it acts alone.
+	InitiativeGroup int `json:",omitempty"`
+	// Kind is KindCharacter in an encounter roster, telling characters apart
+	// from initiative events listed alongside them; empty elsewhere.
+	Kind string `json:",omitempty"`
+	// Concentration is the spell the character is concentrating on in an
+	// encounter, nil when none; encounter-scoped like Combat.
+	Concentration *Concentration `json:",omitempty"`
+	// DeathSaves is a player character's death save tally while it is at 0
+	// HP or dead; encounter-scoped like Combat.
+	DeathSaves *DeathSaves `json:",omitempty"`
+	// Legendary is the character's legendary actions and resistances left in
+	// an encounter, nil when it has neither; encounter-scoped like Combat.
+	Legendary *LegendaryCounters `json:",omitempty"`
+	// Resources are the character's limited-use resources: definitions on a
+	// library character, its own copies with uses left in an encounter roster.
+	Resources Resources `json:",omitempty"`
+	DamageDefenses
 }
 
 type CharacterDAO interface {
@@ -46,7 +87,7 @@ func NewCharacterDAO(db *sql.DB) CharacterDAO {
 }
 
 func (dao *characterDAOImpl) GetAllCharacters() ([]Character, error) {
-	rows, err := dao.db.Query("SELECT id, name, armor_class, to_hit_modifier, max_hp, max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(owner_id, ''), type, npc_template_id FROM characters")
+	rows, err := dao.db.Query("SELECT id, name, armor_class, to_hit_modifier, max_hp, max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(owner_id, ''), type, npc_template_id, damage_resistances, damage_vulnerabilities, damage_immunities, resources FROM characters")
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +96,7 @@ func (dao *characterDAOImpl) GetAllCharacters() ([]Character, error) {
 	var characters []Character
 	for rows.Next() {
 		var c Character
-		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID)
+		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID, pq.Array(&c.Resistances), pq.Array(&c.Vulnerabilities), pq.Array(&c.Immunities), &c.Resources)
 		if err != nil {
 			return nil, err
 		}
@@ -68,7 +109,7 @@ func (dao *characterDAOImpl) GetAllCharacters() ([]Character, error) {
 // seed rows with no owner), so logged-out visitors see a few examples rather
 // than every row in the database. Ordered by id for a stable sample.
 func (dao *characterDAOImpl) GetSampleCharacters() ([]Character, error) {
-	rows, err := dao.db.Query("SELECT id, name, armor_class, to_hit_modifier, max_hp, max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(owner_id, ''), type, npc_template_id FROM characters WHERE owner_id IS NULL OR owner_id = '' ORDER BY id")
+	rows, err := dao.db.Query("SELECT id, name, armor_class, to_hit_modifier, max_hp, max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(owner_id, ''), type, npc_template_id, damage_resistances, damage_vulnerabilities, damage_immunities, resources FROM characters WHERE owner_id IS NULL OR owner_id = '' ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -77,7 +118,7 @@ func (dao *characterDAOImpl) GetSampleCharacters() ([]Character, error) {
 	var characters []Character
 	for rows.Next() {
 		var c Character
-		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID)
+		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID, pq.Array(&c.Resistances), pq.Array(&c.Vulnerabilities), pq.Array(&c.Immunities), &c.Resources)
 		if err != nil {
 			return nil, err
 		}
@@ -88,7 +129,7 @@ func (dao *characterDAOImpl) GetSampleCharacters() ([]Character, error) {
 
 func (dao *characterDAOImpl) GetCharacterByID(id int) (Character, error) {
 	var c Character
-	err := dao.db.QueryRow("SELECT id, name, armor_class, to_hit_modifier, max_hp, max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(owner_id, ''), type, npc_template_id FROM characters WHERE id = $1", id).Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID)
+	err := dao.db.QueryRow("SELECT id, name, armor_class, to_hit_modifier, max_hp, max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(owner_id, ''), type, npc_template_id, damage_resistances, damage_vulnerabilities, damage_immunities, resources FROM characters WHERE id = $1", id).Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID, pq.Array(&c.Resistances), pq.Array(&c.Vulnerabilities), pq.Array(&c.Immunities), &c.Resources)
 	if err != nil {
 		return c, err
 	}
@@ -97,7 +138,7 @@ func (dao *characterDAOImpl) GetCharacterByID(id int) (Character, error) {
 
 func (dao *characterDAOImpl) GetCharactersByEncounterID(encounterID int) ([]Character, error) {
 	rows, err := dao.db.Query(
-		"SELECT c.id, c.name, c.armor_class, c.to_hit_modifier, c.max_hp, COALESCE(ec.current_hp, c.max_hp), COALESCE(ec.initiative, 0), COALESCE(ec.is_active, false), COALESCE(c.owner_id, ''), c.type, c.npc_template_id FROM characters c JOIN encounter_characters ec ON c.id = ec.character_id WHERE ec.encounter_id = $1",
+		"SELECT c.id, c.name, c.armor_class, c.to_hit_modifier, c.max_hp, COALESCE(ec.current_hp, c.max_hp), ec.temp_hp, COALESCE(ec.initiative, 0), COALESCE(ec.is_active, false), COALESCE(c.owner_id, ''), c.type, c.npc_template_id, e.round, e.turn_index, e.combat_started, ec.turn_rank, "+dexModifierSQL+", ec.tiebreak_order, COALESCE(ec.held, ''), "+groupSQL+", ec.surprised, c.damage_resistances, c.damage_vulnerabilities, c.damage_immunities, ec.concentration, ec.concentration_condition_ids, ec.death_save_successes, ec.death_save_failures, ec.stable, ec.dead, ec.legendary_actions, ec.legendary_actions_max, ec.legendary_resistances, ec.legendary_resistances_max, e.ruleset FROM characters c JOIN encounter_characters ec ON c.id = ec.character_id JOIN encounters e ON e.id = ec.encounter_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1",
 		encounterID,
 	)
 	if err != nil {
@@ -105,27 +146,49 @@ func (dao *characterDAOImpl) GetCharactersByEncounterID(encounterID int) ([]Char
 	}
 	defer rows.Close()
 	var characters []Character
+	var ruleset string
 	for rows.Next() {
-		var c Character
-		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID)
+		c := Character{Kind: KindCharacter}
+		var combat CombatState
+		var spell sql.NullString
+		var conditionIDs pq.Int64Array
+		var deathSaves DeathSaves
+		var legendary LegendaryCounters
+		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.TempHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, &c.NpcTemplateID, &combat.Round, &combat.TurnIndex, &combat.CombatStarted, &c.TurnRank, &c.DexModifier, &c.TiebreakOrder, &c.Held, &c.InitiativeGroup, &c.Surprised, pq.Array(&c.Resistances), pq.Array(&c.Vulnerabilities), pq.Array(&c.Immunities), &spell, &conditionIDs, &deathSaves.Successes, &deathSaves.Failures, &deathSaves.Stable, &deathSaves.Dead, &legendary.Actions, &legendary.ActionsMax, &legendary.Resistances, &legendary.ResistancesMax, &ruleset)
 		if err != nil {
 			return nil, err
 		}
+		c.Combat = &combat
+		c.Concentration = scanConcentration(spell, conditionIDs)
+		if c.Type == "pc" && (c.CurrentHP == 0 || deathSaves.Dead) {
+			c.DeathSaves = &deathSaves
+		}
+		c.Legendary = legendaryCounters(legendary)
 		characters = append(characters, c)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	if err := dao.attachConditions(encounterID, characters); err != nil {
+	// Listed in turn order, using the same comparison as the combat DAO.
+	sortByTurnOrder(characters, Character.turnSlot)
+	if err := dao.attachConditions(encounterID, ruleset, characters); err != nil {
+		return nil, err
+	}
+	if err := dao.attachResources(encounterID, characters); err != nil {
 		return nil, err
 	}
 	return characters, nil
 }
 
+func (c Character) turnSlot() turnSlot {
+	return turnSlot{CharacterID: c.ID, Initiative: c.Initiative, TurnRank: c.TurnRank, DexModifier: c.DexModifier, TiebreakOrder: c.TiebreakOrder, GroupID: c.InitiativeGroup}
+}
+
 // attachConditions loads every condition in the encounter in one query and
 // distributes them onto the matching characters by character_id, keeping the
 // character list a single round-trip rather than one query per character.
-func (dao *characterDAOImpl) attachConditions(encounterID int, characters []Character) error {
+// Their effects follow the encounter's ruleset.
+func (dao *characterDAOImpl) attachConditions(encounterID int, ruleset string, characters []Character) error {
 	if len(characters) == 0 {
 		return nil
 	}
@@ -151,6 +214,42 @@ func (dao *characterDAOImpl) attachConditions(encounterID int, characters []Char
 	}
 	for i := range characters {
 		characters[i].Conditions = byCharacter[characters[i].ID]
+		characters[i].Modifiers = EffectiveConditions(ruleset, characters[i].Conditions)
+	}
+	return nil
+}
+
+// attachResources loads the encounter's resource copies in one query, like
+// attachConditions, and hands them to their characters, marking whether each
+// recharge ability is available.
+func (dao *characterDAOImpl) attachResources(encounterID int, characters []Character) error {
+	if len(characters) == 0 {
+		return nil
+	}
+	rows, err := dao.db.Query(
+		"SELECT id, character_id, name, current_uses, max_uses, recharge, recharge_on FROM encounter_resources WHERE encounter_id = $1 ORDER BY character_id ASC, id ASC",
+		encounterID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byCharacter := make(map[int]Resources)
+	for rows.Next() {
+		var res Resource
+		var characterID int
+		if err := rows.Scan(&res.ID, &characterID, &res.Name, &res.Current, &res.Max, &res.Recharge, &res.RechargeOn); err != nil {
+			return err
+		}
+		res.markAvailability()
+		byCharacter[characterID] = append(byCharacter[characterID], res)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range characters {
+		characters[i].Resources = byCharacter[characters[i].ID]
 	}
 	return nil
 }
@@ -160,9 +259,11 @@ func (dao *characterDAOImpl) CreateCharacter(character Character) (int, error) {
 		character.Type = "pc"
 	}
 	var newID int
+	defenses := character.DamageDefenses.normalized()
 	err := dao.db.QueryRow(
-		"INSERT INTO characters (name, armor_class, to_hit_modifier, max_hp, owner_id, type) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		"INSERT INTO characters (name, armor_class, to_hit_modifier, max_hp, owner_id, type, damage_resistances, damage_vulnerabilities, damage_immunities, resources) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
 		character.Name, character.ArmorClass, character.ToHitModifier, character.MaxHP, character.OwnerID, character.Type,
+		pq.Array(defenses.Resistances), pq.Array(defenses.Vulnerabilities), pq.Array(defenses.Immunities), character.Resources,
 	).Scan(&newID)
 	return newID, err
 }
@@ -170,13 +271,17 @@ func (dao *characterDAOImpl) CreateCharacter(character Character) (int, error) {
 // UpdateCharacterByOwner updates a character only when it belongs to ownerID.
 // owner_id is intentionally not in the SET clause, so a caller can never
 // reassign a character to a different owner. Returns false when no row matched
-// (wrong id or not owned by the caller).
+// (wrong id or not owned by the caller). Damage defense lists and resources
+// left nil keep their stored values.
 func (dao *characterDAOImpl) UpdateCharacterByOwner(character Character, ownerID string) (bool, error) {
 	if character.Type == "" {
 		character.Type = "pc"
 	}
-	result, err := dao.db.Exec("UPDATE characters SET name = $1, armor_class = $2, to_hit_modifier = $3, max_hp = $4, type = $5 WHERE id = $6 AND owner_id = $7",
-		character.Name, character.ArmorClass, character.ToHitModifier, character.MaxHP, character.Type, character.ID, ownerID)
+	defenses := character.DamageDefenses
+	result, err := dao.db.Exec("UPDATE characters SET name = $1, armor_class = $2, to_hit_modifier = $3, max_hp = $4, type = $5, "+keepDefenseSQL(6)+", resources = COALESCE($9, resources) WHERE id = $10 AND owner_id = $11",
+		character.Name, character.ArmorClass, character.ToHitModifier, character.MaxHP, character.Type,
+		pq.Array(defenses.Resistances), pq.Array(defenses.Vulnerabilities), pq.Array(defenses.Immunities), character.Resources.orKeep(),
+		character.ID, ownerID)
 	if err != nil {
 		return false, err
 	}
@@ -189,17 +294,22 @@ func (dao *characterDAOImpl) UpdateCharacterByOwner(character Character, ownerID
 // character in it. Callers must verify the requester's encounter access first.
 // owner_id stays out of the SET clause, so an edit can never reassign a
 // character; the current owner is returned so callers can echo it back. Returns
-// false when the character is not in the encounter (or does not exist).
+// false when the character is not in the encounter (or does not exist). Like
+// UpdateCharacterByOwner, it keeps damage defense lists left nil.
 func (dao *characterDAOImpl) UpdateCharacterInEncounter(character Character, encounterID int) (string, bool, error) {
 	if character.Type == "" {
 		character.Type = "pc"
 	}
 	var ownerID string
+	defenses := character.DamageDefenses
 	err := dao.db.QueryRow(
-		`UPDATE characters c SET name = $1, armor_class = $2, to_hit_modifier = $3, max_hp = $4, type = $5
-		 WHERE c.id = $6 AND EXISTS (SELECT 1 FROM encounter_characters ec WHERE ec.character_id = c.id AND ec.encounter_id = $7)
+		`UPDATE characters c SET name = $1, armor_class = $2, to_hit_modifier = $3, max_hp = $4, type = $5,
+		 `+keepDefenseSQL(6)+`
+		 WHERE c.id = $9 AND EXISTS (SELECT 1 FROM encounter_characters ec WHERE ec.character_id = c.id AND ec.encounter_id = $10)
 		 RETURNING COALESCE(c.owner_id, '')`,
-		character.Name, character.ArmorClass, character.ToHitModifier, character.MaxHP, character.Type, character.ID, encounterID,
+		character.Name, character.ArmorClass, character.ToHitModifier, character.MaxHP, character.Type,
+		pq.Array(defenses.Resistances), pq.Array(defenses.Vulnerabilities), pq.Array(defenses.Immunities),
+		character.ID, encounterID,
 	).Scan(&ownerID)
 	if errors.Is(err, sql.ErrNoRows) {
 		return "", false, nil
@@ -226,7 +336,7 @@ func (dao *characterDAOImpl) DeleteCharacterByOwner(id int, ownerID string) (boo
 
 // Get all characters for a given Discord user
 func (dao *characterDAOImpl) GetAllCharactersByOwner(discordID string) ([]Character, error) {
-	rows, err := dao.db.Query(`SELECT c.id, c.name, c.armor_class, c.to_hit_modifier, c.max_hp, c.max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(c.owner_id, ''), c.type FROM characters c WHERE c.owner_id = $1`, discordID)
+	rows, err := dao.db.Query(`SELECT c.id, c.name, c.armor_class, c.to_hit_modifier, c.max_hp, c.max_hp AS current_hp, 0 AS initiative, false AS is_active, COALESCE(c.owner_id, ''), c.type, c.damage_resistances, c.damage_vulnerabilities, c.damage_immunities, c.resources FROM characters c WHERE c.owner_id = $1`, discordID)
 	if err != nil {
 		return nil, err
 	}
@@ -235,7 +345,7 @@ func (dao *characterDAOImpl) GetAllCharactersByOwner(discordID string) ([]Charac
 	var characters []Character
 	for rows.Next() {
 		var c Character
-		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type)
+		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, pq.Array(&c.Resistances), pq.Array(&c.Vulnerabilities), pq.Array(&c.Immunities), &c.Resources)
 		if err != nil {
 			return nil, err
 		}
@@ -246,7 +356,7 @@ func (dao *characterDAOImpl) GetAllCharactersByOwner(discordID string) ([]Charac
 
 // Get all characters for a given encounter and Discord user
 func (dao *characterDAOImpl) GetCharactersByEncounterIDAndOwner(encounterID int, discordID string) ([]Character, error) {
-	rows, err := dao.db.Query(`SELECT c.id, c.name, c.armor_class, c.to_hit_modifier, c.max_hp, COALESCE(ec.current_hp, c.max_hp), COALESCE(ec.initiative, 0), COALESCE(ec.is_active, false), COALESCE(c.owner_id, ''), c.type FROM characters c JOIN encounter_characters ec ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND c.owner_id = $2`, encounterID, discordID)
+	rows, err := dao.db.Query(`SELECT c.id, c.name, c.armor_class, c.to_hit_modifier, c.max_hp, COALESCE(ec.current_hp, c.max_hp), COALESCE(ec.initiative, 0), COALESCE(ec.is_active, false), COALESCE(c.owner_id, ''), c.type, c.damage_resistances, c.damage_vulnerabilities, c.damage_immunities FROM characters c JOIN encounter_characters ec ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND c.owner_id = $2`, encounterID, discordID)
 	if err != nil {
 		return nil, err
 	}
@@ -255,7 +365,7 @@ func (dao *characterDAOImpl) GetCharactersByEncounterIDAndOwner(encounterID int,
 	var characters []Character
 	for rows.Next() {
 		var c Character
-		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type)
+		err := rows.Scan(&c.ID, &c.Name, &c.ArmorClass, &c.ToHitModifier, &c.MaxHP, &c.CurrentHP, &c.Initiative, &c.IsActive, &c.OwnerID, &c.Type, pq.Array(&c.Resistances), pq.Array(&c.Vulnerabilities), pq.Array(&c.Immunities))
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,76 @@
+package dao
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestUpdatesKeepOmittedFields checks that a save leaving a field out writes
+// NULL into a COALESCE, keeping the stored value, rather than wiping it.
+func TestUpdatesKeepOmittedFields(t *testing.T) {
+	keepDefenses := "damage_resistances = COALESCE($6, damage_resistances), damage_vulnerabilities = COALESCE($7, damage_vulnerabilities), damage_immunities = COALESCE($8, damage_immunities)"
+	cases := []struct {
+		name  string
+		save  func(db *sql.DB) error
+		query string
+		args  []driver.Value
+		// returning is the row a RETURNING update yields, nil for a plain Exec.
+		returning *sqlmock.Rows
+	}{
+		{
+			"library character",
+			func(db *sql.DB) error {
+				_, err := NewCharacterDAO(db).UpdateCharacterByOwner(Character{ID: 3, Name: "Aragorn", MaxHP: 30}, "u1")
+				return err
+			},
+			"UPDATE characters SET name = $1, armor_class = $2, to_hit_modifier = $3, max_hp = $4, type = $5, " + keepDefenses + ", resources = COALESCE($9, resources) WHERE id = $10 AND owner_id = $11",
+			[]driver.Value{"Aragorn", 0, 0, 30, "pc", nil, nil, nil, nil, 3, "u1"},
+			nil,
+		},
+		{
+			"encounter character",
+			func(db *sql.DB) error {
+				_, _, err := NewCharacterDAO(db).UpdateCharacterInEncounter(Character{ID: 3, Name: "Aragorn", MaxHP: 30}, 7)
+				return err
+			},
+			keepDefenses,
+			[]driver.Value{"Aragorn", 0, 0, 30, "pc", nil, nil, nil, 3, 7},
+			sqlmock.NewRows([]string{"owner_id"}).AddRow("u1"),
+		},
+		{
+			"npc template",
+			func(db *sql.DB) error {
+				_, err := NewNpcTemplateDAO(db).UpdateByOwner(NpcTemplate{ID: 4, Name: "Goblin", MaxHP: 7}, "u1")
+				return err
+			},
+			"UPDATE npc_templates SET name = $1, description = $2, base_stats = $3, armor_class = $4, max_hp = $5, " + keepDefenses + ", legendary_actions = COALESCE($9, legendary_actions), legendary_resistances = COALESCE($10, legendary_resistances), resources = COALESCE($11, resources) WHERE id = $12 AND COALESCE(owner_id, '') = $13",
+			[]driver.Value{"Goblin", "", "(0,0,0,0,0,0)", 0, 7, nil, nil, nil, nil, nil, nil, 4, "u1"},
+			nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer db.Close()
+
+			if c.returning != nil {
+				mock.ExpectQuery(q(c.query)).WithArgs(c.args...).WillReturnRows(c.returning)
+			} else {
+				mock.ExpectExec(q(c.query)).WithArgs(c.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			if err := c.save(db); err != nil {
+				t.Fatalf("save returned error: %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet sqlmock expectations: %v", err)
+			}
+		})
+	}
+}
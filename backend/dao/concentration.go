@@ -0,0 +1,252 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// LedgerConcentration is the action type of ledger entries about
+// concentration: starting it, ending it and the saves that protect it.
+const LedgerConcentration = "concentration"
+
+// Concentration is the spell a combatant is concentrating on and the ids of
+// the conditions it sustains on other creatures, which end with it.
+type Concentration struct {
+	Spell        string `json:"spell"`
+	ConditionIDs []int  `json:"condition_ids,omitempty"`
+}
+
+// ConcentrationEnded is a concentration that has ended, with the condition
+// rows removed along with it.
+type ConcentrationEnded struct {
+	Spell               string `json:"spell"`
+	RemovedConditionIDs []int  `json:"removed_condition_ids"`
+}
+
+// ConcentrationChange is what SetConcentration or EndConcentration did: the
+// concentration now held, if any, and the one that ended, if any.
+type ConcentrationChange struct {
+	Concentration *Concentration      `json:"concentration,omitempty"`
+	Ended         *ConcentrationEnded `json:"ended,omitempty"`
+}
+
+// ConcentrationCheck is the Constitution save damage forces on a concentrating
+// combatant, against DC 10 or half the damage taken, whichever is higher. Save
+// is nil when the roll was left to the DM, and Reason explains a concentration
+// that ended without one. Ended is set when concentration was lost.
+type ConcentrationCheck struct {
+	Spell  string              `json:"spell"`
+	DC     int                 `json:"dc"`
+	Save   *SaveRoll           `json:"save,omitempty"`
+	Reason string              `json:"reason,omitempty"`
+	Ended  *ConcentrationEnded `json:"ended,omitempty"`
+}
+
+// SetConcentration marks a combatant as concentrating on spell, sustaining the
+// given conditions. As in 5e, starting a new concentration ends the old one,
+// taking its conditions with it. The change is logged to the ledger.
+func (dao *encounterCharacterDAOImpl) SetConcentration(encounterID, characterID int, concentration Concentration) (ConcentrationChange, EncounterLedgerEntry, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	defer tx.Rollback()
+
+	target, err := lockHPTarget(tx, encounterID, characterID)
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	if concentration.ConditionIDs == nil {
+		concentration.ConditionIDs = []int{}
+	}
+	var found int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM encounter_character_conditions WHERE encounter_id = $1 AND id = ANY($2)",
+		encounterID, pq.Array(concentration.ConditionIDs),
+	).Scan(&found); err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	if found != len(concentration.ConditionIDs) {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, fmt.Errorf("unknown condition in encounter")
+	}
+
+	change := ConcentrationChange{Concentration: &concentration}
+	description := fmt.Sprintf("%s starts concentrating on %s", target.name, concentration.Spell)
+	if target.concentration != nil {
+		ended, err := endConcentration(tx, encounterID, characterID, *target.concentration)
+		if err != nil {
+			return ConcentrationChange{}, EncounterLedgerEntry{}, err
+		}
+		change.Ended = &ended
+		description += fmt.Sprintf(", ending %s%s", ended.Spell, describeRemovedConditions(ended))
+	}
+	if _, err := tx.Exec(
+		"UPDATE encounter_characters SET concentration = $1, concentration_condition_ids = $2 WHERE encounter_id = $3 AND character_id = $4",
+		concentration.Spell, pq.Array(concentration.ConditionIDs), encounterID, characterID,
+	); err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActorID:     characterID,
+		ActionType:  LedgerConcentration,
+		Description: description,
+		Details:     change,
+	})
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	return change, entry, nil
+}
+
+// EndConcentration ends a combatant's concentration by choice, removing the
+// conditions it sustained, and logs it to the ledger.
+func (dao *encounterCharacterDAOImpl) EndConcentration(encounterID, characterID int) (ConcentrationChange, EncounterLedgerEntry, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	defer tx.Rollback()
+
+	target, err := lockHPTarget(tx, encounterID, characterID)
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	if target.concentration == nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, fmt.Errorf("character is not concentrating")
+	}
+	ended, err := endConcentration(tx, encounterID, characterID, *target.concentration)
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	change := ConcentrationChange{Ended: &ended}
+	entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActorID:     characterID,
+		ActionType:  LedgerConcentration,
+		Description: fmt.Sprintf("%s stops concentrating on %s%s", target.name, ended.Spell, describeRemovedConditions(ended)),
+		Details:     change,
+	})
+	if err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ConcentrationChange{}, EncounterLedgerEntry{}, err
+	}
+	return change, entry, nil
+}
+
+// checkConcentration makes a combatant that just took damage check its
+// concentration and logs the outcome. Dropping to 0 HP ends concentration
+// outright; otherwise rollD20 rolls the Constitution save or, when nil, the DC
+// is logged for the DM to roll. It returns nil when the combatant was not
+// concentrating or took no damage.
+func checkConcentration(tx *sql.Tx, encounterID int, target hpTarget, damage int, rollD20 func() int) (*ConcentrationCheck, error) {
+	if target.concentration == nil || damage <= 0 {
+		return nil, nil
+	}
+	characterID := target.result.CharacterID
+	check := &ConcentrationCheck{Spell: target.concentration.Spell, DC: max(10, damage/2)}
+	switch {
+	case target.result.After == 0:
+		check.Reason = "dropped to 0 HP"
+	case rollD20 != nil:
+		save, err := rollSave(tx, encounterID, characterID, "constitution", check.DC, RollNormal, rollD20)
+		if err != nil {
+			return nil, err
+		}
+		check.Save = &save
+	}
+	if check.Reason != "" || (check.Save != nil && !check.Save.Success) {
+		ended, err := endConcentration(tx, encounterID, characterID, *target.concentration)
+		if err != nil {
+			return nil, err
+		}
+		check.Ended = &ended
+	}
+	if _, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActorID:     characterID,
+		ActionType:  LedgerConcentration,
+		Description: describeConcentrationCheck(target.name, *check),
+		Details:     check,
+	}); err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+// endConcentration clears a combatant's concentration and deletes the
+// conditions it sustained, reporting the ones that were still there.
+func endConcentration(tx *sql.Tx, encounterID, characterID int, concentration Concentration) (ConcentrationEnded, error) {
+	ended := ConcentrationEnded{Spell: concentration.Spell, RemovedConditionIDs: []int{}}
+	if len(concentration.ConditionIDs) > 0 {
+		rows, err := tx.Query(
+			"DELETE FROM encounter_character_conditions WHERE encounter_id = $1 AND id = ANY($2) RETURNING id",
+			encounterID, pq.Array(concentration.ConditionIDs),
+		)
+		if err != nil {
+			return ConcentrationEnded{}, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return ConcentrationEnded{}, err
+			}
+			ended.RemovedConditionIDs = append(ended.RemovedConditionIDs, id)
+		}
+		if err := rows.Err(); err != nil {
+			return ConcentrationEnded{}, err
+		}
+	}
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET concentration = NULL, concentration_condition_ids = '{}' WHERE encounter_id = $1 AND character_id = $2",
+		encounterID, characterID,
+	)
+	return ended, err
+}
+
+// scanConcentration turns the concentration columns into a *Concentration,
+// nil when the combatant is not concentrating.
+func scanConcentration(spell sql.NullString, conditionIDs pq.Int64Array) *Concentration {
+	if !spell.Valid {
+		return nil
+	}
+	concentration := &Concentration{Spell: spell.String}
+	for _, id := range conditionIDs {
+		concentration.ConditionIDs = append(concentration.ConditionIDs, int(id))
+	}
+	return concentration
+}
+
+// describeConcentrationCheck renders a concentration check for the ledger,
+// e.g. "Wizard loses concentration on Hold Person (DC 11 Constitution save:
+// 7), ending 1 condition".
+func describeConcentrationCheck(name string, check ConcentrationCheck) string {
+	switch {
+	case check.Reason != "":
+		return fmt.Sprintf("%s loses concentration on %s (%s)%s", name, check.Spell, check.Reason, describeRemovedConditions(*check.Ended))
+	case check.Save == nil:
+		return fmt.Sprintf("%s must make a DC %d Constitution save to keep concentration on %s", name, check.DC, check.Spell)
+	case check.Ended != nil:
+		return fmt.Sprintf("%s loses concentration on %s (DC %d Constitution save: %d)%s", name, check.Spell, check.DC, check.Save.Total, describeRemovedConditions(*check.Ended))
+	}
+	return fmt.Sprintf("%s keeps concentration on %s (DC %d Constitution save: %d)", name, check.Spell, check.DC, check.Save.Total)
+}
+
+func describeRemovedConditions(ended ConcentrationEnded) string {
+	switch n := len(ended.RemovedConditionIDs); n {
+	case 0:
+		return ""
+	case 1:
+		return ", removing 1 condition"
+	default:
+		return fmt.Sprintf(", removing %d conditions", n)
+	}
+}
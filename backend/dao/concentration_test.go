@@ -0,0 +1,142 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	selectConSaveQ      = "SELECT FLOOR((COALESCE((c.stats).constitution, (t.base_stats).constitution, 10) - 10) / 2.0)::int, ARRAY(SELECT cc.condition FROM encounter_character_conditions cc WHERE cc.encounter_id = ec.encounter_id AND cc.character_id = ec.character_id ORDER BY cc.condition) FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1 AND ec.character_id = $2"
+	deleteSustainedQ    = "DELETE FROM encounter_character_conditions WHERE encounter_id = $1 AND id = ANY($2) RETURNING id"
+	clearConcentrationQ = "UPDATE encounter_characters SET concentration = NULL, concentration_condition_ids = '{}' WHERE encounter_id = $1 AND character_id = $2"
+)
+
+// concentratingRow is an hpRow for a combatant concentrating on spell, with
+// the sustained condition ids as a Postgres array literal.
+func concentratingRow(name string, maxHP, currentHP int, spell, conditionIDs string) *sqlmock.Rows {
+	return sqlmock.NewRows(hpColumns).AddRow(name, 12, maxHP, currentHP, 0, "{}", "{}", "{}", spell, conditionIDs, false, 0, 0, false, false)
+}
+
+func TestApplyHP_FailedConcentrationSaveEndsSustainedConditions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// 14 damage sets DC 10; 5 + 1 fails. Condition 12 was already removed by
+	// hand, so only 11 comes back from the delete.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 3).WillReturnRows(concentratingRow("Wizard", 30, 20, "Hold Person", "{11,12}"))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(6, 0, 0, 0, false, false, 7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 3, HPDamage, -14, "Wizard takes 14 damage (20 → 6)", `{"raw_damage":14,"final_damage":14}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectQuery(q(selectConSaveQ)).WithArgs(7, 3).WillReturnRows(saveRow(1, "{}"))
+	mock.ExpectQuery(q(deleteSustainedQ)).WithArgs(7, "{11,12}").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec(q(clearConcentrationQ)).WithArgs(7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 3, 0, LedgerConcentration, 0,
+			"Wizard loses concentration on Hold Person (DC 10 Constitution save: 6), removing 1 condition",
+			`{"spell":"Hold Person","dc":10,"save":{"ability":"constitution","dc":10,"dice":[5],"natural":5,"modifier":1,"total":6,"success":false},"ended":{"spell":"Hold Person","removed_condition_ids":[11]}}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 3, ActorID: 2, Kind: HPDamage, Amount: 14, ConcentrationD20: fixedD20(t, 5)})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.Concentration == nil || result.Concentration.Ended == nil {
+		t.Errorf("expected concentration to end, got %+v", result.Concentration)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_UnrolledConcentrationSaveIsLoggedForTheDM(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 3).WillReturnRows(concentratingRow("Wizard", 60, 50, "Fly", "{}"))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(26, 0, 0, 0, false, false, 7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 3, HPDamage, -24, "Wizard takes 24 damage (50 → 26)", sqlmock.AnyArg()).
+		WillReturnRows(ledgerRow())
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 3, 0, LedgerConcentration, 0,
+			"Wizard must make a DC 12 Constitution save to keep concentration on Fly", `{"spell":"Fly","dc":12}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 3, Kind: HPDamage, Amount: 24})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if check := result.Concentration; check == nil || check.DC != 12 || check.Ended != nil {
+		t.Errorf("unexpected concentration check: %+v", check)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSetConcentration_EndsThePreviousSpell(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 3).WillReturnRows(concentratingRow("Cleric", 30, 30, "Bless", "{4}"))
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM encounter_character_conditions WHERE encounter_id = $1 AND id = ANY($2)")).
+		WithArgs(7, "{11}").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(q(deleteSustainedQ)).WithArgs(7, "{4}").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec(q(clearConcentrationQ)).WithArgs(7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE encounter_characters SET concentration = $1, concentration_condition_ids = $2 WHERE encounter_id = $3 AND character_id = $4")).
+		WithArgs("Hold Person", "{11}", 7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 3, 0, LedgerConcentration, 0, "Cleric starts concentrating on Hold Person, ending Bless, removing 1 condition", sqlmock.AnyArg()).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	change, _, err := dao.SetConcentration(7, 3, Concentration{Spell: "Hold Person", ConditionIDs: []int{11}})
+	if err != nil {
+		t.Fatalf("SetConcentration returned error: %v", err)
+	}
+	if change.Ended == nil || change.Ended.Spell != "Bless" {
+		t.Errorf("expected Bless to end, got %+v", change.Ended)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestEndConcentration_NotConcentratingRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 3).WillReturnRows(hpRow("Fighter", 30, 30, 0))
+	mock.ExpectRollback()
+
+	if _, _, err := dao.EndConcentration(7, 3); err == nil {
+		t.Fatal("expected an error for a combatant that is not concentrating")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package dao
+
+import (
+	"maps"
+	"slices"
+)
+
+// ConditionEffects is the mechanical side of a condition, structured so
+// clients can apply it without re-implementing the 5e rules. Abilities in
+// AutoFailSaves and SaveDisadvantage are stat_block field names (see
+// Abilities). D20Penalty and SpeedPenalty (in feet) come from 2024 exhaustion.
+type ConditionEffects struct {
+	NoActions                   bool     `json:"NoActions,omitempty"`
+	NoReactions                 bool     `json:"NoReactions,omitempty"`
+	SpeedZero                   bool     `json:"SpeedZero,omitempty"`
+	SpeedHalved                 bool     `json:"SpeedHalved,omitempty"`
+	AttackAdvantage             bool     `json:"AttackAdvantage,omitempty"`             // on the creature's own attack rolls
+	AttackDisadvantage          bool     `json:"AttackDisadvantage,omitempty"`          // on the creature's own attack rolls
+	AttackersAdvantage          bool     `json:"AttackersAdvantage,omitempty"`          // on attack rolls against the creature
+	AttackersDisadvantage       bool     `json:"AttackersDisadvantage,omitempty"`       // on attack rolls against the creature
+	MeleeAttackersAdvantage     bool     `json:"MeleeAttackersAdvantage,omitempty"`     // attackers within 5 feet
+	RangedAttackersDisadvantage bool     `json:"RangedAttackersDisadvantage,omitempty"` // attackers farther away
+	MeleeHitsCrit               bool     `json:"MeleeHitsCrit,omitempty"`               // hits from within 5 feet are critical
+	AbilityCheckDisadvantage    bool     `json:"AbilityCheckDisadvantage,omitempty"`
+	AutoFailSaves               []string `json:"AutoFailSaves,omitempty"`
+	SaveDisadvantage            []string `json:"SaveDisadvantage,omitempty"`
+	ResistAllDamage             bool     `json:"ResistAllDamage,omitempty"`
+	HPMaxHalved                 bool     `json:"HPMaxHalved,omitempty"`
+	D20Penalty                  int      `json:"D20Penalty,omitempty"`
+	SpeedPenalty                int      `json:"SpeedPenalty,omitempty"`
+	Dead                        bool     `json:"Dead,omitempty"`
+}
+
+// conditionImplies lists the conditions that bring others with them: the
+// creature has the implied condition for as long as it has the first.
+var conditionImplies = map[string][]string{
+	"Paralyzed":   {"Incapacitated"},
+	"Petrified":   {"Incapacitated"},
+	"Stunned":     {"Incapacitated"},
+	"Unconscious": {"Incapacitated"},
+}
+
+var strDexSaves = []string{"strength", "dexterity"}
+
+// conditionEffects2014 is the 2014 rules effect table for every binary
+// condition. Charmed and Deafened only matter in ways the tracker cannot
+// model, so they have none.
+var conditionEffects2014 = map[string]ConditionEffects{
+	"Blinded":       {AttackDisadvantage: true, AttackersAdvantage: true},
+	"Frightened":    {AttackDisadvantage: true, AbilityCheckDisadvantage: true},
+	"Grappled":      {SpeedZero: true},
+	"Incapacitated": {NoActions: true, NoReactions: true},
+	"Invisible":     {AttackAdvantage: true, AttackersDisadvantage: true},
+	"Paralyzed":     {SpeedZero: true, AutoFailSaves: strDexSaves, AttackersAdvantage: true, MeleeHitsCrit: true},
+	"Petrified":     {SpeedZero: true, AutoFailSaves: strDexSaves, AttackersAdvantage: true, ResistAllDamage: true},
+	"Poisoned":      {AttackDisadvantage: true, AbilityCheckDisadvantage: true},
+	"Prone":         {AttackDisadvantage: true, MeleeAttackersAdvantage: true, RangedAttackersDisadvantage: true},
+	"Restrained":    {SpeedZero: true, AttackDisadvantage: true, AttackersAdvantage: true, SaveDisadvantage: []string{"dexterity"}},
+	"Stunned":       {SpeedZero: true, AutoFailSaves: strDexSaves, AttackersAdvantage: true},
+	"Unconscious":   {SpeedZero: true, AutoFailSaves: strDexSaves, AttackersAdvantage: true, MeleeHitsCrit: true},
+}
+
+// conditionEffects is the binary condition effect table per ruleset. The 2024
+// rules only change Grappled, which now also hampers the creature's attacks
+// against anyone but the grappler.
+var conditionEffects = map[string]map[string]ConditionEffects{
+	Ruleset2014: conditionEffects2014,
+	Ruleset2024: withEffects(conditionEffects2014, map[string]ConditionEffects{
+		"Grappled": {SpeedZero: true, AttackDisadvantage: true},
+	}),
+}
+
+// withEffects returns a copy of base with the given conditions replaced.
+func withEffects(base, changes map[string]ConditionEffects) map[string]ConditionEffects {
+	effects := maps.Clone(base)
+	maps.Copy(effects, changes)
+	return effects
+}
+
+// exhaustionLevelEffects is what each level of exhaustion adds under the 2014
+// rules, indexed by level - 1 like ExhaustionEffects. Levels are cumulative.
+var exhaustionLevelEffects = []ConditionEffects{
+	{AbilityCheckDisadvantage: true},
+	{SpeedHalved: true},
+	{AttackDisadvantage: true, SaveDisadvantage: Abilities},
+	{HPMaxHalved: true},
+	{SpeedZero: true},
+	{Dead: true},
+}
+
+// exhaustionMechanics returns the combined effects at each exhaustion level
+// under ruleset. The 2024 rules take 2 from every d20 test and 5 feet of speed
+// per level instead.
+func exhaustionMechanics(ruleset string) []ConditionEffects {
+	levels := make([]ConditionEffects, MaxExhaustionLevel)
+	var total ConditionEffects
+	for i, effects := range exhaustionLevelEffects {
+		if rulesetOrDefault(ruleset) == Ruleset2024 {
+			effects = ConditionEffects{D20Penalty: 2 * (i + 1), SpeedPenalty: 5 * (i + 1), Dead: i+1 == MaxExhaustionLevel}
+		}
+		total.merge(effects)
+		levels[i] = total.clone()
+	}
+	return levels
+}
+
+// effectsOf returns the effects of a condition at level under ruleset, and
+// false when the condition has none worth modeling (including every homebrew
+// condition).
+func effectsOf(ruleset, name string, level *int) (ConditionEffects, bool) {
+	if name == "Exhaustion" {
+		if level == nil || *level < 1 {
+			return ConditionEffects{}, false
+		}
+		return exhaustionMechanics(ruleset)[min(*level, MaxExhaustionLevel)-1], true
+	}
+	effects, ok := conditionEffects[rulesetOrDefault(ruleset)][name]
+	return effects, ok
+}
+
+// merge adds other's effects to e.
+func (e *ConditionEffects) merge(other ConditionEffects) {
+	e.NoActions = e.NoActions || other.NoActions
+	e.NoReactions = e.NoReactions || other.NoReactions
+	e.SpeedZero = e.SpeedZero || other.SpeedZero
+	e.SpeedHalved = e.SpeedHalved || other.SpeedHalved
+	e.AttackAdvantage = e.AttackAdvantage || other.AttackAdvantage
+	e.AttackDisadvantage = e.AttackDisadvantage || other.AttackDisadvantage
+	e.AttackersAdvantage = e.AttackersAdvantage || other.AttackersAdvantage
+	e.AttackersDisadvantage = e.AttackersDisadvantage || other.AttackersDisadvantage
+	e.MeleeAttackersAdvantage = e.MeleeAttackersAdvantage || other.MeleeAttackersAdvantage
+	e.RangedAttackersDisadvantage = e.RangedAttackersDisadvantage || other.RangedAttackersDisadvantage
+	e.MeleeHitsCrit = e.MeleeHitsCrit || other.MeleeHitsCrit
+	e.AbilityCheckDisadvantage = e.AbilityCheckDisadvantage || other.AbilityCheckDisadvantage
+	e.AutoFailSaves = unionAbilities(e.AutoFailSaves, other.AutoFailSaves)
+	e.SaveDisadvantage = unionAbilities(e.SaveDisadvantage, other.SaveDisadvantage)
+	e.ResistAllDamage = e.ResistAllDamage || other.ResistAllDamage
+	e.HPMaxHalved = e.HPMaxHalved || other.HPMaxHalved
+	e.D20Penalty = max(e.D20Penalty, other.D20Penalty)
+	e.SpeedPenalty = max(e.SpeedPenalty, other.SpeedPenalty)
+	e.Dead = e.Dead || other.Dead
+}
+
+// clone copies e so later merges cannot share its slices.
+func (e ConditionEffects) clone() ConditionEffects {
+	e.AutoFailSaves = slices.Clone(e.AutoFailSaves)
+	e.SaveDisadvantage = slices.Clone(e.SaveDisadvantage)
+	return e
+}
+
+// unionAbilities merges two ability lists, keeping stat_block order.
+func unionAbilities(a, b []string) []string {
+	if len(b) == 0 {
+		return a
+	}
+	var union []string
+	for _, ability := range Abilities {
+		if slices.Contains(a, ability) || slices.Contains(b, ability) {
+			union = append(union, ability)
+		}
+	}
+	return union
+}
+
+// Net roll modes once advantage and disadvantage have cancelled out.
+const (
+	ModeAdvantage    = "advantage"
+	ModeDisadvantage = "disadvantage"
+)
+
+// ConditionModifiers is a combatant's effective state under its conditions:
+// every condition it has, including implied ones, and their combined effects.
+// AttackRolls and AttacksAgainst are the net modes of its own attack rolls and
+// of attack rolls against it; as in 5e, advantage and disadvantage cancel, so
+// they are empty when neither or both apply. The melee and ranged refinements
+// from Prone are left to the flags.
+type ConditionModifiers struct {
+	Conditions []string `json:"Conditions"`
+	ConditionEffects
+	AttackRolls    string `json:"AttackRolls,omitempty"`
+	AttacksAgainst string `json:"AttacksAgainst,omitempty"`
+}
+
+// EffectiveConditions works out a combatant's effective conditions and
+// modifiers under ruleset from the conditions applied to it. It returns nil
+// when there are none.
+func EffectiveConditions(ruleset string, conditions []Condition) *ConditionModifiers {
+	if len(conditions) == 0 {
+		return nil
+	}
+	modifiers := &ConditionModifiers{}
+	levels := make(map[string]*int, len(conditions))
+	for _, cond := range conditions {
+		levels[cond.Condition] = cond.Level
+		modifiers.Conditions = append(modifiers.Conditions, cond.Condition)
+		for _, implied := range conditionImplies[cond.Condition] {
+			if _, ok := levels[implied]; !ok {
+				levels[implied] = nil
+				modifiers.Conditions = append(modifiers.Conditions, implied)
+			}
+		}
+	}
+	slices.Sort(modifiers.Conditions)
+	modifiers.Conditions = slices.Compact(modifiers.Conditions)
+	for _, name := range modifiers.Conditions {
+		if effects, ok := effectsOf(ruleset, name, levels[name]); ok {
+			modifiers.merge(effects)
+		}
+	}
+	modifiers.AttackRolls = netMode(modifiers.AttackAdvantage, modifiers.AttackDisadvantage)
+	modifiers.AttacksAgainst = netMode(modifiers.AttackersAdvantage, modifiers.AttackersDisadvantage)
+	return modifiers
+}
+
+// netMode combines advantage and disadvantage into a single roll mode.
+func netMode(advantage, disadvantage bool) string {
+	switch {
+	case advantage && !disadvantage:
+		return ModeAdvantage
+	case disadvantage && !advantage:
+		return ModeDisadvantage
+	}
+	return ""
+}
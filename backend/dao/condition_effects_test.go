@@ -0,0 +1,83 @@
+package dao
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEffectiveConditions_AddsImpliedConditions(t *testing.T) {
+	modifiers := EffectiveConditions(Ruleset2014, []Condition{{Condition: "Stunned"}, {Condition: "Prone"}})
+	if want := []string{"Incapacitated", "Prone", "Stunned"}; !slices.Equal(modifiers.Conditions, want) {
+		t.Errorf("Conditions = %v, want %v", modifiers.Conditions, want)
+	}
+	if !modifiers.NoActions || !modifiers.NoReactions || !modifiers.SpeedZero {
+		t.Errorf("expected Incapacitated and Stunned effects, got %+v", modifiers.ConditionEffects)
+	}
+	if want := []string{"strength", "dexterity"}; !slices.Equal(modifiers.AutoFailSaves, want) {
+		t.Errorf("AutoFailSaves = %v, want %v", modifiers.AutoFailSaves, want)
+	}
+	if modifiers.AttackRolls != ModeDisadvantage || modifiers.AttacksAgainst != ModeAdvantage {
+		t.Errorf("modes = %q/%q, want disadvantage/advantage", modifiers.AttackRolls, modifiers.AttacksAgainst)
+	}
+}
+
+func TestEffectiveConditions_AdvantageAndDisadvantageCancel(t *testing.T) {
+	// Invisible grants advantage on attacks, Poisoned imposes disadvantage.
+	modifiers := EffectiveConditions(Ruleset2014, []Condition{{Condition: "Invisible"}, {Condition: "Poisoned"}})
+	if modifiers.AttackRolls != "" {
+		t.Errorf("AttackRolls = %q, want none", modifiers.AttackRolls)
+	}
+	if modifiers.AttacksAgainst != ModeDisadvantage {
+		t.Errorf("AttacksAgainst = %q, want disadvantage", modifiers.AttacksAgainst)
+	}
+}
+
+func TestEffectiveConditions_ExhaustionIsCumulative(t *testing.T) {
+	level := 3
+	modifiers := EffectiveConditions(Ruleset2014, []Condition{{Condition: "Exhaustion", Level: &level}, {Condition: "Marked"}})
+	if !modifiers.AbilityCheckDisadvantage || !modifiers.SpeedHalved || !modifiers.AttackDisadvantage || modifiers.HPMaxHalved {
+		t.Errorf("level 3 effects = %+v", modifiers.ConditionEffects)
+	}
+	if !slices.Equal(modifiers.SaveDisadvantage, Abilities) {
+		t.Errorf("SaveDisadvantage = %v, want every ability", modifiers.SaveDisadvantage)
+	}
+	if want := []string{"Exhaustion", "Marked"}; !slices.Equal(modifiers.Conditions, want) {
+		t.Errorf("Conditions = %v, want %v", modifiers.Conditions, want)
+	}
+}
+
+func TestEffectiveConditions_NoneIsNil(t *testing.T) {
+	if modifiers := EffectiveConditions(Ruleset2014, nil); modifiers != nil {
+		t.Errorf("EffectiveConditions(Ruleset2014, nil) = %+v, want nil", modifiers)
+	}
+}
+
+func TestConditionCatalog_IncludesMechanics(t *testing.T) {
+	for _, info := range ConditionCatalog(Ruleset2014) {
+		switch info.Name {
+		case "Paralyzed":
+			if !slices.Equal(info.Implies, []string{"Incapacitated"}) || info.Effects == nil || !info.Effects.MeleeHitsCrit {
+				t.Errorf("Paralyzed = %+v", info)
+			}
+		case "Exhaustion":
+			if len(info.LevelMechanics) != MaxExhaustionLevel || !info.LevelMechanics[5].Dead || info.LevelMechanics[0].SpeedHalved {
+				t.Errorf("Exhaustion mechanics = %+v", info.LevelMechanics)
+			}
+		}
+	}
+}
+
+func TestEffectiveConditions_2024ExhaustionIsAFlatPenalty(t *testing.T) {
+	level := 3
+	modifiers := EffectiveConditions(Ruleset2024, []Condition{{Condition: "Exhaustion", Level: &level}, {Condition: "Grappled"}})
+	if modifiers.D20Penalty != 6 || modifiers.SpeedPenalty != 15 {
+		t.Errorf("penalties = -%d to d20 tests, -%d ft, want -6 and -15", modifiers.D20Penalty, modifiers.SpeedPenalty)
+	}
+	if modifiers.SpeedHalved || len(modifiers.SaveDisadvantage) > 0 {
+		t.Errorf("2014 exhaustion effects leaked into 2024: %+v", modifiers.ConditionEffects)
+	}
+	// 2024 Grappled also imposes disadvantage on the grappled creature's attacks.
+	if modifiers.AttackRolls != ModeDisadvantage {
+		t.Errorf("AttackRolls = %q, want disadvantage", modifiers.AttackRolls)
+	}
+}
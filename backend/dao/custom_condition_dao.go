@@ -0,0 +1,155 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MaxCustomConditionLevel caps how many levels a homebrew condition may track.
+const MaxCustomConditionLevel = 10
+
+// CustomCondition is a user's homebrew condition. It is visible to its owner
+// and, through the catalog, to everyone in an encounter the owner runs.
+// MaxLevel is 0 for a binary condition; LevelEffects optionally describes each
+// level, indexed by level - 1, like ExhaustionEffects.
+type CustomCondition struct {
+	ID           int      `json:"ID"`
+	OwnerID      string   `json:"OwnerID"`
+	Name         string   `json:"Name"`
+	Description  string   `json:"Description"`
+	MaxLevel     int      `json:"MaxLevel"`
+	LevelEffects []string `json:"LevelEffects"`
+}
+
+// Validate checks a homebrew condition before it is stored. Names may not
+// shadow a canonical condition, whatever their case.
+func (c CustomCondition) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("condition name is required")
+	}
+	if slices.ContainsFunc(ValidConditions, func(name string) bool { return strings.EqualFold(name, c.Name) }) {
+		return fmt.Errorf("%s is already a 5e condition", c.Name)
+	}
+	if c.MaxLevel < 0 || c.MaxLevel > MaxCustomConditionLevel {
+		return fmt.Errorf("max level must be between 0 and %d", MaxCustomConditionLevel)
+	}
+	if len(c.LevelEffects) > c.MaxLevel {
+		return fmt.Errorf("level effects must not outnumber the levels")
+	}
+	return nil
+}
+
+// Info returns the condition's catalog entry.
+func (c CustomCondition) Info() ConditionInfo {
+	return ConditionInfo{
+		Name:         c.Name,
+		Description:  c.Description,
+		MaxLevel:     c.MaxLevel,
+		LevelEffects: c.LevelEffects,
+		Homebrew:     true,
+	}
+}
+
+type CustomConditionDAO interface {
+	ListVisible(encounterID int, userID string) ([]CustomCondition, error)
+	FindForEncounter(encounterID int, name string) (ConditionInfo, error)
+	Create(cond CustomCondition) (int, error)
+	UpdateByOwner(cond CustomCondition, ownerID string) (bool, error)
+	DeleteByOwner(id int, ownerID string) (bool, error)
+}
+
+type customConditionDAOImpl struct {
+	db *sql.DB
+}
+
+func NewCustomConditionDAO(db *sql.DB) CustomConditionDAO {
+	return &customConditionDAOImpl{db: db}
+}
+
+// ListVisible returns userID's homebrew conditions, plus those of the owner
+// of encounterID when it is not 0, sorted by name. When both define the same
+// name the encounter owner's wins, since that is the one the encounter accepts.
+func (dao *customConditionDAOImpl) ListVisible(encounterID int, userID string) ([]CustomCondition, error) {
+	rows, err := dao.db.Query(
+		"SELECT DISTINCT ON (name) id, owner_id, name, description, max_level, level_effects FROM custom_conditions WHERE owner_id = $2 OR owner_id = (SELECT owner_id FROM encounters WHERE id = $1) ORDER BY name, owner_id = $2",
+		encounterID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conditions := []CustomCondition{}
+	for rows.Next() {
+		var c CustomCondition
+		if err := rows.Scan(&c.ID, &c.OwnerID, &c.Name, &c.Description, &c.MaxLevel, pq.Array(&c.LevelEffects)); err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, rows.Err()
+}
+
+// FindForEncounter returns the catalog entry for the named homebrew condition
+// of the encounter's owner, or a "condition not found" error.
+func (dao *customConditionDAOImpl) FindForEncounter(encounterID int, name string) (ConditionInfo, error) {
+	var c CustomCondition
+	err := dao.db.QueryRow(
+		"SELECT cc.name, cc.description, cc.max_level, cc.level_effects FROM custom_conditions cc JOIN encounters e ON e.owner_id = cc.owner_id WHERE e.id = $1 AND cc.name = $2",
+		encounterID, name,
+	).Scan(&c.Name, &c.Description, &c.MaxLevel, pq.Array(&c.LevelEffects))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ConditionInfo{}, fmt.Errorf("condition not found")
+	}
+	if err != nil {
+		return ConditionInfo{}, err
+	}
+	return c.Info(), nil
+}
+
+func (dao *customConditionDAOImpl) Create(cond CustomCondition) (int, error) {
+	var id int
+	err := dao.db.QueryRow(
+		"INSERT INTO custom_conditions (owner_id, name, description, max_level, level_effects) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		cond.OwnerID, cond.Name, cond.Description, cond.MaxLevel, pq.Array(levelEffects(cond.LevelEffects)),
+	).Scan(&id)
+	return id, err
+}
+
+// UpdateByOwner updates a homebrew condition only when it belongs to ownerID.
+// Returns false when no row matched.
+func (dao *customConditionDAOImpl) UpdateByOwner(cond CustomCondition, ownerID string) (bool, error) {
+	result, err := dao.db.Exec(
+		"UPDATE custom_conditions SET name = $1, description = $2, max_level = $3, level_effects = $4 WHERE id = $5 AND owner_id = $6",
+		cond.Name, cond.Description, cond.MaxLevel, pq.Array(levelEffects(cond.LevelEffects)), cond.ID, ownerID,
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// DeleteByOwner removes a homebrew condition owned by ownerID. Conditions
+// already applied in encounters stay, as plain names.
+func (dao *customConditionDAOImpl) DeleteByOwner(id int, ownerID string) (bool, error) {
+	result, err := dao.db.Exec("DELETE FROM custom_conditions WHERE id = $1 AND owner_id = $2", id, ownerID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// levelEffects stores a nil list as '{}' in the NOT NULL column.
+func levelEffects(effects []string) []string {
+	if effects == nil {
+		return []string{}
+	}
+	return effects
+}
@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCustomConditionValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		cond CustomCondition
+		ok   bool
+	}{
+		{"binary", CustomCondition{Name: "Marked"}, true},
+		{"leveled", CustomCondition{Name: "Bleeding", MaxLevel: 3, LevelEffects: []string{"1d4", "2d4"}}, true},
+		{"missing name", CustomCondition{}, false},
+		{"shadows a 5e condition", CustomCondition{Name: "poisoned"}, false},
+		{"too many levels", CustomCondition{Name: "Doom", MaxLevel: MaxCustomConditionLevel + 1}, false},
+		{"effects past the max level", CustomCondition{Name: "Marked", LevelEffects: []string{"-1 AC"}}, false},
+	}
+	for _, c := range cases {
+		if err := c.cond.Validate(); (err == nil) != c.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestMergeConditionCatalog_SkipsTakenNames(t *testing.T) {
+	catalog := MergeConditionCatalog(Ruleset2014, []CustomCondition{
+		{Name: "Marked", Description: "Hunter's quarry"},
+		{Name: "PRONE"},
+	})
+	if len(catalog) != len(ValidConditions)+1 {
+		t.Fatalf("len(catalog) = %d, want %d", len(catalog), len(ValidConditions)+1)
+	}
+	if got := catalog[len(catalog)-1]; got.Name != "Marked" || !got.Homebrew || got.Description != "Hunter's quarry" {
+		t.Errorf("last entry = %+v, want homebrew Marked", got)
+	}
+}
+
+func TestCustomConditionListVisible_IncludesEncounterOwner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewCustomConditionDAO(db)
+
+	mock.ExpectQuery(q("SELECT DISTINCT ON (name) id, owner_id, name, description, max_level, level_effects FROM custom_conditions WHERE owner_id = $2 OR owner_id = (SELECT owner_id FROM encounters WHERE id = $1) ORDER BY name, owner_id = $2")).
+		WithArgs(7, "player1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "name", "description", "max_level", "level_effects"}).
+			AddRow(2, "dm1", "Bleeding", "", 3, "{1d4,2d4,3d4}").
+			AddRow(5, "player1", "Marked", "", 0, "{}"))
+
+	conditions, err := dao.ListVisible(7, "player1")
+	if err != nil {
+		t.Fatalf("ListVisible returned error: %v", err)
+	}
+	if len(conditions) != 2 || conditions[0].OwnerID != "dm1" || len(conditions[0].LevelEffects) != 3 {
+		t.Errorf("conditions = %+v", conditions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCustomConditionFindForEncounter_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewCustomConditionDAO(db)
+
+	mock.ExpectQuery(q("SELECT cc.name, cc.description, cc.max_level, cc.level_effects FROM custom_conditions cc JOIN encounters e ON e.owner_id = cc.owner_id WHERE e.id = $1 AND cc.name = $2")).
+		WithArgs(7, "Sleepy").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "description", "max_level", "level_effects"}))
+
+	if _, err := dao.FindForEncounter(7, "Sleepy"); err == nil || err.Error() != "condition not found" {
+		t.Errorf("err = %v, want condition not found", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCustomConditionCreate_StoresEmptyEffects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewCustomConditionDAO(db)
+
+	mock.ExpectQuery(q("INSERT INTO custom_conditions (owner_id, name, description, max_level, level_effects) VALUES ($1, $2, $3, $4, $5) RETURNING id")).
+		WithArgs("dm1", "Marked", "", 0, "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+	id, err := dao.Create(CustomCondition{OwnerID: "dm1", Name: "Marked"})
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	if id != 4 {
+		t.Errorf("id = %d, want 4", id)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package dao
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ValidDamageTypes is the canonical D&D 5e damage type set. Like
+// ValidConditions, handlers validate incoming names against it so resistances
+// and damage rolls always speak the same vocabulary.
+var ValidDamageTypes = []string{
+	"Acid",
+	"Bludgeoning",
+	"Cold",
+	"Fire",
+	"Force",
+	"Lightning",
+	"Necrotic",
+	"Piercing",
+	"Poison",
+	"Psychic",
+	"Radiant",
+	"Slashing",
+	"Thunder",
+}
+
+// IsValidDamageType reports whether name is one of ValidDamageTypes.
+func IsValidDamageType(name string) bool {
+	return slices.Contains(ValidDamageTypes, name)
+}
+
+// DamageTypeCatalog returns every valid damage type, for the frontend's
+// pickers.
+func DamageTypeCatalog() []string {
+	return slices.Clone(ValidDamageTypes)
+}
+
+// Which defense changed a damage roll, as recorded in the ledger.
+const (
+	DefenseImmune     = "immune"
+	DefenseResistant  = "resistant"
+	DefenseVulnerable = "vulnerable"
+)
+
+// DamageDefenses lists the damage types a creature resists, is vulnerable to
+// and is immune to. NPC templates carry them and spawned NPCs get a copy, so a
+// DM can adjust one fire elemental without touching the rest.
+type DamageDefenses struct {
+	Resistances     []string
+	Vulnerabilities []string
+	Immunities      []string
+}
+
+// Validate returns an error naming the first entry that is not a valid damage
+// type.
+func (d DamageDefenses) Validate() error {
+	for _, list := range [][]string{d.Resistances, d.Vulnerabilities, d.Immunities} {
+		for _, name := range list {
+			if !IsValidDamageType(name) {
+				return fmt.Errorf("unknown damage type %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// normalized replaces nil lists with empty ones, so they store as '{}' in the
+// NOT NULL array columns rather than NULL. Updates skip it instead: there a nil
+// list is one the request left out, and keeps the stored value (see
+// keepDefenseSQL).
+func (d DamageDefenses) normalized() DamageDefenses {
+	for _, list := range []*[]string{&d.Resistances, &d.Vulnerabilities, &d.Immunities} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	return d
+}
+
+// keepDefenseSQL is the SET clause of an update that writes the three damage
+// defense lists from parameters $n to $n+2, keeping any list passed as NULL.
+func keepDefenseSQL(n int) string {
+	return fmt.Sprintf("damage_resistances = COALESCE($%d, damage_resistances), damage_vulnerabilities = COALESCE($%d, damage_vulnerabilities), damage_immunities = COALESCE($%d, damage_immunities)", n, n+1, n+2)
+}
+
+// Adjust applies the defenses to raw damage of damageType, following the 5e
+// order: immunity negates it, otherwise resistance halves it (rounding down)
+// and vulnerability then doubles it. It also returns the defenses that
+// applied, empty when none did. Untyped damage ("") is never adjusted.
+func (d DamageDefenses) Adjust(raw int, damageType string) (int, []string) {
+	if damageType == "" {
+		return raw, nil
+	}
+	if slices.Contains(d.Immunities, damageType) {
+		return 0, []string{DefenseImmune}
+	}
+	final := raw
+	var applied []string
+	if slices.Contains(d.Resistances, damageType) {
+		final /= 2
+		applied = append(applied, DefenseResistant)
+	}
+	if slices.Contains(d.Vulnerabilities, damageType) {
+		final *= 2
+		applied = append(applied, DefenseVulnerable)
+	}
+	return final, applied
+}
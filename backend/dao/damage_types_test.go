@@ -0,0 +1,41 @@
+package dao
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDamageDefensesAdjust(t *testing.T) {
+	defenses := DamageDefenses{
+		Resistances:     []string{"Fire", "Cold"},
+		Vulnerabilities: []string{"Cold", "Radiant"},
+		Immunities:      []string{"Poison"},
+	}
+	cases := []struct {
+		damageType string
+		raw, want  int
+		applied    []string
+	}{
+		{"", 9, 9, nil},
+		{"Slashing", 9, 9, nil},
+		{"Fire", 9, 4, []string{DefenseResistant}},
+		{"Radiant", 9, 18, []string{DefenseVulnerable}},
+		{"Cold", 9, 8, []string{DefenseResistant, DefenseVulnerable}},
+		{"Poison", 9, 0, []string{DefenseImmune}},
+	}
+	for _, c := range cases {
+		got, applied := defenses.Adjust(c.raw, c.damageType)
+		if got != c.want || !slices.Equal(applied, c.applied) {
+			t.Errorf("Adjust(%d, %q) = %d %v, want %d %v", c.raw, c.damageType, got, applied, c.want, c.applied)
+		}
+	}
+}
+
+func TestDamageDefensesValidateRejectsUnknownType(t *testing.T) {
+	if err := (DamageDefenses{Resistances: []string{"Fire"}}).Validate(); err != nil {
+		t.Errorf("valid defenses rejected: %v", err)
+	}
+	if err := (DamageDefenses{Immunities: []string{"fire"}}).Validate(); err == nil {
+		t.Error("expected an error for a miscased damage type")
+	}
+}
@@ -0,0 +1,139 @@
+package dao
+
+import (
+	"fmt"
+)
+
+// LedgerDeathSave is the action type of a death saving throw's ledger entry.
+const LedgerDeathSave = "death_save"
+
+// DeathSaves is a player character's death saving throw tally at 0 HP. Three
+// successes make it Stable, three failures make it Dead.
+type DeathSaves struct {
+	Successes int  `json:"successes"`
+	Failures  int  `json:"failures"`
+	Stable    bool `json:"stable"`
+	Dead      bool `json:"dead"`
+}
+
+// fail adds n failures, ending any stability; the third kills.
+func (d *DeathSaves) fail(n int) {
+	d.Failures = min(d.Failures+n, 3)
+	d.Stable = false
+	if d.Failures == 3 {
+		d.Dead = true
+	}
+}
+
+// succeed adds a success; the third stabilizes the character and, as in 5e,
+// clears the tally.
+func (d *DeathSaves) succeed() {
+	d.Successes++
+	if d.Successes == 3 {
+		*d = DeathSaves{Stable: true}
+	}
+}
+
+// describe renders the tally for the ledger, e.g. "2 successes, 1 failure".
+func (d DeathSaves) describe() string {
+	switch {
+	case d.Dead:
+		return "dead"
+	case d.Stable:
+		return "stable"
+	}
+	return fmt.Sprintf("%s, %s", plural(d.Successes, "success", "successes"), plural(d.Failures, "failure", "failures"))
+}
+
+func describeDeathSaveFailures(n int) string {
+	return plural(n, "death save failure", "death save failures")
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, one)
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// DeathSaveResult is one death saving throw. Natural is the d20, rolled by the
+// server when Rolled; HP is the character's hit points after it, 1 on a
+// natural 20 and otherwise still 0.
+type DeathSaveResult struct {
+	CharacterID int        `json:"character_id"`
+	Natural     int        `json:"natural"`
+	Rolled      bool       `json:"rolled"`
+	Success     bool       `json:"success"`
+	HP          int        `json:"hp"`
+	DeathSaves  DeathSaves `json:"death_saves"`
+}
+
+// RollDeathSave records a player character's death saving throw, made at the
+// start of its turn while at 0 HP. natural is the d20 the player rolled, or 0
+// to have rollD20 roll it. Following 5e, 10 or higher is a success and lower a
+// failure; a natural 1 counts as two failures and a natural 20 brings the
+// character back with 1 HP. It returns the ledger entry that logged the save.
+func (dao *encounterCharacterDAOImpl) RollDeathSave(encounterID, characterID, natural int, rollD20 func() int) (DeathSaveResult, EncounterLedgerEntry, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return DeathSaveResult{}, EncounterLedgerEntry{}, err
+	}
+	defer tx.Rollback()
+
+	target, err := lockHPTarget(tx, encounterID, characterID)
+	if err != nil {
+		return DeathSaveResult{}, EncounterLedgerEntry{}, err
+	}
+	switch {
+	case !target.isPC:
+		return DeathSaveResult{}, EncounterLedgerEntry{}, fmt.Errorf("only player characters make death saves")
+	case target.deathSaves.Dead:
+		return DeathSaveResult{}, EncounterLedgerEntry{}, fmt.Errorf("character is dead")
+	case target.result.Before > 0:
+		return DeathSaveResult{}, EncounterLedgerEntry{}, fmt.Errorf("character is not at 0 hp")
+	case target.deathSaves.Stable:
+		return DeathSaveResult{}, EncounterLedgerEntry{}, fmt.Errorf("character is stable")
+	}
+
+	result := DeathSaveResult{CharacterID: characterID, Natural: natural}
+	if natural == 0 {
+		result.Natural, result.Rolled = rollD20(), true
+	}
+	var description string
+	switch {
+	case result.Natural == 20:
+		result.Success = true
+		target.heal(1)
+		description = fmt.Sprintf("%s rolls a natural 20 on a death save and regains 1 HP", target.name)
+	case result.Natural == 1:
+		target.deathSaves.fail(2)
+		description = fmt.Sprintf("%s fails a death save (natural 1): %s", target.name, target.deathSaves.describe())
+	case result.Natural >= 10:
+		result.Success = true
+		target.deathSaves.succeed()
+		description = fmt.Sprintf("%s succeeds on a death save (%d): %s", target.name, result.Natural, target.deathSaves.describe())
+	default:
+		target.deathSaves.fail(1)
+		description = fmt.Sprintf("%s fails a death save (%d): %s", target.name, result.Natural, target.deathSaves.describe())
+	}
+	result.HP, result.DeathSaves = target.result.After, target.deathSaves
+
+	if err := target.save(tx, encounterID); err != nil {
+		return DeathSaveResult{}, EncounterLedgerEntry{}, err
+	}
+	entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActorID:     characterID,
+		ActionType:  LedgerDeathSave,
+		HPChange:    target.result.After - target.result.Before,
+		Description: description,
+		Details:     result,
+	})
+	if err != nil {
+		return DeathSaveResult{}, EncounterLedgerEntry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return DeathSaveResult{}, EncounterLedgerEntry{}, err
+	}
+	return result, entry, nil
+}
@@ -0,0 +1,162 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// pcRow is an hpRow for a player character with a death save tally.
+func pcRow(name string, maxHP, currentHP, successes, failures int) *sqlmock.Rows {
+	return sqlmock.NewRows(hpColumns).AddRow(name, 12, maxHP, currentHP, 0, "{}", "{}", "{}", nil, "{}", true, successes, failures, false, false)
+}
+
+func TestRollDeathSave(t *testing.T) {
+	cases := []struct {
+		name                string
+		successes, failures int
+		natural             int
+		hp                  int
+		want                DeathSaves
+		description         string
+	}{
+		{"success", 1, 1, 12, 0, DeathSaves{Successes: 2, Failures: 1}, "Aragorn succeeds on a death save (12): 2 successes, 1 failure"},
+		{"third success stabilizes", 2, 2, 10, 0, DeathSaves{Stable: true}, "Aragorn succeeds on a death save (10): stable"},
+		{"failure", 0, 0, 9, 0, DeathSaves{Failures: 1}, "Aragorn fails a death save (9): 0 successes, 1 failure"},
+		{"natural 1 counts twice", 1, 2, 1, 0, DeathSaves{Successes: 1, Failures: 3, Dead: true}, "Aragorn fails a death save (natural 1): dead"},
+		{"natural 20 regains 1 HP", 0, 2, 20, 1, DeathSaves{}, "Aragorn rolls a natural 20 on a death save and regains 1 HP"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer db.Close()
+			dao := NewEncounterCharacterDAO(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(pcRow("Aragorn", 30, 0, c.successes, c.failures))
+			mock.ExpectExec(q(saveHPQ)).
+				WithArgs(c.hp, 0, c.want.Successes, c.want.Failures, c.want.Stable, c.want.Dead, 7, 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("INSERT INTO encounter_ledger").
+				WithArgs(7, 5, 0, LedgerDeathSave, c.hp, c.description, sqlmock.AnyArg()).
+				WillReturnRows(ledgerRow())
+			mock.ExpectCommit()
+
+			result, _, err := dao.RollDeathSave(7, 5, c.natural, nil)
+			if err != nil {
+				t.Fatalf("RollDeathSave returned error: %v", err)
+			}
+			if result.DeathSaves != c.want || result.HP != c.hp {
+				t.Errorf("result = %+v, want %+v at %d HP", result, c.want, c.hp)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet sqlmock expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestRollDeathSave_RejectsNPCs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 4).WillReturnRows(hpRow("Goblin", 7, 0, 0))
+	mock.ExpectRollback()
+
+	if _, _, err := dao.RollDeathSave(7, 4, 0, fixedD20(t)); err == nil {
+		t.Fatal("expected an error for an NPC")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_DamageAtZeroHPFailsDeathSaves(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(pcRow("Aragorn", 30, 0, 2, 1))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(0, 0, 2, 2, false, false, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 5, HPDamage, 0, "Aragorn takes 4 damage (0 → 0): 1 death save failure, 2 successes, 2 failures", sqlmock.AnyArg()).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, Kind: HPDamage, Amount: 4})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.DeathSaves == nil || result.DeathSaves.Failures != 2 {
+		t.Errorf("death saves = %+v, want 2 failures", result.DeathSaves)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_MassiveDamageKillsOutright(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// 10 HP left, so 40 damage leaves 30 over: Aragorn's whole maximum.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(pcRow("Aragorn", 30, 10, 0, 0))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(0, 0, 0, 0, false, true, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 5, HPDamage, -10, "Aragorn takes 40 damage (10 → 0) and dies outright",
+			`{"raw_damage":40,"final_damage":40,"instant_death":true}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	if _, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, Kind: HPDamage, Amount: 40}); err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_HealingResetsDeathSaves(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(pcRow("Aragorn", 30, 0, 1, 2))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(3, 0, 0, 0, false, false, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 5, HPHealing, 3, "Aragorn regains 3 HP (0 → 3)", nil).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, Kind: HPHealing, Amount: 3})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.DeathSaves != nil {
+		t.Errorf("death saves = %+v, want none once healed", result.DeathSaves)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
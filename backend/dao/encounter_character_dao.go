@@ -2,7 +2,11 @@ package dao
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"slices"
+
+	"go-initiative-tracker/dice"
 )
 
 type EncounterCharacter struct {
@@ -13,14 +17,55 @@ type EncounterCharacter struct {
 	IsActive    bool
 }
 
+// TurnResult reports where combat stands after a turn-changing operation: who
+// now holds the turn and the encounter's round/turn position. When an
+// initiative event holds the turn, ActiveEventID is set and ActiveCharacterID
+// is 0.
+type TurnResult struct {
+	ActiveCharacterID int
+	ActiveEventID     int
+	Round             int
+	TurnIndex         int
+	// Skipped lists the combatants AdvanceTurn passed over under the
+	// encounter's skip policy, in turn order.
+	Skipped []int
+	// Recharges are the ledger entries of the recharge rolls made as the
+	// incoming creature's turn started.
+	Recharges []EncounterLedgerEntry
+	// ExpiredEffects are the ledger entries of the encounter effects that ran
+	// out; EffectsChanged says whether any effect ticked or was put back.
+	ExpiredEffects []EncounterLedgerEntry
+	EffectsChanged bool
+}
+
 type EncounterCharacterDAO interface {
 	GetByEncounterAndCharacter(encounterID, characterID int) (EncounterCharacter, error)
 	Update(enc EncounterCharacter) error
 	Upsert(enc EncounterCharacter) error
-	StartCombat(encounterID int) (int, error)
+	StartCombat(encounterID int, surprised []int) (TurnResult, error)
 	ResetCombat(encounterID int) error
-	AdvanceTurn(encounterID int) (int, error)
-	SetActiveCharacter(encounterID, characterID int) error
+	// The turn-changing methods below take an optional expectedActiveID, the
+	// active character the caller last saw (0 for none). When it no longer
+	// holds the turn they change nothing and return a *TurnConflictError.
+	AdvanceTurn(encounterID int, expectedActiveID *int, roll dice.Roller) (TurnResult, error)
+	SetActiveCharacter(encounterID, characterID int, expectedActiveID *int) error
+	PreviousTurn(encounterID int, expectedActiveID *int) (TurnResult, error)
+	SetTiebreakOrder(encounterID int, characterIDs []int) error
+	RollInitiative(encounterID int, opts InitiativeRollOptions) ([]InitiativeRoll, error)
+	Hold(encounterID, characterID int, hold string, expectedActiveID *int, roll dice.Roller) (TurnResult, error)
+	Rejoin(encounterID, characterID int, position string, expectedActiveID *int, roll dice.Roller) (TurnResult, error)
+	SetInitiativeGroup(encounterID, templateID int, grouped bool) error
+	ApplyHP(change HPChange) (HPResult, error)
+	Attack(attack Attack) ([]AttackResult, error)
+	RollSaves(save SavingThrow) (GroupSaveResult, error)
+	SetConcentration(encounterID, characterID int, concentration Concentration) (ConcentrationChange, EncounterLedgerEntry, error)
+	EndConcentration(encounterID, characterID int) (ConcentrationChange, EncounterLedgerEntry, error)
+	RollDeathSave(encounterID, characterID, natural int, rollD20 func() int) (DeathSaveResult, EncounterLedgerEntry, error)
+	SpendLegendary(use LegendaryUse) (LegendaryCounters, EncounterLedgerEntry, error)
+	RestoreLegendary(use LegendaryUse) (LegendaryCounters, EncounterLedgerEntry, error)
+	SpendResource(use ResourceUse) (Resource, EncounterLedgerEntry, error)
+	RestoreResource(use ResourceUse) (Resource, EncounterLedgerEntry, error)
+	Rest(encounterID, characterID int, rest string) ([]EncounterLedgerEntry, error)
 }
 
 type encounterCharacterDAOImpl struct {
@@ -48,190 +93,588 @@ func (dao *encounterCharacterDAOImpl) Update(enc EncounterCharacter) error {
 	return err
 }
 
+// groupMatesSQL selects the character ids in character $2's initiative group
+// within encounter $1, $2 included; it is empty when $2 is not grouped.
+const groupMatesSQL = "(SELECT mate.id FROM encounter_characters me JOIN characters c ON c.id = me.character_id JOIN characters mate ON mate.npc_template_id = c.npc_template_id WHERE me.encounter_id = $1 AND me.character_id = $2 AND me.grouped)"
+
+// Upsert saves a combatant's encounter values. A grouped NPC's initiative is
+// the group's, so storing one member's initiative stores it for them all.
 func (dao *encounterCharacterDAOImpl) Upsert(enc EncounterCharacter) error {
 	_, err := dao.db.Exec(
-		"INSERT INTO encounter_characters (encounter_id, character_id, initiative, current_hp, is_active) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (encounter_id, character_id) DO UPDATE SET initiative = EXCLUDED.initiative, current_hp = EXCLUDED.current_hp, is_active = EXCLUDED.is_active",
+		"WITH saved AS (INSERT INTO encounter_characters (encounter_id, character_id, initiative, current_hp, is_active) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (encounter_id, character_id) DO UPDATE SET initiative = EXCLUDED.initiative, current_hp = EXCLUDED.current_hp, is_active = EXCLUDED.is_active RETURNING initiative)"+
+			" UPDATE encounter_characters SET initiative = (SELECT initiative FROM saved) WHERE encounter_id = $1 AND grouped AND character_id <> $2 AND character_id IN "+groupMatesSQL,
 		enc.EncounterID, enc.CharacterID, enc.Initiative, enc.CurrentHP, enc.IsActive,
 	)
 	return err
 }
 
-func (dao *encounterCharacterDAOImpl) StartCombat(encounterID int) (int, error) {
-	tx, err := dao.db.Begin()
-	if err != nil {
-		return 0, err
-	}
-	defer tx.Rollback()
+// loadActiveTurn returns who holds the encounter's turn: the active character
+// id or the active initiative event id, with the other (or both, if no one
+// holds the turn) 0.
+func loadActiveTurn(tx *sql.Tx, encounterID int) (characterID, eventID int, err error) {
+	err = tx.QueryRow(
+		"SELECT COALESCE((SELECT MAX(character_id) FROM encounter_characters WHERE encounter_id = $1 AND is_active = TRUE), 0), COALESCE((SELECT MAX(id) FROM encounter_initiative_events WHERE encounter_id = $1 AND is_active = TRUE), 0)",
+		encounterID,
+	).Scan(&characterID, &eventID)
+	return characterID, eventID, err
+}
 
-	rows, err := tx.Query(
-		"SELECT character_id FROM encounter_characters WHERE encounter_id = $1 ORDER BY COALESCE(initiative, 0) DESC, character_id ASC",
+// loadCombatState reads the encounter's round and turn position and locks its
+// row until the transaction ends. Every combat mutation loads it first, so
+// concurrent changes from co-DMs queue up behind each other instead of
+// interleaving.
+func loadCombatState(tx *sql.Tx, encounterID int) (CombatState, error) {
+	var state CombatState
+	err := tx.QueryRow(
+		"SELECT round, turn_index, combat_started FROM encounters WHERE id = $1 FOR UPDATE",
 		encounterID,
+	).Scan(&state.Round, &state.TurnIndex, &state.CombatStarted)
+	return state, err
+}
+
+func saveCombatState(tx *sql.Tx, encounterID int, state CombatState) error {
+	_, err := tx.Exec(
+		"UPDATE encounters SET round = $1, turn_index = $2, combat_started = $3 WHERE id = $4",
+		state.Round, state.TurnIndex, state.CombatStarted, encounterID,
+	)
+	return err
+}
+
+// deactivateAll leaves no one holding the turn, combatant or initiative event.
+func deactivateAll(tx *sql.Tx, encounterID int) error {
+	_, err := tx.Exec(
+		"WITH events AS (UPDATE encounter_initiative_events SET is_active = FALSE WHERE encounter_id = $1) UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1",
+		encounterID,
+	)
+	return err
+}
+
+// activate makes characterID the only active combatant in the encounter,
+// together with the rest of its initiative group if it is grouped, and reports
+// whether it was found there.
+func activate(tx *sql.Tx, encounterID, characterID int) (bool, error) {
+	if err := deactivateAll(tx, encounterID); err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(
+		"UPDATE encounter_characters SET is_active = TRUE WHERE encounter_id = $1 AND (character_id = $2 OR (grouped AND character_id IN "+groupMatesSQL+"))",
+		encounterID, characterID,
 	)
 	if err != nil {
-		return 0, err
+		return false, err
 	}
-	defer rows.Close()
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
 
-	var orderedIDs []int
-	for rows.Next() {
-		var id int
-		if scanErr := rows.Scan(&id); scanErr != nil {
-			return 0, scanErr
-		}
-		orderedIDs = append(orderedIDs, id)
+// activateEvent hands the turn to an initiative event and reports whether it
+// was found in the encounter.
+func activateEvent(tx *sql.Tx, encounterID, eventID int) (bool, error) {
+	if err := deactivateAll(tx, encounterID); err != nil {
+		return false, err
 	}
-	if len(orderedIDs) == 0 {
-		return 0, fmt.Errorf("no characters in encounter")
+	res, err := tx.Exec(
+		"UPDATE encounter_initiative_events SET is_active = TRUE WHERE encounter_id = $1 AND id = $2",
+		encounterID, eventID,
+	)
+	if err != nil {
+		return false, err
 	}
-	activeID := orderedIDs[0]
+	affected, err := res.RowsAffected()
+	return affected > 0, err
+}
 
-	if _, err = tx.Exec(
-		"UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1",
+// activateSlot hands the turn to whoever owns slot.
+func activateSlot(tx *sql.Tx, encounterID int, slot turnSlot) (bool, error) {
+	if slot.EventID != 0 {
+		return activateEvent(tx, encounterID, slot.EventID)
+	}
+	return activate(tx, encounterID, slot.CharacterID)
+}
+
+// releaseTurnMarkers puts every held combatant back in the rotation at their
+// old slot and clears any surprise or interrupted turn left over, used when
+// combat starts over.
+func releaseTurnMarkers(tx *sql.Tx, encounterID int) error {
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET held = NULL, surprised = FALSE, resuming = FALSE WHERE encounter_id = $1 AND (held IS NOT NULL OR surprised OR resuming)",
 		encounterID,
-	); err != nil {
-		return 0, err
+	)
+	return err
+}
+
+// StartCombat opens round 1 and activates the top of the initiative order
+// (which may be an initiative event). Anyone still holding from an earlier
+// fight rejoins the rotation first. The combatants in surprised lose their
+// round-one turn: they are skipped, and each one's surprise clears as that
+// skipped turn ends, so those at the very top of the order are cleared at
+// once. Each surprised combatant gets a ledger entry.
+func (dao *encounterCharacterDAOImpl) StartCombat(encounterID int, surprised []int) (TurnResult, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return TurnResult{}, err
 	}
+	defer tx.Rollback()
 
-	if _, err = tx.Exec(
-		"UPDATE encounter_characters SET is_active = TRUE WHERE encounter_id = $1 AND character_id = $2",
-		encounterID, activeID,
-	); err != nil {
-		return 0, err
+	if _, err = loadCombatState(tx, encounterID); err != nil {
+		return TurnResult{}, err
+	}
+	if err = releaseTurnMarkers(tx, encounterID); err != nil {
+		return TurnResult{}, err
+	}
+	if err = markSurprised(tx, encounterID, surprised); err != nil {
+		return TurnResult{}, err
+	}
+	order, err := loadTurnOrder(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if len(order) == 0 {
+		return TurnResult{}, fmt.Errorf("no characters in encounter")
+	}
+	// Nobody is held now, so a first slot is always found.
+	first, _, skipped, _ := nextTurn(order, -1, SkipPolicyNone)
+	if _, err = activateSlot(tx, encounterID, order[first]); err != nil {
+		return TurnResult{}, err
+	}
+	for _, characterID := range skipped {
+		if err = endTurn(tx, encounterID, characterID); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	if err = clearSurprise(tx, encounterID, surprisedAmong(order, skipped)); err != nil {
+		return TurnResult{}, err
+	}
+	state := CombatState{Round: 1, TurnIndex: first, CombatStarted: true}
+	if err = saveCombatState(tx, encounterID, state); err != nil {
+		return TurnResult{}, err
+	}
+	if err = clearTurnHistory(tx, encounterID); err != nil {
+		return TurnResult{}, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return 0, err
+		return TurnResult{}, err
 	}
 
-	return activeID, nil
+	return TurnResult{ActiveCharacterID: order[first].CharacterID, ActiveEventID: order[first].EventID, Round: state.Round, TurnIndex: state.TurnIndex, Skipped: skipped}, nil
 }
 
+// ResetCombat returns the encounter to set-up: no one active or holding, the
+// round counter cleared and nothing left to rewind, ready for a fresh
+// StartCombat.
 func (dao *encounterCharacterDAOImpl) ResetCombat(encounterID int) error {
-	_, err := dao.db.Exec(
-		"UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1",
-		encounterID,
-	)
-	return err
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = loadCombatState(tx, encounterID); err != nil {
+		return err
+	}
+	if err = deactivateAll(tx, encounterID); err != nil {
+		return err
+	}
+	if err = saveCombatState(tx, encounterID, CombatState{}); err != nil {
+		return err
+	}
+	if err = releaseTurnMarkers(tx, encounterID); err != nil {
+		return err
+	}
+	if err = clearTurnHistory(tx, encounterID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // SetActiveCharacter makes a single character the active turn holder, clearing
 // is_active on everyone else in the encounter. This lets a user click a
 // character to take the turn so a subsequent AdvanceTurn continues from there.
-func (dao *encounterCharacterDAOImpl) SetActiveCharacter(encounterID, characterID int) error {
+// The round is kept; turn_index moves to the character's slot, and combat is
+// marked started (in round 1) if it was not already.
+func (dao *encounterCharacterDAOImpl) SetActiveCharacter(encounterID, characterID int, expectedActiveID *int) error {
 	tx, err := dao.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	if _, err = tx.Exec(
-		"UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1",
-		encounterID,
-	); err != nil {
+	state, err := loadCombatState(tx, encounterID)
+	if err != nil {
 		return err
 	}
-
-	res, err := tx.Exec(
-		"UPDATE encounter_characters SET is_active = TRUE WHERE encounter_id = $1 AND character_id = $2",
-		encounterID, characterID,
-	)
-	if err != nil {
+	if err = checkExpectedTurn(tx, encounterID, state, expectedActiveID); err != nil {
 		return err
 	}
-	affected, err := res.RowsAffected()
+	found, err := activate(tx, encounterID, characterID)
 	if err != nil {
 		return err
 	}
-	if affected == 0 {
+	if !found {
 		return fmt.Errorf("character not in encounter")
 	}
 
+	order, err := loadTurnOrder(tx, encounterID)
+	if err != nil {
+		return err
+	}
+	state.TurnIndex = max(activeIndex(order, characterID, 0), 0)
+	state.Round = max(state.Round, 1)
+	state.CombatStarted = true
+	if err = saveCombatState(tx, encounterID, state); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
-func (dao *encounterCharacterDAOImpl) AdvanceTurn(encounterID int) (int, error) {
+// SetTiebreakOrder records the DM's order for combatants whose initiative and
+// Dexterity tie: characterIDs go first to last, and anyone not listed loses the
+// tie to everyone who is. The previous order is replaced wholesale. If combat
+// is under way, turn_index is recomputed so it still points at the active
+// combatant after the reorder.
+func (dao *encounterCharacterDAOImpl) SetTiebreakOrder(encounterID int, characterIDs []int) error {
 	tx, err := dao.db.Begin()
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer tx.Rollback()
 
-	rows, err := tx.Query(
-		"SELECT character_id FROM encounter_characters WHERE encounter_id = $1 ORDER BY COALESCE(initiative, 0) DESC, character_id ASC",
-		encounterID,
-	)
+	if _, err = tx.Exec("UPDATE encounter_characters SET tiebreak_order = NULL WHERE encounter_id = $1", encounterID); err != nil {
+		return err
+	}
+	for i, characterID := range characterIDs {
+		result, err := tx.Exec(
+			"UPDATE encounter_characters SET tiebreak_order = $1 WHERE encounter_id = $2 AND character_id = $3",
+			i, encounterID, characterID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("character not in encounter")
+		}
+	}
+
+	if err = resyncTurnIndex(tx, encounterID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AdvanceTurn passes the turn to the next combatant or initiative event in
+// initiative order. Moving on from the last slot wraps to the first and starts
+// a new round; if no one is active yet, the first slot takes round 1. As the
+// incoming creature's turn starts, its legendary actions refill and so do its
+// turn_start resources. roll rolls its recharge dice; nil leaves them to the
+// DM. Combatants the encounter's skip policy passes over still have their turn
+// end (their timed conditions tick) and are reported in Skipped; held
+// combatants are left out.
+func (dao *encounterCharacterDAOImpl) AdvanceTurn(encounterID int, expectedActiveID *int, roll dice.Roller) (TurnResult, error) {
+	tx, err := dao.db.Begin()
 	if err != nil {
-		return 0, err
+		return TurnResult{}, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var orderedIDs []int
-	for rows.Next() {
-		var id int
-		if scanErr := rows.Scan(&id); scanErr != nil {
-			return 0, scanErr
+	// advance takes the encounter lock itself; an expectation needs it first.
+	if expectedActiveID != nil {
+		state, err := loadCombatState(tx, encounterID)
+		if err != nil {
+			return TurnResult{}, err
+		}
+		if err = checkExpectedTurn(tx, encounterID, state, expectedActiveID); err != nil {
+			return TurnResult{}, err
 		}
-		orderedIDs = append(orderedIDs, id)
 	}
-	if len(orderedIDs) == 0 {
-		return 0, fmt.Errorf("no characters in encounter")
+
+	turn, err := advance(tx, encounterID, true, turnSnapshot{}, roll)
+	if err != nil {
+		return TurnResult{}, err
 	}
 
-	currentActiveID := 0
-	if err = tx.QueryRow(
-		"SELECT COALESCE(MAX(character_id), 0) FROM encounter_characters WHERE encounter_id = $1 AND is_active = TRUE",
-		encounterID,
-	).Scan(&currentActiveID); err != nil {
-		return 0, err
+	if err = tx.Commit(); err != nil {
+		return TurnResult{}, err
+	}
+	return turn, nil
+}
+
+// advance moves the turn from the active combatant to the next one in the
+// rotation and records the history entry PreviousTurn needs, filling in the
+// turn-related fields of snapshot (callers may pre-fill Combatants). endCurrent
+// says whether the outgoing combatant's turn ends, ticking its conditions; a
+// delay hands the turn on without ending it. The incoming creature's turn
+// starts either way, refilling its legendary actions and recharging its
+// resources; roll rolls the recharge dice (nil leaves them to the DM), and
+// each roll is logged to the ledger. Encounter effects tick on both the turns
+// that start and the turns that end.
+func advance(tx *sql.Tx, encounterID int, endCurrent bool, snapshot turnSnapshot, roll dice.Roller) (TurnResult, error) {
+	state, err := loadCombatState(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	order, err := loadTurnOrder(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if len(order) == 0 {
+		return TurnResult{}, fmt.Errorf("no characters in encounter")
+	}
+
+	currentActiveID, currentEventID, err := loadActiveTurn(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
 	}
+	var policy string
+	if err = tx.QueryRow("SELECT skip_policy FROM encounters WHERE id = $1", encounterID).Scan(&policy); err != nil {
+		return TurnResult{}, err
+	}
+
+	current := activeIndex(order, currentActiveID, currentEventID)
+	nextIndex, newRound, skipped, ok := nextTurn(order, current, policy)
+	if !ok {
+		return TurnResult{}, fmt.Errorf("no combatant can take the turn: everyone is holding")
+	}
+	next := order[nextIndex]
 
-	nextIndex := 0
-	if currentActiveID != 0 {
-		for idx, id := range orderedIDs {
-			if id == currentActiveID {
-				nextIndex = (idx + 1) % len(orderedIDs)
-				break
+	if _, err = activateSlot(tx, encounterID, next); err != nil {
+		return TurnResult{}, err
+	}
+	// The incoming creature's turn starts (each member's, for a group), unless
+	// a rejoin interrupted it after it had started; then it just resumes.
+	var starting []int
+	var recharges []EncounterLedgerEntry
+	if next.EventID == 0 {
+		var members []int
+		for _, s := range turnMembers(order, nextIndex) {
+			members = append(members, s.CharacterID)
+		}
+		if snapshot.Resumed, err = resumeTurns(tx, encounterID, members); err != nil {
+			return TurnResult{}, err
+		}
+		for _, characterID := range members {
+			if !slices.Contains(snapshot.Resumed, characterID) {
+				starting = append(starting, characterID)
 			}
 		}
+		if snapshot.Legendary, snapshot.Resources, recharges, err = startTurn(tx, encounterID, starting, roll); err != nil {
+			return TurnResult{}, err
+		}
+		snapshot.Ledger = append(snapshot.Ledger, ledgerIDs(recharges)...)
 	}
-	nextActiveID := orderedIDs[nextIndex]
 
-	if _, err = tx.Exec(
-		"UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1",
-		encounterID,
-	); err != nil {
-		return 0, err
+	// Every turn that ends in this advance: the outgoing creature's (each
+	// member's, for an initiative group), then each skipped creature's, which
+	// starts and ends without it acting. currentActiveID is 0 on the very first
+	// advance (no one active yet) and when an initiative event held the turn, so
+	// no creature's turn ends then.
+	var ending []int
+	if endCurrent && currentActiveID != 0 && current >= 0 {
+		for _, s := range turnMembers(order, current) {
+			ending = append(ending, s.CharacterID)
+		}
 	}
+	ending = append(ending, skipped...)
 
-	if _, err = tx.Exec(
-		"UPDATE encounter_characters SET is_active = TRUE WHERE encounter_id = $1 AND character_id = $2",
-		encounterID, nextActiveID,
+	// Record what this advance is about to change so PreviousTurn can undo it.
+	snapshot.ActiveCharacterID = currentActiveID
+	snapshot.ActiveEventID = currentEventID
+	snapshot.State = state
+	snapshot.Surprised = surprisedAmong(order, ending)
+	for _, characterID := range ending {
+		conditions, err := loadTimedConditions(tx, encounterID, characterID)
+		if err != nil {
+			return TurnResult{}, err
+		}
+		snapshot.Conditions = append(snapshot.Conditions, conditions...)
+	}
+	// Battlefield effects tick on the turns that start and end here; a skipped
+	// creature's turn does both.
+	var expired []EncounterLedgerEntry
+	if snapshot.Effects, expired, err = tickEffects(tx, encounterID, append(starting, skipped...), ending); err != nil {
+		return TurnResult{}, err
+	}
+	snapshot.Ledger = append(snapshot.Ledger, ledgerIDs(expired)...)
+	if err = pushTurnHistory(tx, encounterID, snapshot); err != nil {
+		return TurnResult{}, err
+	}
+
+	for _, characterID := range ending {
+		if err = endTurn(tx, encounterID, characterID); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	if err = clearSurprise(tx, encounterID, snapshot.Surprised); err != nil {
+		return TurnResult{}, err
+	}
+
+	// A fight that was never formally started (or predates round tracking)
+	// begins at round 1 on its first advance.
+	state.Round = max(state.Round, 1)
+	if newRound {
+		state.Round++
+	}
+	state.TurnIndex = nextIndex
+	state.CombatStarted = true
+	if err = saveCombatState(tx, encounterID, state); err != nil {
+		return TurnResult{}, err
+	}
+
+	return TurnResult{ActiveCharacterID: next.CharacterID, ActiveEventID: next.EventID, Round: state.Round, TurnIndex: state.TurnIndex, Skipped: skipped, Recharges: recharges, ExpiredEffects: expired, EffectsChanged: len(snapshot.Effects) > 0}, nil
+}
+
+// startTurn refills the legendary actions and recharges the resources of the
+// creatures whose turn starts, returning what they were before and the
+// recharge rolls.
+func startTurn(tx *sql.Tx, encounterID int, starting []int, roll dice.Roller) ([]legendarySnapshot, []resourceSnapshot, []EncounterLedgerEntry, error) {
+	if len(starting) == 0 {
+		return nil, nil, nil, nil
+	}
+	legendary, err := refillLegendaryActions(tx, encounterID, starting)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	resources, recharges, err := rechargeResources(tx, encounterID, starting, roll)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return legendary, resources, recharges, nil
+}
+
+// endTurn ticks down a creature's timed conditions as its turn ends, then
+// clears any that have expired. Conditions with a NULL duration ("until
+// removed") are left untouched.
+func endTurn(tx *sql.Tx, encounterID, characterID int) error {
+	if _, err := tx.Exec(
+		"UPDATE encounter_character_conditions SET duration_rounds = duration_rounds - 1 WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL",
+		encounterID, characterID,
 	); err != nil {
-		return 0, err
+		return err
+	}
+	_, err := tx.Exec(
+		"DELETE FROM encounter_character_conditions WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL AND duration_rounds <= 0",
+		encounterID, characterID,
+	)
+	return err
+}
+
+// PreviousTurn undoes the most recent AdvanceTurn: the previous combatant is
+// active again, the round and turn index step back, and the outgoing creature's
+// timed conditions get their exact pre-advance durations back — including any
+// that expired and were deleted — as do the legendary actions and resources
+// the incoming creature's turn refilled and the encounter effects that ticked.
+// The recharge rolls and effect expiries it logged are deleted from the ledger.
+// Whatever belonged to a combatant that has left the encounter since is
+// skipped. Everything happens in one transaction, and each call consumes one
+// recorded advance, so repeated calls keep rewinding until the start of combat.
+func (dao *encounterCharacterDAOImpl) PreviousTurn(encounterID int, expectedActiveID *int) (TurnResult, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer tx.Rollback()
+
+	state, err := loadCombatState(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if err = checkExpectedTurn(tx, encounterID, state, expectedActiveID); err != nil {
+		return TurnResult{}, err
+	}
+
+	historyID, snapshot, err := popTurnHistory(tx, encounterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TurnResult{}, fmt.Errorf("no previous turn to rewind")
+	}
+	if err != nil {
+		return TurnResult{}, err
 	}
 
-	// Tick down the outgoing creature's timed conditions as its turn ends (when we
-	// switch away from it), then clear any that have expired. Conditions with a
-	// NULL duration ("until removed") are left untouched. currentActiveID is 0 on
-	// the very first advance (no one active yet), so nothing is decremented then.
-	if currentActiveID != 0 {
-		if _, err = tx.Exec(
-			"UPDATE encounter_character_conditions SET duration_rounds = duration_rounds - 1 WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL",
-			encounterID, currentActiveID,
-		); err != nil {
-			return 0, err
+	for _, cond := range snapshot.Conditions {
+		if err = restoreCondition(tx, cond); err != nil {
+			return TurnResult{}, err
 		}
-		if _, err = tx.Exec(
-			"DELETE FROM encounter_character_conditions WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL AND duration_rounds <= 0",
-			encounterID, currentActiveID,
-		); err != nil {
-			return 0, err
+	}
+	for _, c := range snapshot.Combatants {
+		if err = restoreCombatant(tx, encounterID, c); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	for _, characterID := range snapshot.Surprised {
+		if err = setSurprised(tx, encounterID, characterID); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	for _, characterID := range snapshot.Resuming {
+		if err = setResuming(tx, encounterID, characterID, false); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	for _, characterID := range snapshot.Resumed {
+		if err = setResuming(tx, encounterID, characterID, true); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	for _, l := range snapshot.Legendary {
+		if err = restoreLegendaryActions(tx, encounterID, l); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	for _, res := range snapshot.Resources {
+		if err = saveResourceUses(tx, res.ID, res.Current); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	for _, e := range snapshot.Effects {
+		if err = restoreEffect(tx, e); err != nil {
+			return TurnResult{}, err
+		}
+	}
+	if err = deleteLedgerEntries(tx, encounterID, snapshot.Ledger); err != nil {
+		return TurnResult{}, err
+	}
+
+	activeID, activeEventID := snapshot.ActiveCharacterID, snapshot.ActiveEventID
+	switch {
+	case activeEventID != 0:
+		// The event may have been removed since; then no one is active.
+		found, err := activateEvent(tx, encounterID, activeEventID)
+		if err != nil {
+			return TurnResult{}, err
+		}
+		if !found {
+			activeEventID = 0
 		}
+	case activeID != 0:
+		// The combatant may have left the encounter since; then no one is active.
+		found, err := activate(tx, encounterID, activeID)
+		if err != nil {
+			return TurnResult{}, err
+		}
+		if !found {
+			activeID = 0
+		}
+	default:
+		if err = deactivateAll(tx, encounterID); err != nil {
+			return TurnResult{}, err
+		}
+	}
+
+	if err = saveCombatState(tx, encounterID, snapshot.State); err != nil {
+		return TurnResult{}, err
+	}
+	if _, err = tx.Exec("DELETE FROM encounter_turn_history WHERE id = $1", historyID); err != nil {
+		return TurnResult{}, err
 	}
 
 	if err = tx.Commit(); err != nil {
-		return 0, err
+		return TurnResult{}, err
 	}
 
-	return nextActiveID, nil
+	return TurnResult{ActiveCharacterID: activeID, ActiveEventID: activeEventID, Round: snapshot.State.Round, TurnIndex: snapshot.State.TurnIndex, EffectsChanged: len(snapshot.Effects) > 0}, nil
 }
@@ -1,32 +1,128 @@
 package dao
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
-// The DAO relies on SQL to order combatants; these constants mirror the exact
-// statements so the tests lock in the ordering contract and argument wiring.
+// These constants mirror the exact statements so the tests lock in the
+// argument wiring; turn order itself is sorted in Go by compareTurnOrder.
 const (
-	selectOrderedQ = "SELECT character_id FROM encounter_characters WHERE encounter_id = $1 ORDER BY COALESCE(initiative, 0) DESC, character_id ASC"
-	selectActiveQ  = "SELECT COALESCE(MAX(character_id), 0) FROM encounter_characters WHERE encounter_id = $1 AND is_active = TRUE"
-	updateAllOffQ  = "UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1"
-	updateOnQ      = "UPDATE encounter_characters SET is_active = TRUE WHERE encounter_id = $1 AND character_id = $2"
+	selectOrderedQ = "SELECT ec.character_id, COALESCE(ec.initiative, 0), ec.turn_rank, " + dexModifierSQL + ", ec.tiebreak_order, c.type = 'npc', COALESCE(ec.current_hp, c.max_hp), " + disabledSQL + ", ec.held IS NOT NULL, 0, " + groupSQL + ", ec.surprised FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1" +
+		" UNION ALL SELECT 0, initiative_count, 0, 0, NULL, FALSE, 0, FALSE, FALSE, id, 0, FALSE FROM encounter_initiative_events WHERE encounter_id = $1"
+	selectActiveQ   = "SELECT COALESCE((SELECT MAX(character_id) FROM encounter_characters WHERE encounter_id = $1 AND is_active = TRUE), 0), COALESCE((SELECT MAX(id) FROM encounter_initiative_events WHERE encounter_id = $1 AND is_active = TRUE), 0)"
+	skipPolicyQ     = "SELECT skip_policy FROM encounters WHERE id = $1"
+	releaseMarkersQ = "UPDATE encounter_characters SET held = NULL, surprised = FALSE, resuming = FALSE WHERE encounter_id = $1 AND (held IS NOT NULL OR surprised OR resuming)"
+	// Delay, ready and rejoin.
+	selectCombatantQ  = "SELECT initiative, turn_rank, held FROM encounter_characters WHERE encounter_id = $1 AND character_id = $2 FOR UPDATE"
+	setHeldQ          = "UPDATE encounter_characters SET held = $1 WHERE encounter_id = $2 AND character_id = $3 AND NOT grouped"
+	rejoinSlotQ       = "UPDATE encounter_characters SET initiative = $1, turn_rank = $2, held = NULL WHERE encounter_id = $3 AND character_id = $4"
+	clearSurpriseQ    = "UPDATE encounter_characters SET surprised = FALSE WHERE encounter_id = $1 AND character_id = $2"
+	restoreCombatantQ = "UPDATE encounter_characters SET initiative = $1, turn_rank = $2, held = $3 WHERE encounter_id = $4 AND character_id = $5"
+	setResumingQ      = "UPDATE encounter_characters SET resuming = $1 WHERE encounter_id = $2 AND character_id = $3"
+	resumeTurnsQ      = "UPDATE encounter_characters SET resuming = FALSE WHERE encounter_id = $1 AND character_id = ANY($2) AND resuming RETURNING character_id"
+	updateAllOffQ     = "WITH events AS (UPDATE encounter_initiative_events SET is_active = FALSE WHERE encounter_id = $1) UPDATE encounter_characters SET is_active = FALSE WHERE encounter_id = $1"
+	updateEventOnQ    = "UPDATE encounter_initiative_events SET is_active = TRUE WHERE encounter_id = $1 AND id = $2"
+	updateOnQ         = "UPDATE encounter_characters SET is_active = TRUE WHERE encounter_id = $1 AND (character_id = $2 OR (grouped AND character_id IN " + groupMatesSQL + "))"
 	// AdvanceTurn ticks the newly-active creature's timed conditions at the start
 	// of its turn and clears expired ones; these mirror those two statements.
 	tickConditionsQ   = "UPDATE encounter_character_conditions SET duration_rounds = duration_rounds - 1 WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL"
 	expireConditionsQ = "DELETE FROM encounter_character_conditions WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL AND duration_rounds <= 0"
+	// Round/turn bookkeeping on the encounters row.
+	selectStateQ = "SELECT round, turn_index, combat_started FROM encounters WHERE id = $1 FOR UPDATE"
+	saveStateQ   = "UPDATE encounters SET round = $1, turn_index = $2, combat_started = $3 WHERE id = $4"
+	// Turn history backing PreviousTurn.
+	selectTimedQ   = "SELECT id, encounter_id, character_id, condition, duration_rounds, level, COALESCE(note, '') FROM encounter_character_conditions WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL"
+	pushHistoryQ   = "INSERT INTO encounter_turn_history (encounter_id, snapshot) VALUES ($1, $2)"
+	popHistoryQ    = "SELECT id, snapshot FROM encounter_turn_history WHERE encounter_id = $1 ORDER BY id DESC LIMIT 1 FOR UPDATE"
+	clearHistoryQ  = "DELETE FROM encounter_turn_history WHERE encounter_id = $1"
+	deleteHistoryQ = "DELETE FROM encounter_turn_history WHERE id = $1"
+	restoreCondQ   = "INSERT INTO encounter_character_conditions (id, encounter_id, character_id, condition, duration_rounds, level, note)"
+	deleteLedgerQ  = "DELETE FROM encounter_ledger WHERE encounter_id = $1 AND id = ANY($2)"
+	// Start-of-turn legendary action refill, resource recharge and effect ticks.
+	rechargeResourcesQ = "SELECT er.id, er.character_id, c.name, er.name, er.current_uses, er.recharge, er.recharge_on FROM encounter_resources er JOIN characters c ON c.id = er.character_id WHERE er.encounter_id = $1 AND er.character_id = ANY($2) AND er.recharge IN ('turn_start', 'recharge') AND er.current_uses < er.max_uses ORDER BY er.id FOR UPDATE OF er"
+	refillLegendaryQ   = "UPDATE encounter_characters ec SET legendary_actions = ec.legendary_actions_max FROM encounter_characters old WHERE old.encounter_id = ec.encounter_id AND old.character_id = ec.character_id AND ec.encounter_id = $1 AND ec.character_id = ANY($2) AND ec.legendary_actions < ec.legendary_actions_max RETURNING ec.character_id, old.legendary_actions"
+	tickEffectsQ       = "SELECT " + effectColumns + " FROM encounter_effects WHERE encounter_id = $1 AND duration_rounds IS NOT NULL AND ((tick_at = 'start' AND tick_character_id = ANY($2)) OR (tick_at = 'end' AND tick_character_id = ANY($3))) ORDER BY id FOR UPDATE"
+	// DM-chosen tiebreak order.
+	clearTiebreakQ = "UPDATE encounter_characters SET tiebreak_order = NULL WHERE encounter_id = $1"
+	setTiebreakQ   = "UPDATE encounter_characters SET tiebreak_order = $1 WHERE encounter_id = $2 AND character_id = $3"
+	// Server-side initiative rolls.
+	selectRollersQ = "SELECT ec.character_id, c.name, c.type, COALESCE(ec.initiative, 0) = 0, " + dexModifierSQL + ", " + groupSQL + " FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1 ORDER BY ec.character_id ASC FOR UPDATE OF ec"
+	setInitiativeQ = "UPDATE encounter_characters SET initiative = $1, turn_rank = 0 WHERE encounter_id = $2 AND character_id = $3"
 )
 
 func q(s string) string { return regexp.QuoteMeta(s) }
 
+// expectTurnStart expects the start of the given combatants' turn, with none
+// of them short of legendary actions or holding a resource to recharge.
+func expectTurnStart(mock sqlmock.Sqlmock, encounterID int, characterIDs ...int) {
+	ids := make([]string, len(characterIDs))
+	for i, id := range characterIDs {
+		ids[i] = strconv.Itoa(id)
+	}
+	arg := "{" + strings.Join(ids, ",") + "}"
+	mock.ExpectQuery(q(resumeTurnsQ)).WithArgs(encounterID, arg).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id"}))
+	mock.ExpectQuery(q(refillLegendaryQ)).WithArgs(encounterID, arg).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "legendary_actions"}))
+	mock.ExpectQuery(q(rechargeResourcesQ)).WithArgs(encounterID, arg).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id", "name", "name", "current_uses", "recharge", "recharge_on"}))
+}
+
+// expectEffectTicks expects the lookup of the encounter effects that tick on
+// the turns starting and ending in an advance, with none due.
+func expectEffectTicks(mock sqlmock.Sqlmock, encounterID int, starting, ending string) {
+	mock.ExpectQuery(q(tickEffectsQ)).WithArgs(encounterID, starting, ending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "name", "description", "owner_id", "duration_rounds", "tick_character_id", "tick_at"}))
+}
+
+func stateRow(round, turnIndex int, started bool) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(round, turnIndex, started)
+}
+
+func policyRow(policy string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"skip_policy"}).AddRow(policy)
+}
+
+func conditionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "encounter_id", "character_id", "condition", "duration_rounds", "level", "note"})
+}
+
+// snapshotJSON is the history payload AdvanceTurn is expected to record.
+func snapshotJSON(t *testing.T, snapshot turnSnapshot) string {
+	t.Helper()
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("marshal snapshot: %v", err)
+	}
+	return string(data)
+}
+
+// activeRow is loadActiveTurn's result with characterID holding the turn (0
+// for no one) and no initiative event active.
+func activeRow(characterID int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"character_id", "event_id"}).AddRow(characterID, 0)
+}
+
+func slotRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"character_id", "initiative", "turn_rank", "dex_modifier", "tiebreak_order", "is_npc", "current_hp", "disabled", "held", "event_id", "group_id", "surprised"})
+}
+
+// orderedRows returns turn-order rows for ids with strictly descending
+// initiative, so compareTurnOrder keeps them in the order given. Everyone is a
+// healthy PC, so no skip policy passes over them.
 func orderedRows(ids ...int) *sqlmock.Rows {
-	rows := sqlmock.NewRows([]string{"character_id"})
-	for _, id := range ids {
-		rows.AddRow(id)
+	rows := slotRows()
+	for i, id := range ids {
+		rows.AddRow(id, len(ids)-i, 0, 0, nil, false, 10, false, false, 0, 0, false)
 	}
 	return rows
 }
@@ -39,21 +135,25 @@ func TestStartCombat_ActivatesTopOfInitiativeOrder(t *testing.T) {
 	defer db.Close()
 	dao := NewEncounterCharacterDAO(db)
 
-	// Rows arrive already sorted by the SELECT (initiative DESC, id ASC). The
-	// query expectation includes that ORDER BY, so the ordering contract is
-	// asserted here; the DAO must activate the first row (character 5).
+	// Rows carry descending initiative, so the DAO must activate the first row
+	// (character 5).
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectExec(q(releaseMarkersQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	// A fresh fight has nothing to rewind into.
+	mock.ExpectExec(q(clearHistoryQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 4))
 	mock.ExpectCommit()
 
-	active, err := dao.StartCombat(7)
+	turn, err := dao.StartCombat(7, nil)
 	if err != nil {
 		t.Fatalf("StartCombat returned error: %v", err)
 	}
-	if active != 5 {
-		t.Errorf("active character = %d, want 5", active)
+	if turn.ActiveCharacterID != 5 || turn.Round != 1 || turn.TurnIndex != 0 {
+		t.Errorf("turn = %+v, want character 5 in round 1, slot 0", turn)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unmet sqlmock expectations: %v", err)
@@ -69,10 +169,12 @@ func TestStartCombat_NoCharactersErrorsAndRollsBack(t *testing.T) {
 	dao := NewEncounterCharacterDAO(db)
 
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectExec(q(releaseMarkersQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows())
 	mock.ExpectRollback()
 
-	if _, err := dao.StartCombat(7); err == nil {
+	if _, err := dao.StartCombat(7, nil); err == nil {
 		t.Fatal("expected an error when the encounter has no characters")
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -89,11 +191,13 @@ func TestStartCombat_RollsBackWhenUpdateFails(t *testing.T) {
 	dao := NewEncounterCharacterDAO(db)
 
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectExec(q(releaseMarkersQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnError(errors.New("boom"))
 	mock.ExpectRollback()
 
-	if _, err := dao.StartCombat(7); err == nil {
+	if _, err := dao.StartCombat(7, nil); err == nil {
 		t.Fatal("expected StartCombat to surface the update error")
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -109,24 +213,33 @@ func TestAdvanceTurn_MovesToNextCombatant(t *testing.T) {
 	defer db.Close()
 	dao := NewEncounterCharacterDAO(db)
 
-	// Order [5,2,8], currently active 5 -> next is 2.
+	// Order [5,2,8], currently active 5 -> next is 2, still round 3.
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 0, true))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
 	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).
-		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(5))
+		WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 2)
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 5).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{2}", "{5}")
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{ActiveCharacterID: 5, State: CombatState{Round: 3, TurnIndex: 0, CombatStarted: true}})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	// Conditions tick on the outgoing creature (5), whose turn is ending.
 	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	next, err := dao.AdvanceTurn(7)
+	turn, err := dao.AdvanceTurn(7, nil, nil)
 	if err != nil {
 		t.Fatalf("AdvanceTurn returned error: %v", err)
 	}
-	if next != 2 {
-		t.Errorf("next character = %d, want 2", next)
+	if turn.ActiveCharacterID != 2 || turn.Round != 3 || turn.TurnIndex != 1 {
+		t.Errorf("turn = %+v, want character 2 in round 3, slot 1", turn)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unmet sqlmock expectations: %v", err)
@@ -141,24 +254,34 @@ func TestAdvanceTurn_WrapsFromLastToFirst(t *testing.T) {
 	defer db.Close()
 	dao := NewEncounterCharacterDAO(db)
 
-	// Order [5,2,8], currently active 8 (last) -> wraps to 5.
+	// Order [5,2,8], currently active 8 (last) -> wraps to 5 and opens round 4.
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 2, true))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
 	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).
-		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(8))
+		WillReturnRows(activeRow(8))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 5)
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 8).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{5}", "{8}")
+	mock.ExpectExec(q(pushHistoryQ)).WithArgs(7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 	// Conditions tick on the outgoing creature (8), whose turn is ending.
 	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(4, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	next, err := dao.AdvanceTurn(7)
+	turn, err := dao.AdvanceTurn(7, nil, nil)
 	if err != nil {
 		t.Fatalf("AdvanceTurn returned error: %v", err)
 	}
-	if next != 5 {
-		t.Errorf("next character = %d, want 5 (wrap-around)", next)
+	if turn.ActiveCharacterID != 5 {
+		t.Errorf("next character = %d, want 5 (wrap-around)", turn.ActiveCharacterID)
+	}
+	if turn.Round != 4 {
+		t.Errorf("round = %d, want 4 after wrapping", turn.Round)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unmet sqlmock expectations: %v", err)
@@ -173,22 +296,60 @@ func TestAdvanceTurn_NoActiveStartsAtFirst(t *testing.T) {
 	defer db.Close()
 	dao := NewEncounterCharacterDAO(db)
 
-	// No one active yet (MAX returns 0) -> first combatant becomes active. With no
-	// outgoing creature, no conditions are ticked, so none are expected here.
+	// No one active yet (MAX returns 0) -> first combatant becomes active in round
+	// 1. With no outgoing creature, no conditions are ticked, so none are expected.
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
 	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).
-		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(0))
+		WillReturnRows(activeRow(0))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 5)
+	expectEffectTicks(mock, 7, "{5}", "{}")
+	// Even the opening advance is recorded, so it too can be rewound.
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{State: CombatState{}})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	next, err := dao.AdvanceTurn(7)
+	turn, err := dao.AdvanceTurn(7, nil, nil)
 	if err != nil {
 		t.Fatalf("AdvanceTurn returned error: %v", err)
 	}
-	if next != 5 {
-		t.Errorf("next character = %d, want 5", next)
+	if turn.ActiveCharacterID != 5 || turn.Round != 1 {
+		t.Errorf("turn = %+v, want character 5 in round 1", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_StaleExpectationConflictsAndRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// The caller still thinks 5 is up, but a co-DM already passed the turn to 2.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 1, true))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(2))
+	mock.ExpectRollback()
+
+	expected := 5
+	_, err = dao.AdvanceTurn(7, &expected, nil)
+	var conflict *TurnConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("err = %v, want a TurnConflictError", err)
+	}
+	want := TurnResult{ActiveCharacterID: 2, Round: 3, TurnIndex: 1}
+	if !reflect.DeepEqual(conflict.Current, want) {
+		t.Errorf("current = %+v, want %+v", conflict.Current, want)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("unmet sqlmock expectations: %v", err)
@@ -204,10 +365,11 @@ func TestAdvanceTurn_NoCharactersErrorsAndRollsBack(t *testing.T) {
 	dao := NewEncounterCharacterDAO(db)
 
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
 	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows())
 	mock.ExpectRollback()
 
-	if _, err := dao.AdvanceTurn(7); err == nil {
+	if _, err := dao.AdvanceTurn(7, nil, nil); err == nil {
 		t.Fatal("expected an error when the encounter has no characters")
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -224,11 +386,15 @@ func TestSetActiveCharacter_ActivatesOneClearsRest(t *testing.T) {
 	dao := NewEncounterCharacterDAO(db)
 
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Jumping the turn keeps the round but moves turn_index to 2's slot.
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	if err := dao.SetActiveCharacter(7, 2); err != nil {
+	if err := dao.SetActiveCharacter(7, 2, nil); err != nil {
 		t.Fatalf("SetActiveCharacter returned error: %v", err)
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -247,11 +413,12 @@ func TestSetActiveCharacter_NotInEncounterErrorsAndRollsBack(t *testing.T) {
 	// The target isn't a combatant, so the activating UPDATE hits no rows and the
 	// transaction must roll back rather than leave the encounter with no active.
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 99).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectRollback()
 
-	if err := dao.SetActiveCharacter(7, 99); err == nil {
+	if err := dao.SetActiveCharacter(7, 99, nil); err == nil {
 		t.Fatal("expected an error when the character is not in the encounter")
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -268,10 +435,11 @@ func TestSetActiveCharacter_RollsBackWhenClearFails(t *testing.T) {
 	dao := NewEncounterCharacterDAO(db)
 
 	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnError(errors.New("boom"))
 	mock.ExpectRollback()
 
-	if err := dao.SetActiveCharacter(7, 2); err == nil {
+	if err := dao.SetActiveCharacter(7, 2, nil); err == nil {
 		t.Fatal("expected SetActiveCharacter to surface the update error")
 	}
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -287,7 +455,13 @@ func TestResetCombat_DeactivatesEveryone(t *testing.T) {
 	defer db.Close()
 	dao := NewEncounterCharacterDAO(db)
 
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 1, true))
 	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(0, 0, false, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(releaseMarkersQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(clearHistoryQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
 
 	if err := dao.ResetCombat(7); err != nil {
 		t.Fatalf("ResetCombat returned error: %v", err)
@@ -296,3 +470,767 @@ func TestResetCombat_DeactivatesEveryone(t *testing.T) {
 		t.Errorf("unmet sqlmock expectations: %v", err)
 	}
 }
+
+func TestPreviousTurn_RestoresActiveRoundAndExpiredConditions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// The advance being undone moved the turn off character 8 in round 3 (slot
+	// 2). Its Poisoned had 1 round left, so the tick deleted it; Blessed had 3.
+	one, three := 1, 3
+	snapshot := turnSnapshot{
+		ActiveCharacterID: 8,
+		State:             CombatState{Round: 3, TurnIndex: 2, CombatStarted: true},
+		Conditions: []Condition{
+			{ID: 40, EncounterID: 7, CharacterID: 8, Condition: "Poisoned", DurationRounds: &one},
+			{ID: 41, EncounterID: 7, CharacterID: 8, Condition: "Blessed", DurationRounds: &three, Note: "cleric"},
+		},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 0, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(12, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q(restoreCondQ)).WithArgs(40, 7, 8, "Poisoned", 1, nil, "").
+		WillReturnResult(sqlmock.NewResult(40, 1))
+	mock.ExpectExec(q(restoreCondQ)).WithArgs(41, 7, 8, "Blessed", 3, nil, "cleric").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 2, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(12).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.PreviousTurn(7, nil)
+	if err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 8 || turn.Round != 3 || turn.TurnIndex != 2 {
+		t.Errorf("turn = %+v, want character 8 in round 3, slot 2", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPreviousTurn_SkipsCombatantThatLeft(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Character 8's turn ended, expiring its Poisoned and refilling nothing;
+	// then 8 left the encounter. The rewind writes nothing for 8 and leaves no
+	// one active rather than failing.
+	one := 1
+	snapshot := turnSnapshot{
+		ActiveCharacterID: 8,
+		State:             CombatState{Round: 3, TurnIndex: 2, CombatStarted: true},
+		Conditions:        []Condition{{ID: 40, EncounterID: 7, CharacterID: 8, Condition: "Poisoned", DurationRounds: &one}},
+		Legendary:         []legendarySnapshot{{CharacterID: 8, Actions: 1}},
+	}
+	mock.ExpectExec(q("DELETE FROM encounter_characters WHERE encounter_id = $1 AND character_id = $2")).WithArgs(7, 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 0, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(12, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q(restoreCondQ+" SELECT $1::int, ec.encounter_id, ec.character_id")).WithArgs(40, 7, 8, "Poisoned", 1, nil, "").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q("UPDATE encounter_characters SET legendary_actions = $1")).WithArgs(1, 7, 8).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 2, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(12).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := NewEncounterDAO(db).RemoveCharacterFromEncounter(7, 8); err != nil {
+		t.Fatalf("RemoveCharacterFromEncounter returned error: %v", err)
+	}
+	turn, err := dao.PreviousTurn(7, nil)
+	if err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 0 {
+		t.Errorf("turn = %+v, want no one active", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPreviousTurn_UndoingFirstAdvanceClearsActive(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(1, 0, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(3, []byte(snapshotJSON(t, turnSnapshot{}))))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(0, 0, false, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.PreviousTurn(7, nil)
+	if err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 0 || turn.Round != 0 {
+		t.Errorf("turn = %+v, want no one active before round 1", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPreviousTurn_NoHistoryErrorsAndRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	if _, err := dao.PreviousTurn(7, nil); err == nil {
+		t.Fatal("expected an error when there is no turn to rewind")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestStartCombat_BreaksInitiativeTieOnDexterity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// 2 and 5 both rolled 15; 5's +2 Dexterity wins the tie over the lower id.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectExec(q(releaseMarkersQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(
+		slotRows().
+			AddRow(2, 15, 0, 0, nil, false, 10, false, false, 0, 0, false).
+			AddRow(5, 15, 0, 2, nil, false, 10, false, false, 0, 0, false))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(clearHistoryQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	turn, err := dao.StartCombat(7, nil)
+	if err != nil {
+		t.Fatalf("StartCombat returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 5 {
+		t.Errorf("active = %d, want 5", turn.ActiveCharacterID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSetTiebreakOrder_RecomputesTurnIndexMidCombat(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(q(clearTiebreakQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(setTiebreakQ)).WithArgs(0, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(setTiebreakQ)).WithArgs(1, 7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	zero, one := 0, 1
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(
+		slotRows().
+			AddRow(2, 15, 0, 0, &one, false, 10, false, false, 0, 0, false).
+			AddRow(5, 15, 0, 0, &zero, false, 10, false, false, 0, 0, false))
+	// Character 2 holds the turn and now sorts second.
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(2))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := dao.SetTiebreakOrder(7, []int{5, 2}); err != nil {
+		t.Fatalf("SetTiebreakOrder returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSetTiebreakOrder_UnknownCharacterRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(q(clearTiebreakQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(setTiebreakQ)).WithArgs(0, 7, 99).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := dao.SetTiebreakOrder(7, []int{99}); err == nil {
+		t.Fatal("expected an error for a character outside the encounter")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+// fixedD20 returns the given rolls in order, standing in for the server's die.
+func fixedD20(t *testing.T, rolls ...int) func() int {
+	t.Helper()
+	return func() int {
+		if len(rolls) == 0 {
+			t.Fatal("rolled more d20s than expected")
+		}
+		roll := rolls[0]
+		rolls = rolls[1:]
+		return roll
+	}
+}
+
+func TestRollInitiative_NPCScopeWithAdvantage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectRollersQ)).WithArgs(7).WillReturnRows(
+		sqlmock.NewRows([]string{"character_id", "name", "type", "unset", "dex_modifier", "group_id"}).
+			AddRow(2, "Aragorn", "pc", false, 2, 0).
+			AddRow(5, "Goblin", "npc", true, 2, 0).
+			AddRow(8, "Ogre", "npc", false, -1, 0))
+	// The PC is out of scope; the goblin rolls 4 and 15 with advantage, the ogre 9.
+	mock.ExpectExec(q(setInitiativeQ)).WithArgs(17, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(setInitiativeQ)).WithArgs(8, 7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 0, "initiative", 0, "Rolled initiative: Goblin 17 (adv 4/15 +2); Ogre 8 (d20 9 -1)", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "actor_id", "actor_name", "target_id", "target_name", "action_type", "hp_change", "description", "details", "created_at"}).
+			AddRow(1, 7, 0, "", 0, "", "initiative", 0, "", nil, "2026-01-01T00:00:00Z"))
+	mock.ExpectCommit()
+
+	rolls, err := dao.RollInitiative(7, InitiativeRollOptions{
+		Scope:   InitiativeScopeNPCs,
+		Modes:   map[int]RollMode{5: RollAdvantage},
+		RollD20: fixedD20(t, 4, 15, 9),
+	})
+	if err != nil {
+		t.Fatalf("RollInitiative returned error: %v", err)
+	}
+	if len(rolls) != 2 || rolls[0].CharacterID != 5 || rolls[0].Total != 17 || rolls[1].Total != 8 {
+		t.Errorf("rolls = %+v, want goblin 17 then ogre 8", rolls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRollInitiative_UnsetScopeIncludesZeroInitiative(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// The roster adds combatants with initiative 0, so the goblin added that
+	// way reads as unset alongside the ogre with none; the PC rolled 14.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectRollersQ)).WithArgs(7).WillReturnRows(
+		sqlmock.NewRows([]string{"character_id", "name", "type", "unset", "dex_modifier", "group_id"}).
+			AddRow(2, "Aragorn", "pc", false, 2, 0).
+			AddRow(5, "Goblin", "npc", true, 2, 0).
+			AddRow(8, "Ogre", "npc", true, -1, 0))
+	mock.ExpectExec(q(setInitiativeQ)).WithArgs(12, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(setInitiativeQ)).WithArgs(8, 7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 0, "initiative", 0, "Rolled initiative: Goblin 12 (d20 10 +2); Ogre 8 (d20 9 -1)", nil).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	rolls, err := dao.RollInitiative(7, InitiativeRollOptions{Scope: InitiativeScopeUnset, RollD20: fixedD20(t, 10, 9)})
+	if err != nil {
+		t.Fatalf("RollInitiative returned error: %v", err)
+	}
+	if len(rolls) != 2 || rolls[0].CharacterID != 5 || rolls[1].CharacterID != 8 {
+		t.Errorf("rolls = %+v, want the goblin and the ogre", rolls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRollInitiative_NothingInScopeRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectRollersQ)).WithArgs(7).WillReturnRows(
+		sqlmock.NewRows([]string{"character_id", "name", "type", "unset", "dex_modifier", "group_id"}).
+			AddRow(2, "Aragorn", "pc", false, 2, 0))
+	mock.ExpectRollback()
+
+	if _, err := dao.RollInitiative(7, InitiativeRollOptions{Scope: InitiativeScopeUnset, RollD20: fixedD20(t)}); err == nil {
+		t.Fatal("expected an error when no combatant is in scope")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_SkipsDefeatedNPCsAndTicksTheirConditions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Order [5,2,8] with goblin 2 at 0 HP: 5's turn ends, 2 is passed over
+	// (its turn still ends, so its conditions tick) and 8 takes the turn.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(5, 18, 0, 0, nil, false, 12, false, false, 0, 0, false).
+		AddRow(2, 12, 0, 0, nil, true, 0, false, false, 0, 0, false).
+		AddRow(8, 9, 0, 0, nil, true, 7, false, false, 0, 0, false))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).
+		WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyDefeatedNPCs))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 8)
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 5).WillReturnRows(conditionRows())
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 2).WillReturnRows(conditionRows().AddRow(41, 7, 2, "Poisoned", 1, nil, ""))
+	expectEffectTicks(mock, 7, "{8,2}", "{5,2}")
+	one := 1
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{
+			ActiveCharacterID: 5,
+			State:             CombatState{Round: 2, TurnIndex: 0, CombatStarted: true},
+			Conditions:        []Condition{{ID: 41, EncounterID: 7, CharacterID: 2, Condition: "Poisoned", DurationRounds: &one, Note: ""}},
+		})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 2, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 8 || turn.TurnIndex != 2 || len(turn.Skipped) != 1 || turn.Skipped[0] != 2 {
+		t.Errorf("turn = %+v, want character 8 in slot 2 with 2 skipped", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func ledgerRow() *sqlmock.Rows { return ledgerRowWithID(1) }
+
+func ledgerRowWithID(id int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "encounter_id", "actor_id", "actor_name", "target_id", "target_name", "action_type", "hp_change", "description", "details", "created_at"}).
+		AddRow(id, 7, 0, "", 0, "", "", 0, "", nil, "2026-01-01T00:00:00Z")
+}
+
+func TestHold_ActiveCombatantPassesTurnWithoutEndingIt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	fourteen := 14
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	mock.ExpectQuery(q(selectCombatantQ)).WithArgs(7, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"initiative", "turn_rank", "held"}).AddRow(14, 0, nil))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	mock.ExpectExec(q(setHeldQ)).WithArgs(HoldDelay, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(5, 14, 0, 0, nil, false, 10, false, true, 0, 0, false).
+		AddRow(2, 12, 0, 0, nil, false, 10, false, false, 0, 0, false))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 2)
+	expectEffectTicks(mock, 7, "{2}", "{}")
+	// No condition tick: the delayer's turn has not ended.
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{
+			ActiveCharacterID: 5,
+			State:             CombatState{Round: 2, TurnIndex: 0, CombatStarted: true},
+			Combatants:        []combatantSnapshot{{CharacterID: 5, Initiative: &fourteen}},
+		})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").WithArgs(7, 5, 0, HoldDelay, 0, "delays their turn", nil).WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	turn, err := dao.Hold(7, 5, HoldDelay, nil, nil)
+	if err != nil {
+		t.Fatalf("Hold returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 2 || turn.Round != 2 {
+		t.Errorf("turn = %+v, want character 2 still in round 2", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestHold_AlreadyHoldingRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	mock.ExpectQuery(q(selectCombatantQ)).WithArgs(7, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"initiative", "turn_rank", "held"}).AddRow(14, 0, HoldReady))
+	mock.ExpectRollback()
+
+	if _, err := dao.Hold(7, 5, HoldDelay, nil, nil); err == nil {
+		t.Fatal("expected an error for a combatant already holding")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRejoin_BeforeTakesTheTurnThenTheActorResumes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// 8 delayed from 20; 5 (active) and 2 share 14. 8 rejoins before 5, so the
+	// count-14 group is pinned as [8, 5, 2] with descending ranks.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 1, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(8, 20, 0, 0, nil, false, 10, false, true, 0, 0, false).
+		AddRow(5, 14, 0, 2, nil, false, 10, false, false, 0, 0, false).
+		AddRow(2, 14, 0, 0, nil, false, 10, false, false, 0, 0, false))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	for _, c := range []struct{ id, init, rank int }{{8, 20, 3}, {5, 14, 2}, {2, 14, 1}} {
+		mock.ExpectQuery(q(selectCombatantQ)).WithArgs(7, c.id).
+			WillReturnRows(sqlmock.NewRows([]string{"initiative", "turn_rank", "held"}).AddRow(c.init, 0, nil))
+		mock.ExpectExec(q(rejoinSlotQ)).WithArgs(14, c.rank, 7, c.id).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(refillLegendaryQ)).WithArgs(7, "{8}").
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "legendary_actions"}))
+	mock.ExpectQuery(q(rechargeResourcesQ)).WithArgs(7, "{8}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id", "name", "name", "current_uses", "recharge", "recharge_on"}))
+	mock.ExpectQuery(q(tickEffectsQ)).WithArgs(7, "{8}", "{}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "name", "description", "owner_id", "duration_rounds", "tick_character_id", "tick_at"}))
+	mock.ExpectExec(q(setResumingQ)).WithArgs(true, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(pushHistoryQ)).WithArgs(7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(8, 14, 3, 0, nil, false, 10, false, false, 0, 0, false).
+		AddRow(5, 14, 2, 2, nil, false, 10, false, false, 0, 0, false).
+		AddRow(2, 14, 1, 0, nil, false, 10, false, false, 0, 0, false))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").WithArgs(7, 8, 5, "rejoin", 0, sqlmock.AnyArg(), nil).WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	turn, err := dao.Rejoin(7, 8, RejoinBefore, nil, nil)
+	if err != nil {
+		t.Fatalf("Rejoin returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 8 || turn.TurnIndex != 0 {
+		t.Errorf("turn = %+v, want character 8 in slot 0", turn)
+	}
+
+	// 8's turn ends and 5's interrupted turn resumes: it has already started,
+	// so nothing refills or recharges for 5 and only 8's end-of-turn effects tick.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(8, 14, 3, 0, nil, false, 10, false, false, 0, 0, false).
+		AddRow(5, 14, 2, 2, nil, false, 10, false, false, 0, 0, false).
+		AddRow(2, 14, 1, 0, nil, false, 10, false, false, 0, 0, false))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(8))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(resumeTurnsQ)).WithArgs(7, "{5}").WillReturnRows(sqlmock.NewRows([]string{"character_id"}).AddRow(5))
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 8).WillReturnRows(conditionRows())
+	mock.ExpectQuery(q(tickEffectsQ)).WithArgs(7, "{}", "{8}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "name", "description", "owner_id", "duration_rounds", "tick_character_id", "tick_at"}))
+	resumed := turnSnapshot{ActiveCharacterID: 8, State: CombatState{Round: 2, TurnIndex: 0, CombatStarted: true}, Resumed: []int{5}}
+	mock.ExpectExec(q(pushHistoryQ)).WithArgs(7, snapshotJSON(t, resumed)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	next, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if next.ActiveCharacterID != 5 || next.TurnIndex != 1 {
+		t.Errorf("turn = %+v, want character 5 resuming in slot 1", next)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPreviousTurn_RestoresHeldCombatant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	fourteen := 14
+	snapshot := turnSnapshot{
+		ActiveCharacterID: 5,
+		State:             CombatState{Round: 2, TurnIndex: 0, CombatStarted: true},
+		Combatants:        []combatantSnapshot{{CharacterID: 5, Initiative: &fourteen}},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 1, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(4, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q(restoreCombatantQ)).WithArgs(&fourteen, 0, nil, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(2, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.PreviousTurn(7, nil)
+	if err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 5 {
+		t.Errorf("active = %d, want 5 back on their turn", turn.ActiveCharacterID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_GroupTurnEndsForEveryMember(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Goblins 2 and 3 (template 4) act together on 15; the group is active.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(1, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(3, 15, 0, 2, nil, true, 7, false, false, 0, 4, false).
+		AddRow(8, 10, 0, 0, nil, false, 10, false, false, 0, 0, false).
+		AddRow(2, 15, 0, 2, nil, true, 7, false, false, 0, 4, false))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(3))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 8)
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 2).WillReturnRows(conditionRows())
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 3).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{8}", "{2,3}")
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{ActiveCharacterID: 3, State: CombatState{Round: 1, TurnIndex: 0, CombatStarted: true}})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	// Conditions tick on both goblins as the group's turn ends.
+	for _, id := range []int{2, 3} {
+		mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, id).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, id).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 2, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 8 || turn.TurnIndex != 2 {
+		t.Errorf("turn = %+v, want character 8 in slot 2", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestSetInitiativeGroup_SharesFirstInitiativeAndActiveTurn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("SELECT ec.character_id, ec.initiative, ec.held IS NOT NULL, ec.is_active FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND c.npc_template_id = $2 ORDER BY ec.character_id ASC FOR UPDATE OF ec")).
+		WithArgs(7, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "initiative", "held", "is_active"}).
+			AddRow(2, nil, false, false).
+			AddRow(3, 12, false, true).
+			AddRow(5, 9, false, false))
+	mock.ExpectExec(q("UPDATE encounter_characters ec SET grouped = $1, initiative = COALESCE($2, ec.initiative) FROM characters c WHERE c.id = ec.character_id AND ec.encounter_id = $3 AND c.npc_template_id = $4")).
+		WithArgs(true, 12, 7, 4).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	// 3 held the turn, so the whole group now does.
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 3).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectCommit()
+
+	if err := dao.SetInitiativeGroup(7, 4, true); err != nil {
+		t.Fatalf("SetInitiativeGroup returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestStartCombat_SurprisedCombatantLosesFirstTurn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// 5 tops the order but is surprised, so 2 acts first and 5's skipped turn
+	// ends (and its surprise clears) straight away. 8 stays surprised until its
+	// own turn comes round.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(0, 0, false))
+	mock.ExpectExec(q(releaseMarkersQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, c := range []struct {
+		id   int
+		name string
+	}{{5, "Goblin"}, {8, "Ogre"}} {
+		mock.ExpectQuery(q("UPDATE encounter_characters ec SET surprised = TRUE FROM characters c WHERE c.id = ec.character_id AND ec.encounter_id = $1 AND ec.character_id = $2 RETURNING c.name")).
+			WithArgs(7, c.id).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(c.name))
+		mock.ExpectQuery("INSERT INTO encounter_ledger").
+			WithArgs(7, c.id, 0, "surprised", 0, c.name+" is surprised and loses their first turn", nil).
+			WillReturnRows(ledgerRow())
+	}
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(5, 18, 0, 0, nil, true, 7, false, false, 0, 0, true).
+		AddRow(2, 15, 0, 0, nil, false, 10, false, false, 0, 0, false).
+		AddRow(8, 9, 0, 0, nil, true, 30, false, false, 0, 0, true))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(clearSurpriseQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(clearHistoryQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	turn, err := dao.StartCombat(7, []int{5, 8})
+	if err != nil {
+		t.Fatalf("StartCombat returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 2 || turn.TurnIndex != 1 || len(turn.Skipped) != 1 || turn.Skipped[0] != 5 {
+		t.Errorf("turn = %+v, want character 2 in slot 1 with 5 skipped", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_SkipsSurprisedAndClearsTheMarker(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(1, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(slotRows().
+		AddRow(2, 15, 0, 0, nil, false, 10, false, false, 0, 0, false).
+		AddRow(8, 9, 0, 0, nil, true, 30, false, false, 0, 0, true).
+		AddRow(4, 3, 0, 0, nil, false, 10, false, false, 0, 0, false))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(2))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 4)
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 2).WillReturnRows(conditionRows())
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 8).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{4,8}", "{2,8}")
+	// The snapshot remembers 8's surprise so PreviousTurn can put it back.
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{ActiveCharacterID: 2, State: CombatState{Round: 1, TurnIndex: 0, CombatStarted: true}, Surprised: []int{8}})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, id := range []int{2, 8} {
+		mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, id).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, id).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	mock.ExpectExec(q(clearSurpriseQ)).WithArgs(7, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 2, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 4 || len(turn.Skipped) != 1 || turn.Skipped[0] != 8 {
+		t.Errorf("turn = %+v, want character 4 with 8 skipped", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package dao
 import (
 	"database/sql"
 	"slices"
+	"strings"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
@@ -42,13 +43,16 @@ var ValidConditions = []string{
 	"Unconscious",
 }
 
-// MaxExhaustionLevel is the 5e cap: a sixth level of exhaustion kills the creature.
+// MaxExhaustionLevel is the 5e cap, under both rulesets: a sixth level of
+// exhaustion kills the creature.
 const MaxExhaustionLevel = 6
 
-// conditionMaxLevels lists the conditions that track a level and how high it
-// goes. Anything absent is binary — applied or not — and must carry a nil Level.
-var conditionMaxLevels = map[string]int{
-	"Exhaustion": MaxExhaustionLevel,
+// conditionMaxLevels lists, per ruleset, the conditions that track a level and
+// how high it goes. Anything absent is binary — applied or not — and must
+// carry a nil Level.
+var conditionMaxLevels = map[string]map[string]int{
+	Ruleset2014: {"Exhaustion": MaxExhaustionLevel},
+	Ruleset2024: {"Exhaustion": MaxExhaustionLevel},
 }
 
 // ExhaustionEffects describes what each level of exhaustion does (5e, 2014
@@ -63,47 +67,116 @@ var ExhaustionEffects = []string{
 	"Death",
 }
 
+// ExhaustionEffects2024 is ExhaustionEffects under the 2024 rules, where each
+// level is a flat penalty rather than a new effect.
+var ExhaustionEffects2024 = []string{
+	"-2 to d20 tests, speed reduced by 5 feet",
+	"-4 to d20 tests, speed reduced by 10 feet",
+	"-6 to d20 tests, speed reduced by 15 feet",
+	"-8 to d20 tests, speed reduced by 20 feet",
+	"-10 to d20 tests, speed reduced by 25 feet",
+	"Death",
+}
+
+// rulesetOrDefault maps an unknown or empty ruleset to the 2014 rules, which
+// every encounter played before rulesets existed.
+func rulesetOrDefault(ruleset string) string {
+	if ruleset == Ruleset2024 {
+		return Ruleset2024
+	}
+	return Ruleset2014
+}
+
 // ConditionInfo is the catalog entry for one condition. MaxLevel is 0 for
 // ordinary binary conditions and >0 for leveled ones, which lets the frontend
-// decide whether to prompt for a level.
+// decide whether to prompt for a level. Homebrew marks a user's custom
+// condition (see CustomCondition), which also carries its own Description.
+// Implies lists the conditions this one brings with it, and Effects its
+// mechanics; a leveled condition has LevelMechanics instead, the combined
+// effects at each level.
 type ConditionInfo struct {
-	Name         string   `json:"Name"`
-	MaxLevel     int      `json:"MaxLevel"`
-	LevelEffects []string `json:"LevelEffects,omitempty"`
+	Name           string             `json:"Name"`
+	Description    string             `json:"Description,omitempty"`
+	MaxLevel       int                `json:"MaxLevel"`
+	LevelEffects   []string           `json:"LevelEffects,omitempty"`
+	Homebrew       bool               `json:"Homebrew,omitempty"`
+	Implies        []string           `json:"Implies,omitempty"`
+	Effects        *ConditionEffects  `json:"Effects,omitempty"`
+	LevelMechanics []ConditionEffects `json:"LevelMechanics,omitempty"`
 }
 
-// ConditionCatalog returns every valid condition with its level metadata.
-func ConditionCatalog() []ConditionInfo {
+// IsValidLevel reports whether level is acceptable for the condition: leveled
+// conditions require 1..MaxLevel, binary ones require nil.
+func (c ConditionInfo) IsValidLevel(level *int) bool {
+	if c.MaxLevel == 0 {
+		return level == nil
+	}
+	return level != nil && *level >= 1 && *level <= c.MaxLevel
+}
+
+// ConditionCatalog returns every valid condition with its level metadata and
+// mechanics under ruleset.
+func ConditionCatalog(ruleset string) []ConditionInfo {
 	catalog := make([]ConditionInfo, 0, len(ValidConditions))
 	for _, name := range ValidConditions {
-		info := ConditionInfo{Name: name, MaxLevel: conditionMaxLevels[name]}
-		if name == "Exhaustion" {
-			info.LevelEffects = ExhaustionEffects
-		}
+		info, _ := LookupCondition(ruleset, name)
 		catalog = append(catalog, info)
 	}
 	return catalog
 }
 
+// MergeConditionCatalog returns the canonical catalog followed by the homebrew
+// conditions, skipping any whose name is already taken so a condition always
+// means one thing.
+func MergeConditionCatalog(ruleset string, homebrew []CustomCondition) []ConditionInfo {
+	catalog := ConditionCatalog(ruleset)
+	for _, custom := range homebrew {
+		if !slices.ContainsFunc(catalog, func(info ConditionInfo) bool { return strings.EqualFold(info.Name, custom.Name) }) {
+			catalog = append(catalog, custom.Info())
+		}
+	}
+	return catalog
+}
+
+// LookupCondition returns the catalog entry for a canonical 5e condition
+// under ruleset, reporting false for anything else.
+func LookupCondition(ruleset, name string) (ConditionInfo, bool) {
+	if !IsValidCondition(name) {
+		return ConditionInfo{}, false
+	}
+	ruleset = rulesetOrDefault(ruleset)
+	info := ConditionInfo{Name: name, MaxLevel: conditionMaxLevels[ruleset][name], Implies: conditionImplies[name]}
+	switch {
+	case name == "Exhaustion" && ruleset == Ruleset2024:
+		info.LevelEffects = ExhaustionEffects2024
+		info.LevelMechanics = exhaustionMechanics(ruleset)
+	case name == "Exhaustion":
+		info.LevelEffects = ExhaustionEffects
+		info.LevelMechanics = exhaustionMechanics(ruleset)
+	default:
+		if effects, ok := conditionEffects[ruleset][name]; ok {
+			info.Effects = &effects
+		}
+	}
+	return info, true
+}
+
 // IsValidCondition reports whether name is a recognized 5e condition.
 func IsValidCondition(name string) bool {
 	return slices.Contains(ValidConditions, name)
 }
 
-// ConditionMaxLevel returns the highest level name supports, or 0 if it is a
-// binary condition (or not a condition at all).
-func ConditionMaxLevel(name string) int {
-	return conditionMaxLevels[name]
+// ConditionMaxLevel returns the highest level name supports under ruleset, or
+// 0 if it is a binary condition (or not a condition at all).
+func ConditionMaxLevel(ruleset, name string) int {
+	return conditionMaxLevels[rulesetOrDefault(ruleset)][name]
 }
 
 // IsValidConditionLevel reports whether level is acceptable for the named
-// condition: leveled conditions require 1..MaxLevel, binary ones require nil.
-func IsValidConditionLevel(name string, level *int) bool {
-	max := conditionMaxLevels[name]
-	if max == 0 {
-		return level == nil
-	}
-	return level != nil && *level >= 1 && *level <= max
+// condition under ruleset: leveled conditions require 1..MaxLevel, binary ones
+// require nil.
+func IsValidConditionLevel(ruleset, name string, level *int) bool {
+	return ConditionInfo{MaxLevel: ConditionMaxLevel(ruleset, name)}.IsValidLevel(level)
 }
 
 type EncounterConditionDAO interface {
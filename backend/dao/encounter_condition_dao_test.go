@@ -158,7 +158,7 @@ func TestIsValidConditionLevel(t *testing.T) {
 		{"binary with level", "Prone", level(2), false},
 	}
 	for _, tc := range cases {
-		if got := IsValidConditionLevel(tc.cond, tc.level); got != tc.want {
+		if got := IsValidConditionLevel(Ruleset2014, tc.cond, tc.level); got != tc.want {
 			t.Errorf("%s: IsValidConditionLevel(%q, %v) = %v, want %v", tc.name, tc.cond, tc.level, got, tc.want)
 		}
 	}
@@ -167,7 +167,7 @@ func TestIsValidConditionLevel(t *testing.T) {
 // The catalog must tell the frontend which conditions are leveled; without
 // MaxLevel it cannot know to prompt for one.
 func TestConditionCatalogMarksExhaustionLeveled(t *testing.T) {
-	catalog := ConditionCatalog()
+	catalog := ConditionCatalog(Ruleset2014)
 	if len(catalog) != len(ValidConditions) {
 		t.Fatalf("catalog has %d entries, want %d", len(catalog), len(ValidConditions))
 	}
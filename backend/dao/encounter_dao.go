@@ -23,67 +23,122 @@ type EncounterDAO interface {
 	RemoveMember(encounterID int, userID string) error
 	IsMember(encounterID int, userID string) (bool, error)
 	ListMembers(encounterID int) ([]Friend, error)
+	// UpdateSettings changes an encounter's combat settings; nil fields are
+	// left as they are. Returns false when the encounter does not exist.
+	UpdateSettings(encounterID int, settings EncounterSettingsUpdate) (bool, error)
 }
 type Encounter struct {
 	ID          int
 	Name        string
 	OwnerID     string
 	Description string
+	CombatState
+	// SkipPolicy is one of the SkipPolicy* constants.
+	SkipPolicy string
+	// Ruleset is one of the Ruleset* constants.
+	Ruleset string
+}
+
+// Which combatants AdvanceTurn passes over.
+const (
+	SkipPolicyNone         = "none"
+	SkipPolicyDefeatedNPCs = "defeated_npcs" // NPCs at 0 HP
+	SkipPolicyDisabled     = "disabled"      // anyone Unconscious or Petrified
+)
+
+// ValidSkipPolicies lists the accepted Encounter.SkipPolicy values.
+var ValidSkipPolicies = []string{SkipPolicyNone, SkipPolicyDefeatedNPCs, SkipPolicyDisabled}
+
+// Which edition of the 5e rules an encounter plays. It decides the exhaustion
+// table and the condition effects (see ConditionCatalog).
+const (
+	Ruleset2014 = "2014"
+	Ruleset2024 = "2024"
+)
+
+// ValidRulesets lists the accepted Encounter.Ruleset values.
+var ValidRulesets = []string{Ruleset2014, Ruleset2024}
+
+// EncounterSettingsUpdate is a partial update of an encounter's settings.
+type EncounterSettingsUpdate struct {
+	SkipPolicy *string
+	Ruleset    *string
 }
 
-type encounterDAOImpl struct {
-	db *sql.DB
+// CombatState is an encounter's position in combat. Round is 1-based once
+// combat has started and 0 before; TurnIndex is the active combatant's slot in
+// the current initiative order, so "Round 3, slot 2" survives a page reload and
+// the ledger and UI need not infer a new round from the turn wrapping around.
+type CombatState struct {
+	Round         int
+	TurnIndex     int
+	CombatStarted bool
 }
 
-func NewEncounterDAO(db *sql.DB) EncounterDAO {
-	return &encounterDAOImpl{db: db}
+// encounterColumns is the select list every encounter read shares, in the
+// order scanEncounter expects.
+const encounterColumns = "id, name, COALESCE(owner_id, ''), COALESCE(description, ''), round, turn_index, combat_started, skip_policy, ruleset"
+
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (dao *encounterDAOImpl) GetAllEncounters() ([]Encounter, error) {
-	rows, err := dao.db.Query("SELECT id, name, COALESCE(owner_id, ''), COALESCE(description, '') FROM encounters")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+func scanEncounter(row rowScanner) (Encounter, error) {
+	var e Encounter
+	err := row.Scan(&e.ID, &e.Name, &e.OwnerID, &e.Description, &e.Round, &e.TurnIndex, &e.CombatStarted, &e.SkipPolicy, &e.Ruleset)
+	return e, err
+}
 
+func scanEncounters(rows *sql.Rows) ([]Encounter, error) {
+	defer rows.Close()
 	var encounters []Encounter
 	for rows.Next() {
-		var e Encounter
-		err := rows.Scan(&e.ID, &e.Name, &e.OwnerID, &e.Description)
+		e, err := scanEncounter(rows)
 		if err != nil {
 			return nil, err
 		}
 		encounters = append(encounters, e)
 	}
-	return encounters, nil
+	return encounters, rows.Err()
 }
 
-func (dao *encounterDAOImpl) GetUnownedEncounters() ([]Encounter, error) {
-	rows, err := dao.db.Query("SELECT id, name, COALESCE(owner_id, ''), COALESCE(description, '') FROM encounters WHERE owner_id IS NULL OR owner_id = '' ORDER BY id")
+type encounterDAOImpl struct {
+	db *sql.DB
+}
+
+func NewEncounterDAO(db *sql.DB) EncounterDAO {
+	return &encounterDAOImpl{db: db}
+}
+
+func (dao *encounterDAOImpl) GetAllEncounters() ([]Encounter, error) {
+	rows, err := dao.db.Query("SELECT " + encounterColumns + " FROM encounters")
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanEncounters(rows)
+}
 
-	var encounters []Encounter
-	for rows.Next() {
-		var e Encounter
-		err := rows.Scan(&e.ID, &e.Name, &e.OwnerID, &e.Description)
-		if err != nil {
-			return nil, err
-		}
-		encounters = append(encounters, e)
+func (dao *encounterDAOImpl) GetUnownedEncounters() ([]Encounter, error) {
+	rows, err := dao.db.Query("SELECT " + encounterColumns + " FROM encounters WHERE owner_id IS NULL OR owner_id = '' ORDER BY id")
+	if err != nil {
+		return nil, err
 	}
-	return encounters, nil
+	return scanEncounters(rows)
 }
 
+// CreateEncounter inserts an encounter, playing the 2014 rules unless it
+// names a Ruleset.
 func (dao *encounterDAOImpl) CreateEncounter(encounter Encounter) (int, error) {
+	if encounter.Ruleset == "" {
+		encounter.Ruleset = Ruleset2014
+	}
 	var newID int
 	err := dao.db.QueryRow(
-		"INSERT INTO encounters (name, owner_id, description) VALUES ($1, $2, $3) RETURNING id",
+		"INSERT INTO encounters (name, owner_id, description, ruleset) VALUES ($1, $2, $3, $4) RETURNING id",
 		encounter.Name,
 		encounter.OwnerID,
 		encounter.Description,
+		encounter.Ruleset,
 	).Scan(&newID)
 	return newID, err
 }
@@ -102,40 +157,32 @@ func (dao *encounterDAOImpl) DeleteEncounterByOwner(id int, ownerID string) (boo
 	return rows > 0, err
 }
 
+// AddCharacterToEncounter adds a combatant to an encounter, along with its own
+// copy of the character's resources.
 func (dao *encounterDAOImpl) AddCharacterToEncounter(encounterID, characterID int) error {
-	_, err := dao.db.Exec("INSERT INTO encounter_characters (encounter_id, character_id) VALUES ($1, $2)", encounterID, characterID)
+	_, err := dao.db.Exec("WITH added AS (INSERT INTO encounter_characters (encounter_id, character_id) VALUES ($1, $2) RETURNING encounter_id, character_id) "+instanceResourcesSQL, encounterID, characterID)
 	return err
 }
 
+// RemoveCharacterFromEncounter takes a combatant out of an encounter. Its
+// conditions, resources and the timed effects that tick on its turn go with
+// it.
 func (dao *encounterDAOImpl) RemoveCharacterFromEncounter(encounterID, characterID int) error {
 	_, err := dao.db.Exec("DELETE FROM encounter_characters WHERE encounter_id = $1 AND character_id = $2", encounterID, characterID)
 	return err
 }
 
 func (dao *encounterDAOImpl) GetByID(id int) (Encounter, error) {
-	var e Encounter
-	err := dao.db.QueryRow("SELECT id, name, COALESCE(owner_id, ''), COALESCE(description, '') FROM encounters WHERE id = $1", id).Scan(&e.ID, &e.Name, &e.OwnerID, &e.Description)
-	return e, err
+	return scanEncounter(dao.db.QueryRow("SELECT "+encounterColumns+" FROM encounters WHERE id = $1", id))
 }
 
 // Get all encounters for a given Discord user
 func (dao *encounterDAOImpl) GetEncountersByOwnerDiscordID(discordID string) ([]Encounter, error) {
-	rows, err := dao.db.Query("SELECT id, name, COALESCE(owner_id, ''), COALESCE(description, '') FROM encounters WHERE owner_id = $1", discordID)
+	rows, err := dao.db.Query("SELECT "+encounterColumns+" FROM encounters WHERE owner_id = $1", discordID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var encounters []Encounter
-	for rows.Next() {
-		var e Encounter
-		err := rows.Scan(&e.ID, &e.Name, &e.OwnerID, &e.Description)
-		if err != nil {
-			return nil, err
-		}
-		encounters = append(encounters, e)
-	}
-	return encounters, nil
+	return scanEncounters(rows)
 }
 
 // GetAccessibleEncounters returns the union of encounters owned by discordID and
@@ -143,7 +190,7 @@ func (dao *encounterDAOImpl) GetEncountersByOwnerDiscordID(discordID string) ([]
 // is (redundantly) also listed as a member.
 func (dao *encounterDAOImpl) GetAccessibleEncounters(discordID string) ([]Encounter, error) {
 	rows, err := dao.db.Query(`
-		SELECT DISTINCT e.id, e.name, COALESCE(e.owner_id, ''), COALESCE(e.description, '')
+		SELECT DISTINCT e.id, e.name, COALESCE(e.owner_id, ''), COALESCE(e.description, ''), e.round, e.turn_index, e.combat_started, e.skip_policy, e.ruleset
 		FROM encounters e
 		LEFT JOIN encounter_users eu ON eu.encounter_id = e.id
 		WHERE e.owner_id = $1 OR eu.user_id = $1
@@ -151,17 +198,19 @@ func (dao *encounterDAOImpl) GetAccessibleEncounters(discordID string) ([]Encoun
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanEncounters(rows)
+}
 
-	var encounters []Encounter
-	for rows.Next() {
-		var e Encounter
-		if err := rows.Scan(&e.ID, &e.Name, &e.OwnerID, &e.Description); err != nil {
-			return nil, err
-		}
-		encounters = append(encounters, e)
+func (dao *encounterDAOImpl) UpdateSettings(encounterID int, settings EncounterSettingsUpdate) (bool, error) {
+	result, err := dao.db.Exec(
+		"UPDATE encounters SET skip_policy = COALESCE($1, skip_policy), ruleset = COALESCE($2, ruleset) WHERE id = $3",
+		settings.SkipPolicy, settings.Ruleset, encounterID,
+	)
+	if err != nil {
+		return false, err
 	}
-	return encounters, rows.Err()
+	rows, err := result.RowsAffected()
+	return rows > 0, err
 }
 
 func (dao *encounterDAOImpl) AddMember(encounterID int, userID string) error {
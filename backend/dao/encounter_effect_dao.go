@@ -0,0 +1,227 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// When an encounter effect's duration ticks down.
+const (
+	EffectTickStart = "start" // at the start of the tick combatant's turn
+	EffectTickEnd   = "end"   // at the end of it
+)
+
+// ValidEffectTicks lists the accepted EncounterEffect.TickAt values.
+var ValidEffectTicks = []string{EffectTickStart, EffectTickEnd}
+
+// LedgerEffect is the action type of the ledger entry written when an
+// encounter effect runs out.
+const LedgerEffect = "effect"
+
+// EncounterEffect is a timed effect that belongs to the battlefield rather
+// than a creature, such as Wall of Fire or Cloudkill. OwnerID is the combatant
+// that created it, 0 for none. DurationRounds counts down once a round, at the
+// start or end (TickAt) of TickCharacterID's turn, and the effect expires when
+// it reaches 0; nil lasts until removed. TickCharacterID must stay in the
+// encounter: removing it from the roster removes the effects ticking on its
+// turn.
+type EncounterEffect struct {
+	ID              int
+	EncounterID     int
+	Name            string
+	Description     string
+	OwnerID         int
+	DurationRounds  *int
+	TickCharacterID int
+	TickAt          string
+}
+
+// EffectDetails is the ledger detail of an effect's expiry.
+type EffectDetails struct {
+	EffectID int    `json:"effect_id"`
+	Effect   string `json:"effect"`
+}
+
+// EncounterEffectDAO manages an encounter's battlefield effects. AdvanceTurn
+// ticks them down and PreviousTurn puts them back.
+type EncounterEffectDAO interface {
+	ListByEncounter(encounterID int) ([]EncounterEffect, error)
+	// Create adds an effect to the encounter. A timed effect ticks on its
+	// owner's turn unless it names another combatant; it fails when it has
+	// neither, or when either is not in the encounter.
+	Create(effect EncounterEffect) (EncounterEffect, error)
+	// Delete removes an effect, returning false when no such effect exists in
+	// the encounter.
+	Delete(encounterID, effectID int) (bool, error)
+}
+
+type encounterEffectDAOImpl struct {
+	db *sql.DB
+}
+
+func NewEncounterEffectDAO(db *sql.DB) EncounterEffectDAO {
+	return &encounterEffectDAOImpl{db: db}
+}
+
+// effectColumns is the select list every effect read shares, in the order
+// scanEffect expects.
+const effectColumns = "id, encounter_id, name, description, COALESCE(owner_id, 0), duration_rounds, COALESCE(tick_character_id, 0), tick_at"
+
+func scanEffect(row rowScanner) (EncounterEffect, error) {
+	var e EncounterEffect
+	err := row.Scan(&e.ID, &e.EncounterID, &e.Name, &e.Description, &e.OwnerID, &e.DurationRounds, &e.TickCharacterID, &e.TickAt)
+	return e, err
+}
+
+func (dao *encounterEffectDAOImpl) ListByEncounter(encounterID int) ([]EncounterEffect, error) {
+	rows, err := dao.db.Query(
+		"SELECT "+effectColumns+" FROM encounter_effects WHERE encounter_id = $1 ORDER BY id ASC",
+		encounterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var effects []EncounterEffect
+	for rows.Next() {
+		e, err := scanEffect(rows)
+		if err != nil {
+			return nil, err
+		}
+		effects = append(effects, e)
+	}
+	return effects, rows.Err()
+}
+
+func (dao *encounterEffectDAOImpl) Create(effect EncounterEffect) (EncounterEffect, error) {
+	if effect.DurationRounds == nil {
+		effect.TickCharacterID = 0
+	} else if effect.TickCharacterID == 0 {
+		effect.TickCharacterID = effect.OwnerID
+	}
+	if effect.DurationRounds != nil && effect.TickCharacterID == 0 {
+		return EncounterEffect{}, fmt.Errorf("timed effect needs a combatant to tick on")
+	}
+	if effect.TickAt == "" {
+		effect.TickAt = EffectTickEnd
+	}
+
+	// Both combatants must be in this encounter, not merely exist.
+	var combatants []int
+	for _, id := range []int{effect.OwnerID, effect.TickCharacterID} {
+		if id != 0 && !slices.Contains(combatants, id) {
+			combatants = append(combatants, id)
+		}
+	}
+	if len(combatants) > 0 {
+		var found int
+		if err := dao.db.QueryRow(
+			"SELECT COUNT(*) FROM encounter_characters WHERE encounter_id = $1 AND character_id = ANY($2)",
+			effect.EncounterID, int64Array(combatants),
+		).Scan(&found); err != nil {
+			return EncounterEffect{}, err
+		}
+		if found < len(combatants) {
+			return EncounterEffect{}, fmt.Errorf("combatant not in encounter")
+		}
+	}
+
+	if err := dao.db.QueryRow(
+		"INSERT INTO encounter_effects (encounter_id, name, description, owner_id, duration_rounds, tick_character_id, tick_at) VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0), $7) RETURNING id",
+		effect.EncounterID, effect.Name, effect.Description, effect.OwnerID, effect.DurationRounds, effect.TickCharacterID, effect.TickAt,
+	).Scan(&effect.ID); err != nil {
+		return EncounterEffect{}, err
+	}
+	return effect, nil
+}
+
+func (dao *encounterEffectDAOImpl) Delete(encounterID, effectID int) (bool, error) {
+	result, err := dao.db.Exec("DELETE FROM encounter_effects WHERE encounter_id = $1 AND id = $2", encounterID, effectID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// tickEffects counts down the timed effects that tick as the starting
+// combatants' turns start and the ending combatants' turns end, deleting those
+// that run out and logging their expiry to the ledger. It returns the effects
+// it touched as they were before, so the tick can be rewound, and the expiry
+// entries.
+func tickEffects(tx *sql.Tx, encounterID int, starting, ending []int) ([]EncounterEffect, []EncounterLedgerEntry, error) {
+	if len(starting) == 0 && len(ending) == 0 {
+		return nil, nil, nil
+	}
+	rows, err := tx.Query(
+		"SELECT "+effectColumns+" FROM encounter_effects WHERE encounter_id = $1 AND duration_rounds IS NOT NULL AND ((tick_at = 'start' AND tick_character_id = ANY($2)) OR (tick_at = 'end' AND tick_character_id = ANY($3))) ORDER BY id FOR UPDATE",
+		encounterID, int64Array(starting), int64Array(ending),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ticking []EncounterEffect
+	for rows.Next() {
+		e, err := scanEffect(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		ticking = append(ticking, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var expired []EncounterLedgerEntry
+	for _, e := range ticking {
+		if !e.expiresOnTick() {
+			if _, err := tx.Exec("UPDATE encounter_effects SET duration_rounds = duration_rounds - 1 WHERE id = $1", e.ID); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM encounter_effects WHERE id = $1", e.ID); err != nil {
+			return nil, nil, err
+		}
+		entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+			EncounterID: encounterID,
+			ActorID:     e.OwnerID,
+			ActionType:  LedgerEffect,
+			Description: e.Name + " ends",
+			Details:     EffectDetails{EffectID: e.ID, Effect: e.Name},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		expired = append(expired, entry)
+	}
+	return ticking, expired, nil
+}
+
+// expiresOnTick reports whether the effect's next tick runs it out.
+func (e EncounterEffect) expiresOnTick() bool {
+	return *e.DurationRounds <= 1
+}
+
+// restoreEffect puts an effect back as a turn snapshot recorded it. One the
+// tick only counted down gets its rounds back, unless it has been removed
+// since; one the tick expired is recreated under its id, unless its tick
+// combatant has left the encounter since.
+func restoreEffect(tx *sql.Tx, e EncounterEffect) error {
+	if !e.expiresOnTick() {
+		_, err := tx.Exec("UPDATE encounter_effects SET duration_rounds = $1 WHERE id = $2", e.DurationRounds, e.ID)
+		return err
+	}
+	_, err := tx.Exec(
+		`INSERT INTO encounter_effects (id, encounter_id, name, description, owner_id, duration_rounds, tick_character_id, tick_at)
+		 SELECT $1::int, ec.encounter_id, $3::text, $4::text, (SELECT c.id FROM characters c WHERE c.id = $5::int), $6::int, ec.character_id, $8::text
+		 FROM encounter_characters ec WHERE ec.encounter_id = $2 AND ec.character_id = $7
+		 ON CONFLICT (id) DO NOTHING`,
+		e.ID, e.EncounterID, e.Name, e.Description, e.OwnerID, e.DurationRounds, e.TickCharacterID, e.TickAt,
+	)
+	return err
+}
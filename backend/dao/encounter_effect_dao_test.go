@@ -0,0 +1,148 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	countCombatantsQ = "SELECT COUNT(*) FROM encounter_characters WHERE encounter_id = $1 AND character_id = ANY($2)"
+	insertEffectQ    = "INSERT INTO encounter_effects (encounter_id, name, description, owner_id, duration_rounds, tick_character_id, tick_at) VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0), $7) RETURNING id"
+	decrementEffectQ = "UPDATE encounter_effects SET duration_rounds = duration_rounds - 1 WHERE id = $1"
+	deleteEffectQ    = "DELETE FROM encounter_effects WHERE id = $1"
+	restoreEffectQ   = "INSERT INTO encounter_effects (id, encounter_id, name, description, owner_id, duration_rounds, tick_character_id, tick_at)"
+	rewindEffectQ    = "UPDATE encounter_effects SET duration_rounds = $1 WHERE id = $2"
+)
+
+func rounds(n int) *int { return &n }
+
+func TestCreateEffect_TicksOnTheOwnersTurnByDefault(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterEffectDAO(db)
+
+	mock.ExpectQuery(q(countCombatantsQ)).WithArgs(7, "{5}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(q(insertEffectQ)).WithArgs(7, "Wall of Fire", "", 5, 10, 5, EffectTickEnd).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+
+	effect, err := dao.Create(EncounterEffect{EncounterID: 7, Name: "Wall of Fire", OwnerID: 5, DurationRounds: rounds(10)})
+	if err != nil {
+		t.Fatalf("Create returned error: %v", err)
+	}
+	if effect.ID != 3 || effect.TickCharacterID != 5 || effect.TickAt != EffectTickEnd {
+		t.Errorf("effect = %+v, want id 3 ticking at the end of 5's turn", effect)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestCreateEffect_Rejects(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterEffectDAO(db)
+
+	// A timed effect with no owner and no tick combatant never ticks.
+	if _, err := dao.Create(EncounterEffect{EncounterID: 7, Name: "Fog Cloud", DurationRounds: rounds(10)}); err == nil ||
+		err.Error() != "timed effect needs a combatant to tick on" {
+		t.Errorf("error = %v, want needs a combatant", err)
+	}
+
+	// Owner 5 is in the encounter but tick combatant 9 is not.
+	mock.ExpectQuery(q(countCombatantsQ)).WithArgs(7, "{5,9}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	if _, err := dao.Create(EncounterEffect{EncounterID: 7, Name: "Cloudkill", OwnerID: 5, DurationRounds: rounds(10), TickCharacterID: 9}); err == nil ||
+		err.Error() != "combatant not in encounter" {
+		t.Errorf("error = %v, want not in encounter", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_TicksEffectsAndPreviousTurnRestoresThem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Order [5,2,8]: 5's Wall of Fire (3) runs out as 5's turn ends, and the
+	// Cloudkill (4) ticking at the start of 2's turn drops to 2 rounds.
+	wall := EncounterEffect{ID: 3, EncounterID: 7, Name: "Wall of Fire", OwnerID: 5, DurationRounds: rounds(1), TickCharacterID: 5, TickAt: EffectTickEnd}
+	cloud := EncounterEffect{ID: 4, EncounterID: 7, Name: "Cloudkill", OwnerID: 8, DurationRounds: rounds(3), TickCharacterID: 2, TickAt: EffectTickStart}
+	snapshot := turnSnapshot{
+		ActiveCharacterID: 5,
+		State:             CombatState{Round: 3, TurnIndex: 0, CombatStarted: true},
+		Effects:           []EncounterEffect{wall, cloud},
+		Ledger:            []int{31},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 2)
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 5).WillReturnRows(conditionRows())
+	mock.ExpectQuery(q(tickEffectsQ)).WithArgs(7, "{2}", "{5}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "name", "description", "owner_id", "duration_rounds", "tick_character_id", "tick_at"}).
+			AddRow(3, 7, "Wall of Fire", "", 5, 1, 5, EffectTickEnd).
+			AddRow(4, 7, "Cloudkill", "", 8, 3, 2, EffectTickStart))
+	mock.ExpectExec(q(deleteEffectQ)).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 5, 0, LedgerEffect, 0, "Wall of Fire ends", `{"effect_id":3,"effect":"Wall of Fire"}`).
+		WillReturnRows(ledgerRowWithID(31))
+	mock.ExpectExec(q(decrementEffectQ)).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(pushHistoryQ)).WithArgs(7, snapshotJSON(t, snapshot)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if len(turn.ExpiredEffects) != 1 || !turn.EffectsChanged {
+		t.Errorf("turn = %+v, want one expired effect", turn)
+	}
+
+	// Rewinding recreates the Wall of Fire and drops its expiry from the
+	// ledger. The DM has removed the Cloudkill since, so giving it its round
+	// back finds nothing and it stays gone.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 1, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(4, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q(restoreEffectQ)).WithArgs(3, 7, "Wall of Fire", "", 5, 1, 5, EffectTickEnd).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(rewindEffectQ)).WithArgs(3, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(deleteLedgerQ)).WithArgs(7, "{31}").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	back, err := dao.PreviousTurn(7, nil)
+	if err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if !back.EffectsChanged {
+		t.Error("PreviousTurn did not report the restored effects")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
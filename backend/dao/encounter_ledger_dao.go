@@ -2,6 +2,7 @@ package dao
 
 import (
 	"database/sql"
+	"encoding/json"
 )
 
 type EncounterLedgerEntry struct {
@@ -14,7 +15,10 @@ type EncounterLedgerEntry struct {
 	ActionType  string `json:"action_type"`
 	HPChange    int    `json:"hp_change"`
 	Description string `json:"description"`
-	CreatedAt   string `json:"created_at"`
+	// Details is structured data about the entry, such as the raw and final
+	// damage of a hit; omitted for plain entries.
+	Details   json.RawMessage `json:"details,omitempty"`
+	CreatedAt string          `json:"created_at"`
 }
 
 type EncounterLedgerInsert struct {
@@ -24,8 +28,13 @@ type EncounterLedgerInsert struct {
 	ActionType  string
 	HPChange    int
 	Description string
+	Details     any // marshalled to JSON; nil stores NULL
 }
 
+// LedgerRoll is the action type of a dice roll posted to the ledger; its
+// details hold the dice.Result breakdown.
+const LedgerRoll = "roll"
+
 type EncounterLedgerDAO interface {
 	ListByEncounterID(encounterID int, limit int) ([]EncounterLedgerEntry, error)
 	Create(entry EncounterLedgerInsert) (EncounterLedgerEntry, error)
@@ -44,7 +53,7 @@ func (dao *encounterLedgerDAOImpl) ListByEncounterID(encounterID int, limit int)
 		limit = 50
 	}
 	rows, err := dao.db.Query(
-		`SELECT l.id, l.encounter_id, COALESCE(l.actor_id, 0), COALESCE(a.name, ''), COALESCE(l.target_id, 0), COALESCE(t.name, ''), COALESCE(l.action_type, ''), COALESCE(l.hp_change, 0), COALESCE(l.description, ''), TO_CHAR(l.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') FROM encounter_ledger l LEFT JOIN characters a ON a.id = l.actor_id LEFT JOIN characters t ON t.id = l.target_id WHERE l.encounter_id = $1 ORDER BY l.created_at DESC, l.id DESC LIMIT $2`,
+		`SELECT l.id, l.encounter_id, COALESCE(l.actor_id, 0), COALESCE(a.name, ''), COALESCE(l.target_id, 0), COALESCE(t.name, ''), COALESCE(l.action_type, ''), COALESCE(l.hp_change, 0), COALESCE(l.description, ''), l.details, TO_CHAR(l.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') FROM encounter_ledger l LEFT JOIN characters a ON a.id = l.actor_id LEFT JOIN characters t ON t.id = l.target_id WHERE l.encounter_id = $1 ORDER BY l.created_at DESC, l.id DESC LIMIT $2`,
 		encounterID,
 		limit,
 	)
@@ -56,6 +65,7 @@ func (dao *encounterLedgerDAOImpl) ListByEncounterID(encounterID int, limit int)
 	entries := []EncounterLedgerEntry{}
 	for rows.Next() {
 		var entry EncounterLedgerEntry
+		var details []byte
 		if scanErr := rows.Scan(
 			&entry.ID,
 			&entry.EncounterID,
@@ -66,25 +76,50 @@ func (dao *encounterLedgerDAOImpl) ListByEncounterID(encounterID int, limit int)
 			&entry.ActionType,
 			&entry.HPChange,
 			&entry.Description,
+			&details,
 			&entry.CreatedAt,
 		); scanErr != nil {
 			return nil, scanErr
 		}
+		entry.Details = details
 		entries = append(entries, entry)
 	}
 	return entries, nil
 }
 
 func (dao *encounterLedgerDAOImpl) Create(entry EncounterLedgerInsert) (EncounterLedgerEntry, error) {
+	return insertLedgerEntry(dao.db, entry)
+}
+
+// queryRower is the slice of *sql.DB and *sql.Tx that insertLedgerEntry needs,
+// so combat operations can log inside their own transaction and the entry
+// commits (or rolls back) with the change it describes.
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func insertLedgerEntry(db queryRower, entry EncounterLedgerInsert) (EncounterLedgerEntry, error) {
+	// Details goes over the wire as a JSON string: lib/pq would encode raw
+	// bytes as bytea, which jsonb rejects.
+	var detailsArg any
+	if entry.Details != nil {
+		encoded, err := json.Marshal(entry.Details)
+		if err != nil {
+			return EncounterLedgerEntry{}, err
+		}
+		detailsArg = string(encoded)
+	}
 	created := EncounterLedgerEntry{}
-	row := dao.db.QueryRow(
-		`WITH inserted AS (INSERT INTO encounter_ledger (encounter_id, actor_id, target_id, action_type, hp_change, description) VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6) RETURNING id, encounter_id, actor_id, target_id, action_type, hp_change, description, created_at) SELECT i.id, i.encounter_id, COALESCE(i.actor_id, 0), COALESCE(a.name, ''), COALESCE(i.target_id, 0), COALESCE(t.name, ''), COALESCE(i.action_type, ''), COALESCE(i.hp_change, 0), COALESCE(i.description, ''), TO_CHAR(i.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') FROM inserted i LEFT JOIN characters a ON a.id = i.actor_id LEFT JOIN characters t ON t.id = i.target_id`,
+	var details []byte
+	row := db.QueryRow(
+		`WITH inserted AS (INSERT INTO encounter_ledger (encounter_id, actor_id, target_id, action_type, hp_change, description, details) VALUES ($1, NULLIF($2, 0), NULLIF($3, 0), $4, $5, $6, $7) RETURNING id, encounter_id, actor_id, target_id, action_type, hp_change, description, details, created_at) SELECT i.id, i.encounter_id, COALESCE(i.actor_id, 0), COALESCE(a.name, ''), COALESCE(i.target_id, 0), COALESCE(t.name, ''), COALESCE(i.action_type, ''), COALESCE(i.hp_change, 0), COALESCE(i.description, ''), i.details, TO_CHAR(i.created_at, 'YYYY-MM-DD"T"HH24:MI:SS"Z"') FROM inserted i LEFT JOIN characters a ON a.id = i.actor_id LEFT JOIN characters t ON t.id = i.target_id`,
 		entry.EncounterID,
 		entry.ActorID,
 		entry.TargetID,
 		entry.ActionType,
 		entry.HPChange,
 		entry.Description,
+		detailsArg,
 	)
 	if err := row.Scan(
 		&created.ID,
@@ -96,9 +131,33 @@ func (dao *encounterLedgerDAOImpl) Create(entry EncounterLedgerInsert) (Encounte
 		&created.ActionType,
 		&created.HPChange,
 		&created.Description,
+		&details,
 		&created.CreatedAt,
 	); err != nil {
 		return EncounterLedgerEntry{}, err
 	}
+	created.Details = details
 	return created, nil
 }
+
+// deleteLedgerEntries removes the entries a rewound turn change wrote, so the
+// log never shows what no longer happened.
+func deleteLedgerEntries(tx *sql.Tx, encounterID int, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(
+		"DELETE FROM encounter_ledger WHERE encounter_id = $1 AND id = ANY($2)",
+		encounterID, int64Array(ids),
+	)
+	return err
+}
+
+// ledgerIDs lists the ids of entries.
+func ledgerIDs(entries []EncounterLedgerEntry) []int {
+	var ids []int
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
@@ -0,0 +1,147 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// turnSnapshot captures everything a turn change (AdvanceTurn, a delay or a
+// rejoin) is about to change, taken before it changes it, so PreviousTurn can
+// put the encounter back exactly. It is stored as JSON in
+// encounter_turn_history, one row per turn change.
+type turnSnapshot struct {
+	// ActiveCharacterID is the combatant whose turn was ending (0 if no one was
+	// active yet).
+	ActiveCharacterID int `json:"active_character_id"`
+	// ActiveEventID is set instead when an initiative event held the turn.
+	ActiveEventID int         `json:"active_event_id,omitempty"`
+	State         CombatState `json:"state"`
+	// Conditions are the outgoing creature's timed conditions before they were
+	// ticked down, including any the tick then expired and deleted.
+	Conditions []Condition `json:"conditions,omitempty"`
+	// Combatants are the initiative slots a delay or rejoin rewrote.
+	Combatants []combatantSnapshot `json:"combatants,omitempty"`
+	// Surprised are the combatants whose surprise cleared as their turn ended.
+	Surprised []int `json:"surprised,omitempty"`
+	// Resuming are the combatants a rejoin before their turn interrupted,
+	// marked to resume it; Resumed are those whose marked turn resumed.
+	Resuming []int `json:"resuming,omitempty"`
+	Resumed  []int `json:"resumed,omitempty"`
+	// Legendary are the legendary actions the incoming creature had before
+	// the start of its turn refilled them.
+	Legendary []legendarySnapshot `json:"legendary,omitempty"`
+	// Resources are the incoming creature's resources its turn recharged, with
+	// their uses before.
+	Resources []resourceSnapshot `json:"resources,omitempty"`
+	// Effects are the encounter effects the turn change ticked, as they were
+	// before, including any that then expired and were deleted.
+	Effects []EncounterEffect `json:"effects,omitempty"`
+	// Ledger are the ids of the log entries the turn change wrote, deleted
+	// again on rewind.
+	Ledger []int `json:"ledger,omitempty"`
+}
+
+// combatantSnapshot is the part of an encounter_characters row that delaying
+// and rejoining change.
+type combatantSnapshot struct {
+	CharacterID int     `json:"character_id"`
+	Initiative  *int    `json:"initiative"`
+	TurnRank    int     `json:"turn_rank"`
+	Held        *string `json:"held"`
+}
+
+func pushTurnHistory(tx *sql.Tx, encounterID int, snapshot turnSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO encounter_turn_history (encounter_id, snapshot) VALUES ($1, $2)",
+		encounterID, string(data),
+	)
+	return err
+}
+
+// popTurnHistory returns the most recent snapshot for the encounter and its row
+// id, locking the row so two concurrent rewinds cannot both consume it. It
+// returns sql.ErrNoRows when there is nothing to rewind.
+func popTurnHistory(tx *sql.Tx, encounterID int) (int, turnSnapshot, error) {
+	var id int
+	var data []byte
+	err := tx.QueryRow(
+		"SELECT id, snapshot FROM encounter_turn_history WHERE encounter_id = $1 ORDER BY id DESC LIMIT 1 FOR UPDATE",
+		encounterID,
+	).Scan(&id, &data)
+	if err != nil {
+		return 0, turnSnapshot{}, err
+	}
+	var snapshot turnSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, turnSnapshot{}, err
+	}
+	return id, snapshot, nil
+}
+
+// clearTurnHistory forgets every recorded advance, used when combat starts over.
+func clearTurnHistory(tx *sql.Tx, encounterID int) error {
+	_, err := tx.Exec("DELETE FROM encounter_turn_history WHERE encounter_id = $1", encounterID)
+	return err
+}
+
+// loadTimedConditions returns a creature's conditions that count down, i.e.
+// the rows an AdvanceTurn tick can change or delete.
+func loadTimedConditions(tx *sql.Tx, encounterID, characterID int) ([]Condition, error) {
+	rows, err := tx.Query(
+		"SELECT id, encounter_id, character_id, condition, duration_rounds, level, COALESCE(note, '') FROM encounter_character_conditions WHERE encounter_id = $1 AND character_id = $2 AND duration_rounds IS NOT NULL",
+		encounterID, characterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conditions []Condition
+	for rows.Next() {
+		var c Condition
+		if err := rows.Scan(&c.ID, &c.EncounterID, &c.CharacterID, &c.Condition, &c.DurationRounds, &c.Level, &c.Note); err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, rows.Err()
+}
+
+// loadCombatant reads a combatant's slot for a snapshot, locking the row. It
+// returns sql.ErrNoRows when the character is not in the encounter.
+func loadCombatant(tx *sql.Tx, encounterID, characterID int) (combatantSnapshot, error) {
+	c := combatantSnapshot{CharacterID: characterID}
+	err := tx.QueryRow(
+		"SELECT initiative, turn_rank, held FROM encounter_characters WHERE encounter_id = $1 AND character_id = $2 FOR UPDATE",
+		encounterID, characterID,
+	).Scan(&c.Initiative, &c.TurnRank, &c.Held)
+	return c, err
+}
+
+func restoreCombatant(tx *sql.Tx, encounterID int, c combatantSnapshot) error {
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET initiative = $1, turn_rank = $2, held = $3 WHERE encounter_id = $4 AND character_id = $5",
+		c.Initiative, c.TurnRank, c.Held, encounterID, c.CharacterID,
+	)
+	return err
+}
+
+// restoreCondition writes a snapshotted condition back. A row that was ticked
+// down gets its duration back; one that expired is re-inserted under its
+// original id so clients holding that id keep working. Nothing is written when
+// the combatant has left the encounter since.
+func restoreCondition(tx *sql.Tx, cond Condition) error {
+	_, err := tx.Exec(
+		`INSERT INTO encounter_character_conditions (id, encounter_id, character_id, condition, duration_rounds, level, note)
+		 SELECT $1::int, ec.encounter_id, ec.character_id, $4::text, $5::int, $6::int, NULLIF($7::text, '')
+		 FROM encounter_characters ec WHERE ec.encounter_id = $2 AND ec.character_id = $3
+		 ON CONFLICT (encounter_id, character_id, condition)
+		 DO UPDATE SET duration_rounds = EXCLUDED.duration_rounds, level = EXCLUDED.level, note = EXCLUDED.note`,
+		cond.ID, cond.EncounterID, cond.CharacterID, cond.Condition, cond.DurationRounds, cond.Level, cond.Note,
+	)
+	return err
+}
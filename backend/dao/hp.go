@@ -0,0 +1,279 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// How an ApplyHP call changes a combatant's hit points. The kind doubles as
+// the ledger action type.
+const (
+	HPDamage  = "damage"
+	HPHealing = "healing"
+	HPTemp    = "temp_hp" // grant temporary hit points
+)
+
+// ValidHPKinds lists the accepted HPChange kinds.
+var ValidHPKinds = []string{HPDamage, HPHealing, HPTemp}
+
+// HPChange is one damage, healing or temporary HP application. ActorID is who
+// caused it and may be 0; Note, when set, is appended to the generated ledger
+// description. DamageType, one of ValidDamageTypes, lets the target's damage
+// defenses adjust Amount; untyped damage is applied as is. ConcentrationD20
+// rolls the Constitution save damage forces on a concentrating target; nil
+// leaves that roll to the DM (see checkConcentration).
+type HPChange struct {
+	EncounterID      int
+	CharacterID      int
+	ActorID          int
+	Kind             string
+	Amount           int
+	DamageType       string
+	Note             string
+	ConcentrationD20 func() int
+}
+
+// DamageDetails is the ledger detail of a damage entry: the damage as rolled,
+// what was left after the target's defenses, and how much of that temporary
+// hit points soaked up.
+type DamageDetails struct {
+	DamageType   string   `json:"damage_type,omitempty"`
+	RawDamage    int      `json:"raw_damage"`
+	FinalDamage  int      `json:"final_damage"`
+	Defenses     []string `json:"defenses,omitempty"`
+	TempAbsorbed int      `json:"temp_absorbed,omitempty"`
+	// DeathSaveFailures and InstantDeath record what damage did to a player
+	// character at, or knocked to, 0 HP.
+	DeathSaveFailures int  `json:"death_save_failures,omitempty"`
+	InstantDeath      bool `json:"instant_death,omitempty"`
+}
+
+// defenseNouns renders applied defenses for ledger descriptions.
+var defenseNouns = map[string]string{
+	DefenseImmune:     "immunity",
+	DefenseResistant:  "resistance",
+	DefenseVulnerable: "vulnerability",
+}
+
+// HPResult is a combatant's hit points and temporary hit points either side of
+// an ApplyHP call, with the ledger entry that recorded it. Concentration is
+// set when the damage made a concentrating combatant check its concentration.
+type HPResult struct {
+	CharacterID   int                  `json:"character_id"`
+	MaxHP         int                  `json:"max_hp"`
+	Before        int                  `json:"before"`
+	After         int                  `json:"after"`
+	TempBefore    int                  `json:"temp_before"`
+	TempAfter     int                  `json:"temp_after"`
+	Entry         EncounterLedgerEntry `json:"entry"`
+	Concentration *ConcentrationCheck  `json:"concentration,omitempty"`
+	// DeathSaves is a player character's death save tally while at 0 HP.
+	DeathSaves *DeathSaves `json:"death_saves,omitempty"`
+}
+
+// ApplyHP applies damage, healing or a temporary HP grant to a combatant and
+// writes the matching ledger entry in the same transaction, so the HP and the
+// log can never disagree. Following the 5e rules, damage drains temporary HP
+// before current HP, healing never restores temporary HP, and temporary HP
+// does not stack: a grant only replaces the old value when it is higher. HP
+// stays within 0..max_hp. Typed damage is first adjusted by the target's
+// resistances, vulnerabilities and immunities, and the ledger entry's details
+// keep both the raw and the final amount. Damage to a concentrating combatant
+// also checks its concentration. A dead player character cannot be healed.
+// The combatant's row is locked while the change is computed, so two DMs
+// hitting the same goblin both land.
+func (dao *encounterCharacterDAOImpl) ApplyHP(change HPChange) (HPResult, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return HPResult{}, err
+	}
+	defer tx.Rollback()
+
+	target, err := lockHPTarget(tx, change.EncounterID, change.CharacterID)
+	if err != nil {
+		return HPResult{}, err
+	}
+	result := &target.result
+
+	var description string
+	var details any
+	var damageTaken int
+	switch change.Kind {
+	case HPDamage:
+		damage := target.takeDamage(change.Amount, change.DamageType, false)
+		description = describeDamage(target.name, damage, *result)
+		details = damage
+		damageTaken = damage.FinalDamage
+	case HPHealing:
+		if target.deathSaves.Dead {
+			return HPResult{}, fmt.Errorf("character is dead")
+		}
+		target.heal(change.Amount)
+		description = fmt.Sprintf("%s regains %d HP (%d → %d)", target.name, change.Amount, result.Before, result.After)
+	case HPTemp:
+		result.TempAfter = max(result.TempBefore, change.Amount)
+		description = fmt.Sprintf("%s gains %d temp HP (%d → %d)", target.name, change.Amount, result.TempBefore, result.TempAfter)
+		if change.Amount <= result.TempBefore {
+			description = fmt.Sprintf("%s keeps %d temp HP over the %d offered", target.name, result.TempBefore, change.Amount)
+		}
+	default:
+		return HPResult{}, fmt.Errorf("unknown hp change kind %q", change.Kind)
+	}
+	if note := strings.TrimSpace(change.Note); note != "" {
+		description += ": " + note
+	}
+
+	if err := target.save(tx, change.EncounterID); err != nil {
+		return HPResult{}, err
+	}
+	result.Entry, err = insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: change.EncounterID,
+		ActorID:     change.ActorID,
+		TargetID:    change.CharacterID,
+		ActionType:  change.Kind,
+		HPChange:    result.After - result.Before,
+		Description: description,
+		Details:     details,
+	})
+	if err != nil {
+		return HPResult{}, err
+	}
+	result.Concentration, err = checkConcentration(tx, change.EncounterID, target, damageTaken, change.ConcentrationD20)
+	if err != nil {
+		return HPResult{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return HPResult{}, err
+	}
+	return *result, nil
+}
+
+// hpTarget is a combatant locked for an HP change. result starts with After
+// equal to Before and tracks the change as it is applied.
+type hpTarget struct {
+	name          string
+	armorClass    int
+	defenses      DamageDefenses
+	concentration *Concentration
+	isPC          bool
+	deathSaves    DeathSaves
+	result        HPResult
+}
+
+// lockHPTarget loads a combatant's hit points, damage defenses, concentration
+// and death saves, locking its encounter_characters row until tx ends.
+func lockHPTarget(tx *sql.Tx, encounterID, characterID int) (hpTarget, error) {
+	target := hpTarget{result: HPResult{CharacterID: characterID}}
+	result := &target.result
+	var spell sql.NullString
+	var conditionIDs pq.Int64Array
+	err := tx.QueryRow(
+		"SELECT c.name, c.armor_class, c.max_hp, COALESCE(ec.current_hp, c.max_hp), ec.temp_hp, c.damage_resistances, c.damage_vulnerabilities, c.damage_immunities, ec.concentration, ec.concentration_condition_ids, c.type = 'pc', ec.death_save_successes, ec.death_save_failures, ec.stable, ec.dead FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND ec.character_id = $2 FOR UPDATE OF ec",
+		encounterID, characterID,
+	).Scan(&target.name, &target.armorClass, &result.MaxHP, &result.Before, &result.TempBefore, pq.Array(&target.defenses.Resistances), pq.Array(&target.defenses.Vulnerabilities), pq.Array(&target.defenses.Immunities), &spell, &conditionIDs,
+		&target.isPC, &target.deathSaves.Successes, &target.deathSaves.Failures, &target.deathSaves.Stable, &target.deathSaves.Dead)
+	if errors.Is(err, sql.ErrNoRows) {
+		return hpTarget{}, fmt.Errorf("character not in encounter")
+	}
+	if err != nil {
+		return hpTarget{}, err
+	}
+	target.concentration = scanConcentration(spell, conditionIDs)
+	result.After, result.TempAfter = result.Before, result.TempBefore
+	target.syncDeathSaves()
+	return target, nil
+}
+
+// takeDamage applies raw damage of damageType: the target's defenses adjust
+// it, temporary HP soak up what they can and current HP take the rest. A
+// player character already at 0 HP fails death saves instead (two on a
+// critical hit), and dies outright if the damage left over after reaching 0
+// HP is at least its HP maximum.
+func (t *hpTarget) takeDamage(raw int, damageType string, critical bool) DamageDetails {
+	raw = max(raw, 0)
+	damage := DamageDetails{DamageType: damageType, RawDamage: raw}
+	damage.FinalDamage, damage.Defenses = t.defenses.Adjust(raw, damageType)
+	damage.TempAbsorbed = min(damage.FinalDamage, t.result.TempAfter)
+	t.result.TempAfter -= damage.TempAbsorbed
+	remaining := damage.FinalDamage - damage.TempAbsorbed
+	t.result.After = max(t.result.After-remaining, 0)
+
+	if t.isPC && !t.deathSaves.Dead && remaining > 0 && t.result.After == 0 {
+		switch {
+		case remaining-t.result.Before >= t.result.MaxHP:
+			damage.InstantDeath = true
+			t.deathSaves.Dead = true
+		case t.result.Before == 0:
+			damage.DeathSaveFailures = 1
+			if critical {
+				damage.DeathSaveFailures = 2
+			}
+			t.deathSaves.fail(damage.DeathSaveFailures)
+		}
+	}
+	t.syncDeathSaves()
+	return damage
+}
+
+// heal restores up to amount HP, never past the maximum. Any healing brings a
+// living player character back from 0 HP, resetting its death saves; the dead
+// stay dead.
+func (t *hpTarget) heal(amount int) {
+	t.result.After = min(t.result.After+amount, t.result.MaxHP)
+	if !t.deathSaves.Dead && t.result.Before == 0 && t.result.After > 0 {
+		t.deathSaves = DeathSaves{}
+	}
+	t.syncDeathSaves()
+}
+
+// syncDeathSaves exposes a player character's death saves on its result while
+// they matter: at 0 HP, or once dead.
+func (t *hpTarget) syncDeathSaves() {
+	t.result.DeathSaves = nil
+	if t.isPC && (t.result.After == 0 || t.deathSaves.Dead) {
+		saves := t.deathSaves
+		t.result.DeathSaves = &saves
+	}
+}
+
+// save writes the target's new hit points, temporary hit points and death
+// saves.
+func (t hpTarget) save(tx *sql.Tx, encounterID int) error {
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET current_hp = $1, temp_hp = $2, death_save_successes = $3, death_save_failures = $4, stable = $5, dead = $6 WHERE encounter_id = $7 AND character_id = $8",
+		t.result.After, t.result.TempAfter, t.deathSaves.Successes, t.deathSaves.Failures, t.deathSaves.Stable, t.deathSaves.Dead, encounterID, t.result.CharacterID,
+	)
+	return err
+}
+
+// describeDamage renders a damage entry for the ledger, e.g. "Fire Elemental
+// takes 5 fire damage (10 before resistance), 3 absorbed by temp HP (40 → 38)".
+func describeDamage(name string, damage DamageDetails, result HPResult) string {
+	typed := ""
+	if damage.DamageType != "" {
+		typed = " " + strings.ToLower(damage.DamageType)
+	}
+	description := fmt.Sprintf("%s takes %d%s damage", name, damage.FinalDamage, typed)
+	if len(damage.Defenses) > 0 {
+		nouns := make([]string, len(damage.Defenses))
+		for i, defense := range damage.Defenses {
+			nouns[i] = defenseNouns[defense]
+		}
+		description += fmt.Sprintf(" (%d before %s)", damage.RawDamage, strings.Join(nouns, " and "))
+	}
+	if damage.TempAbsorbed > 0 {
+		description += fmt.Sprintf(", %d absorbed by temp HP", damage.TempAbsorbed)
+	}
+	description += fmt.Sprintf(" (%d → %d)", result.Before, result.After)
+	switch {
+	case damage.InstantDeath:
+		description += " and dies outright"
+	case damage.DeathSaveFailures > 0 && result.DeathSaves != nil:
+		description += ": " + describeDeathSaveFailures(damage.DeathSaveFailures) + ", " + result.DeathSaves.describe()
+	}
+	return description
+}
@@ -0,0 +1,185 @@
+package dao
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	selectHPQ = "SELECT c.name, c.armor_class, c.max_hp, COALESCE(ec.current_hp, c.max_hp), ec.temp_hp, c.damage_resistances, c.damage_vulnerabilities, c.damage_immunities, ec.concentration, ec.concentration_condition_ids, c.type = 'pc', ec.death_save_successes, ec.death_save_failures, ec.stable, ec.dead FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND ec.character_id = $2 FOR UPDATE OF ec"
+	saveHPQ   = "UPDATE encounter_characters SET current_hp = $1, temp_hp = $2, death_save_successes = $3, death_save_failures = $4, stable = $5, dead = $6 WHERE encounter_id = $7 AND character_id = $8"
+)
+
+var hpColumns = []string{"name", "armor_class", "max_hp", "current_hp", "temp_hp", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "concentration", "concentration_condition_ids", "is_pc", "death_save_successes", "death_save_failures", "stable", "dead"}
+
+func hpRow(name string, maxHP, currentHP, tempHP int) *sqlmock.Rows {
+	return hpRowWithDefenses(name, maxHP, currentHP, tempHP, "{}", "{}", "{}")
+}
+
+// hpRowWithDefenses takes the damage defense columns as Postgres array
+// literals, e.g. "{Fire}".
+func hpRowWithDefenses(name string, maxHP, currentHP, tempHP int, resistances, vulnerabilities, immunities string) *sqlmock.Rows {
+	return sqlmock.NewRows(hpColumns).
+		AddRow(name, 12, maxHP, currentHP, tempHP, resistances, vulnerabilities, immunities, nil, "{}", false, 0, 0, false, false)
+}
+
+func TestApplyHP_DamageDrainsTempHPFirst(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// 5 temp HP soak the first 5 of 8 damage; the rest comes off current HP.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(hpRow("Aragorn", 30, 20, 5))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(17, 0, 0, 0, false, false, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 5, HPDamage, -3, "Aragorn takes 8 damage, 5 absorbed by temp HP (20 → 17)",
+			`{"raw_damage":8,"final_damage":8,"temp_absorbed":5}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, Kind: HPDamage, Amount: 8})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.After != 17 || result.TempBefore != 5 || result.TempAfter != 0 {
+		t.Errorf("result = %+v, want 17 HP and no temp HP left", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_ResistanceHalvesTypedDamage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 9).
+		WillReturnRows(hpRowWithDefenses("Fire Elemental", 102, 60, 0, "{Bludgeoning,Fire}", "{Cold}", "{Poison}"))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(55, 0, 0, 0, false, false, 7, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 9, HPDamage, -5, "Fire Elemental takes 5 fire damage (11 before resistance) (60 → 55)",
+			`{"damage_type":"Fire","raw_damage":11,"final_damage":5,"defenses":["resistant"]}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 9, Kind: HPDamage, Amount: 11, DamageType: "Fire"})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.After != 55 {
+		t.Errorf("HP after = %d, want 55", result.After)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_LowerTempHPGrantDoesNotStack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(hpRow("Aragorn", 30, 20, 8))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(20, 8, 0, 0, false, false, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 5, HPTemp, 0, "Aragorn keeps 8 temp HP over the 5 offered", nil).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, Kind: HPTemp, Amount: 5})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.TempAfter != 8 {
+		t.Errorf("temp HP = %d, want the existing 8 kept", result.TempAfter)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_HealingStopsAtMaxHP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(hpRow("Aragorn", 30, 25, 4))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(30, 4, 0, 0, false, false, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 5, HPHealing, 5, "Aragorn regains 10 HP (25 → 30): Cure Wounds", nil).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	result, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, ActorID: 2, Kind: HPHealing, Amount: 10, Note: " Cure Wounds "})
+	if err != nil {
+		t.Fatalf("ApplyHP returned error: %v", err)
+	}
+	if result.Before != 25 || result.After != 30 || result.MaxHP != 30 || result.TempAfter != 4 {
+		t.Errorf("result = %+v, want 25 → 30 of 30 with temp HP untouched", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_HealingADeadPCFails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Three failed death saves: nothing is written, the ledger included.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(sqlmock.NewRows(hpColumns).
+		AddRow("Aragorn", 12, 30, 0, 0, "{}", "{}", "{}", nil, "{}", true, 0, 3, false, true))
+	mock.ExpectRollback()
+
+	_, err = dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 5, Kind: HPHealing, Amount: 10})
+	if err == nil || err.Error() != "character is dead" {
+		t.Errorf("error = %v, want character is dead", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestApplyHP_NotInEncounterRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 99).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	if _, err := dao.ApplyHP(HPChange{EncounterID: 7, CharacterID: 99, Kind: HPDamage, Amount: 3}); err == nil {
+		t.Fatal("expected an error for a character outside the encounter")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
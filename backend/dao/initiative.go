@@ -0,0 +1,177 @@
+package dao
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Which combatants RollInitiative rolls for.
+const (
+	InitiativeScopeAll   = "all"   // every combatant, replacing existing values
+	InitiativeScopeNPCs  = "npcs"  // NPCs only; PCs keep what their players rolled
+	InitiativeScopeUnset = "unset" // anyone whose initiative is still empty (NULL or 0)
+)
+
+// ValidInitiativeScopes lists the accepted RollInitiative scopes.
+var ValidInitiativeScopes = []string{InitiativeScopeAll, InitiativeScopeNPCs, InitiativeScopeUnset}
+
+// RollMode is how a d20 test is rolled: straight, or best/worst of two.
+type RollMode int
+
+const (
+	RollNormal RollMode = iota
+	RollAdvantage
+	RollDisadvantage
+)
+
+// InitiativeRollOptions controls a RollInitiative call. RollD20 is the random
+// source, injected so callers (and tests) decide how dice are rolled.
+type InitiativeRollOptions struct {
+	Scope   string
+	Modes   map[int]RollMode // per character id; absent means RollNormal
+	RollD20 func() int
+}
+
+// InitiativeRoll is one combatant's result. Dice holds both d20s when rolled
+// with advantage or disadvantage; Kept is the one that counted. Members of an
+// initiative group all carry the group's single roll and its Group id.
+type InitiativeRoll struct {
+	CharacterID int    `json:"character_id"`
+	Name        string `json:"name"`
+	Dice        []int  `json:"dice"`
+	Kept        int    `json:"kept"`
+	Modifier    int    `json:"modifier"`
+	Total       int    `json:"total"`
+	Group       int    `json:"group,omitempty"`
+}
+
+// rollD20Test rolls one d20 test in the given mode, returning every die rolled
+// and the one that counts.
+func rollD20Test(mode RollMode, rollD20 func() int) ([]int, int) {
+	first := rollD20()
+	switch mode {
+	case RollAdvantage:
+		second := rollD20()
+		return []int{first, second}, max(first, second)
+	case RollDisadvantage:
+		second := rollD20()
+		return []int{first, second}, min(first, second)
+	}
+	return []int{first}, first
+}
+
+// RollInitiative rolls d20 + Dexterity modifier (the 5e initiative modifier)
+// for every combatant in scope, stores the totals, and writes one
+// "initiative" ledger entry listing each roll, all in one transaction. A fresh
+// roll drops any turn_rank left by a rejoin. An initiative group rolls once,
+// with its lowest-id member's modifier and mode, and is in scope when any
+// member is. If combat is already running, turn_index follows the active
+// combatant to its new slot.
+func (dao *encounterCharacterDAOImpl) RollInitiative(encounterID int, opts InitiativeRollOptions) ([]InitiativeRoll, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT ec.character_id, c.name, c.type, COALESCE(ec.initiative, 0) = 0, "+dexModifierSQL+", "+groupSQL+" FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1 ORDER BY ec.character_id ASC FOR UPDATE OF ec",
+		encounterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []InitiativeRoll
+	inScope := make(map[int]bool)      // by character id
+	groupInScope := make(map[int]bool) // by group id
+	for rows.Next() {
+		var roll InitiativeRoll
+		var charType string
+		var unset bool
+		if err := rows.Scan(&roll.CharacterID, &roll.Name, &charType, &unset, &roll.Modifier, &roll.Group); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		in := !(opts.Scope == InitiativeScopeNPCs && charType != "npc") && !(opts.Scope == InitiativeScopeUnset && !unset)
+		inScope[roll.CharacterID] = in
+		if roll.Group != 0 && in {
+			groupInScope[roll.Group] = true
+		}
+		candidates = append(candidates, roll)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var rolls []InitiativeRoll
+	for _, roll := range candidates {
+		if inScope[roll.CharacterID] || groupInScope[roll.Group] {
+			rolls = append(rolls, roll)
+		}
+	}
+	if len(rolls) == 0 {
+		return nil, fmt.Errorf("no combatants to roll initiative for")
+	}
+
+	summary := make([]string, 0, len(rolls))
+	groupRolls := make(map[int]InitiativeRoll)
+	groupSizes := make(map[int]int)
+	for _, roll := range rolls {
+		groupSizes[roll.Group]++
+	}
+	for i := range rolls {
+		roll := &rolls[i]
+		// Rows come in character id order, so a group's first member rolls for
+		// it and the rest take that result.
+		if first, ok := groupRolls[roll.Group]; ok && roll.Group != 0 {
+			roll.Dice, roll.Kept, roll.Modifier, roll.Total = first.Dice, first.Kept, first.Modifier, first.Total
+		} else {
+			mode := opts.Modes[roll.CharacterID]
+			roll.Dice, roll.Kept = rollD20Test(mode, opts.RollD20)
+			roll.Total = roll.Kept + roll.Modifier
+			entry := describeInitiativeRoll(*roll, mode)
+			if roll.Group != 0 {
+				groupRolls[roll.Group] = *roll
+				entry = fmt.Sprintf("%s (group of %d)", entry, groupSizes[roll.Group])
+			}
+			summary = append(summary, entry)
+		}
+		if _, err := tx.Exec(
+			"UPDATE encounter_characters SET initiative = $1, turn_rank = 0 WHERE encounter_id = $2 AND character_id = $3",
+			roll.Total, encounterID, roll.CharacterID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := resyncTurnIndex(tx, encounterID); err != nil {
+		return nil, err
+	}
+	if _, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActionType:  "initiative",
+		Description: "Rolled initiative: " + strings.Join(summary, "; "),
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(rolls, func(a, b InitiativeRoll) int { return b.Total - a.Total })
+	return rolls, nil
+}
+
+// describeInitiativeRoll renders a roll for the ledger, e.g. "Goblin 16 (d20 14
+// +2)" or "Aragorn 19 (adv 7/17 +2)".
+func describeInitiativeRoll(roll InitiativeRoll, mode RollMode) string {
+	dice := fmt.Sprintf("d20 %d", roll.Kept)
+	switch mode {
+	case RollAdvantage:
+		dice = fmt.Sprintf("adv %d/%d", roll.Dice[0], roll.Dice[1])
+	case RollDisadvantage:
+		dice = fmt.Sprintf("dis %d/%d", roll.Dice[0], roll.Dice[1])
+	}
+	return fmt.Sprintf("%s %d (%s %+d)", roll.Name, roll.Total, dice, roll.Modifier)
+}
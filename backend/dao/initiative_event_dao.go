@@ -0,0 +1,139 @@
+package dao
+
+import (
+	"database/sql"
+)
+
+// Roster entry kinds in the encounter characters payload.
+const (
+	KindCharacter = "character"
+	KindEvent     = "event"
+)
+
+// InitiativeEvent is a turn-order entry that is not a creature, such as a lair
+// action on initiative count 20. It takes turns like a combatant but has no HP,
+// AC or conditions. Kind is always KindEvent so clients can tell it apart from
+// characters in the same roster.
+type InitiativeEvent struct {
+	Kind        string
+	ID          int
+	EncounterID int
+	Name        string
+	Initiative  int
+	Description string
+	IsActive    bool
+}
+
+func (e InitiativeEvent) turnSlot() turnSlot {
+	return turnSlot{EventID: e.ID, Initiative: e.Initiative}
+}
+
+// MergeTurnOrder lists an encounter's characters and initiative events
+// together in turn order, for the roster payload. Both are expected to come
+// from the same encounter.
+func MergeTurnOrder(characters []Character, events []InitiativeEvent) []any {
+	type entry struct {
+		slot  turnSlot
+		value any
+	}
+	entries := make([]entry, 0, len(characters)+len(events))
+	for _, c := range characters {
+		entries = append(entries, entry{c.turnSlot(), c})
+	}
+	for _, e := range events {
+		entries = append(entries, entry{e.turnSlot(), e})
+	}
+	sortByTurnOrder(entries, func(e entry) turnSlot { return e.slot })
+
+	roster := make([]any, len(entries))
+	for i, e := range entries {
+		roster[i] = e.value
+	}
+	return roster
+}
+
+// InitiativeEventDAO manages an encounter's initiative events. Create and
+// Delete keep turn_index pointing at whoever holds the turn when they reshuffle
+// a running fight.
+type InitiativeEventDAO interface {
+	ListByEncounter(encounterID int) ([]InitiativeEvent, error)
+	Create(event InitiativeEvent) (InitiativeEvent, error)
+	// Delete removes an event from the encounter, returning false when no such
+	// event exists there. Deleting the event that holds the turn leaves no one
+	// active; the next AdvanceTurn starts from the top of the order.
+	Delete(encounterID, eventID int) (bool, error)
+}
+
+type initiativeEventDAOImpl struct {
+	db *sql.DB
+}
+
+func NewInitiativeEventDAO(db *sql.DB) InitiativeEventDAO {
+	return &initiativeEventDAOImpl{db: db}
+}
+
+func (dao *initiativeEventDAOImpl) ListByEncounter(encounterID int) ([]InitiativeEvent, error) {
+	rows, err := dao.db.Query(
+		"SELECT id, encounter_id, name, initiative_count, COALESCE(description, ''), is_active FROM encounter_initiative_events WHERE encounter_id = $1 ORDER BY id ASC",
+		encounterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []InitiativeEvent
+	for rows.Next() {
+		e := InitiativeEvent{Kind: KindEvent}
+		if err := rows.Scan(&e.ID, &e.EncounterID, &e.Name, &e.Initiative, &e.Description, &e.IsActive); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (dao *initiativeEventDAOImpl) Create(event InitiativeEvent) (InitiativeEvent, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return InitiativeEvent{}, err
+	}
+	defer tx.Rollback()
+
+	event.Kind = KindEvent
+	if err = tx.QueryRow(
+		"INSERT INTO encounter_initiative_events (encounter_id, name, initiative_count, description) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id",
+		event.EncounterID, event.Name, event.Initiative, event.Description,
+	).Scan(&event.ID); err != nil {
+		return InitiativeEvent{}, err
+	}
+	if err = resyncTurnIndex(tx, event.EncounterID); err != nil {
+		return InitiativeEvent{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return InitiativeEvent{}, err
+	}
+	return event, nil
+}
+
+func (dao *initiativeEventDAOImpl) Delete(encounterID, eventID int) (bool, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM encounter_initiative_events WHERE encounter_id = $1 AND id = $2", encounterID, eventID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err = resyncTurnIndex(tx, encounterID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
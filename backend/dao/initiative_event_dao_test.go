@@ -0,0 +1,150 @@
+package dao
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// lairOrderRows is a Dragon (5) and a Knight (2) around the dragon's lair
+// action (event 3) on initiative count 20, which it shares with the Dragon.
+func lairOrderRows() *sqlmock.Rows {
+	return slotRows().
+		AddRow(0, 20, 0, 0, nil, false, 0, false, false, 3, 0, false).
+		AddRow(5, 20, 0, 0, nil, true, 50, false, false, 0, 0, false).
+		AddRow(2, 12, 0, 0, nil, false, 10, false, false, 0, 0, false)
+}
+
+func TestAdvanceTurn_InitiativeEventTakesTheTurnAfterTies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// The lair action loses the tie on 20, so it follows the Dragon.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(1, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(lairOrderRows())
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateEventOnQ)).WithArgs(7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 5).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{}", "{5}")
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{ActiveCharacterID: 5, State: CombatState{Round: 1, TurnIndex: 0, CombatStarted: true}})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if turn.ActiveEventID != 3 || turn.ActiveCharacterID != 0 || turn.TurnIndex != 1 {
+		t.Errorf("turn = %+v, want event 3 in slot 1", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_FromInitiativeEventEndsNoCreatureTurn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(1, 1, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(lairOrderRows())
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "event_id"}).AddRow(0, 3))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTurnStart(mock, 7, 2)
+	expectEffectTicks(mock, 7, "{2}", "{}")
+	// No condition lookups or ticks: an event has no turn of its own to end.
+	mock.ExpectExec(q(pushHistoryQ)).
+		WithArgs(7, snapshotJSON(t, turnSnapshot{ActiveEventID: 3, State: CombatState{Round: 1, TurnIndex: 1, CombatStarted: true}})).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 2, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, nil)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if turn.ActiveCharacterID != 2 || turn.ActiveEventID != 0 || turn.TurnIndex != 2 {
+		t.Errorf("turn = %+v, want character 2 in slot 2", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPreviousTurn_ReactivatesInitiativeEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	snapshot := turnSnapshot{ActiveEventID: 3, State: CombatState{Round: 1, TurnIndex: 1, CombatStarted: true}}
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(2, 0, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(9, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateEventOnQ)).WithArgs(7, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(1, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.PreviousTurn(7, nil)
+	if err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if turn.ActiveEventID != 3 || turn.ActiveCharacterID != 0 {
+		t.Errorf("turn = %+v, want event 3 active again", turn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestMergeTurnOrder_InterleavesEvents(t *testing.T) {
+	characters := []Character{
+		{ID: 5, Name: "Dragon", Initiative: 20, Kind: KindCharacter},
+		{ID: 2, Name: "Knight", Initiative: 12, Kind: KindCharacter},
+	}
+	events := []InitiativeEvent{
+		{Kind: KindEvent, ID: 4, Name: "Tremor", Initiative: 10},
+		{Kind: KindEvent, ID: 3, Name: "Lair action", Initiative: 20},
+	}
+
+	roster := MergeTurnOrder(characters, events)
+
+	var got []string
+	for _, entry := range roster {
+		switch e := entry.(type) {
+		case Character:
+			got = append(got, e.Name)
+		case InitiativeEvent:
+			got = append(got, e.Name)
+		}
+	}
+	want := []string{"Dragon", "Lair action", "Knight", "Tremor"}
+	if !slices.Equal(got, want) {
+		t.Errorf("roster = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,90 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SetInitiativeGroup turns group initiative on or off for the NPCs in an
+// encounter spawned from one template. Grouped, they share one initiative —
+// the lowest-id member's, or the first one set — and take a single turn, while
+// HP and conditions stay per member. Ungrouping keeps each member's current
+// initiative, so the old group simply stops moving as one. Members holding a
+// delay or ready action must rejoin before they can be grouped.
+func (dao *encounterCharacterDAOImpl) SetInitiativeGroup(encounterID, templateID int, grouped bool) error {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT ec.character_id, ec.initiative, ec.held IS NOT NULL, ec.is_active FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND c.npc_template_id = $2 ORDER BY ec.character_id ASC FOR UPDATE OF ec",
+		encounterID, templateID,
+	)
+	if err != nil {
+		return err
+	}
+	var members, activeID int
+	var shared sql.NullInt64
+	var holding bool
+	for rows.Next() {
+		var characterID int
+		var initiative sql.NullInt64
+		var held, active bool
+		if err := rows.Scan(&characterID, &initiative, &held, &active); err != nil {
+			rows.Close()
+			return err
+		}
+		members++
+		if !shared.Valid {
+			shared = initiative
+		}
+		if active {
+			activeID = characterID
+		}
+		holding = holding || held
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if members == 0 {
+		return fmt.Errorf("no npcs from that template in encounter")
+	}
+	if grouped && holding {
+		return fmt.Errorf("a group member is holding")
+	}
+	if !grouped {
+		shared = sql.NullInt64{}
+	}
+
+	if _, err = tx.Exec(
+		"UPDATE encounter_characters ec SET grouped = $1, initiative = COALESCE($2, ec.initiative) FROM characters c WHERE c.id = ec.character_id AND ec.encounter_id = $3 AND c.npc_template_id = $4",
+		grouped, shared, encounterID, templateID,
+	); err != nil {
+		return err
+	}
+	// If the group holds the turn, the whole group (or, once ungrouped, just
+	// the member that was looked up as active) keeps it.
+	if activeID != 0 {
+		if _, err = activate(tx, encounterID, activeID); err != nil {
+			return err
+		}
+	}
+	if err = resyncTurnIndex(tx, encounterID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// joinInitiativeGroup puts a newly added NPC into its template's initiative
+// group when the encounter already has one, taking the group's initiative.
+func joinInitiativeGroup(db *sql.DB, encounterID, characterID int) error {
+	_, err := db.Exec(
+		"UPDATE encounter_characters ec SET grouped = TRUE, initiative = g.initiative FROM (SELECT mate.initiative FROM encounter_characters mate JOIN characters mc ON mc.id = mate.character_id JOIN characters c ON c.npc_template_id = mc.npc_template_id WHERE c.id = $2 AND mate.encounter_id = $1 AND mate.character_id <> $2 AND mate.grouped ORDER BY mate.character_id ASC LIMIT 1) g WHERE ec.encounter_id = $1 AND ec.character_id = $2",
+		encounterID, characterID,
+	)
+	return err
+}
@@ -0,0 +1,215 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LedgerLegendary is the action type of ledger entries that spend or restore
+// legendary actions and legendary resistances.
+const LedgerLegendary = "legendary"
+
+// The legendary pools a boss monster draws on.
+const (
+	LegendaryAction     = "action"     // refills at the start of the creature's turn
+	LegendaryResistance = "resistance" // lasts the day; refills only when restored
+)
+
+// ValidLegendaryPools lists the accepted pools.
+var ValidLegendaryPools = []string{LegendaryAction, LegendaryResistance}
+
+// MaxLegendaryUses caps the size of either pool on a template.
+const MaxLegendaryUses = 10
+
+// LegendaryCounters is a combatant's legendary actions and legendary
+// resistances left in an encounter, out of each pool's max.
+type LegendaryCounters struct {
+	Actions        int
+	ActionsMax     int
+	Resistances    int
+	ResistancesMax int
+}
+
+// counters returns the current and max uses of pool.
+func (l *LegendaryCounters) counters(pool string) (current *int, maximum int) {
+	if pool == LegendaryResistance {
+		return &l.Resistances, l.ResistancesMax
+	}
+	return &l.Actions, l.ActionsMax
+}
+
+// legendaryCounters returns a combatant's counters for its payload, nil when
+// it has neither pool.
+func legendaryCounters(l LegendaryCounters) *LegendaryCounters {
+	if l.ActionsMax == 0 && l.ResistancesMax == 0 {
+		return nil
+	}
+	return &l
+}
+
+// LegendaryUse is one spend or restore of a legendary pool. Amount 0 restores
+// the pool to its max; a spend always uses at least one. Note, when set, is
+// appended to the ledger description, e.g. the legendary action taken.
+type LegendaryUse struct {
+	EncounterID int
+	CharacterID int
+	Pool        string
+	Amount      int
+	Note        string
+}
+
+// LegendaryDetails is the ledger detail of a legendary entry.
+type LegendaryDetails struct {
+	Pool   string `json:"pool"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+// SpendLegendary uses Amount (at least 1) of a combatant's legendary actions
+// or legendary resistances and logs it to the ledger. It fails when the
+// combatant has no such pool or not enough uses left.
+func (dao *encounterCharacterDAOImpl) SpendLegendary(use LegendaryUse) (LegendaryCounters, EncounterLedgerEntry, error) {
+	return dao.changeLegendary(use, true)
+}
+
+// RestoreLegendary gives back Amount uses of a legendary pool, or refills it
+// when Amount is 0, never past its max, and logs it to the ledger.
+func (dao *encounterCharacterDAOImpl) RestoreLegendary(use LegendaryUse) (LegendaryCounters, EncounterLedgerEntry, error) {
+	return dao.changeLegendary(use, false)
+}
+
+func (dao *encounterCharacterDAOImpl) changeLegendary(use LegendaryUse, spend bool) (LegendaryCounters, EncounterLedgerEntry, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, err
+	}
+	defer tx.Rollback()
+
+	var name string
+	var counters LegendaryCounters
+	err = tx.QueryRow(
+		"SELECT c.name, ec.legendary_actions, ec.legendary_actions_max, ec.legendary_resistances, ec.legendary_resistances_max FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND ec.character_id = $2 FOR UPDATE OF ec",
+		use.EncounterID, use.CharacterID,
+	).Scan(&name, &counters.Actions, &counters.ActionsMax, &counters.Resistances, &counters.ResistancesMax)
+	if errors.Is(err, sql.ErrNoRows) {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, fmt.Errorf("character not in encounter")
+	}
+	if err != nil {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, err
+	}
+
+	noun := "legendary " + use.Pool
+	current, maximum := counters.counters(use.Pool)
+	if maximum == 0 {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, fmt.Errorf("%s has no %ss", name, noun)
+	}
+	details := LegendaryDetails{Pool: use.Pool, Before: *current}
+	var description string
+	if spend {
+		amount := max(use.Amount, 1)
+		if amount > *current {
+			return LegendaryCounters{}, EncounterLedgerEntry{}, fmt.Errorf("not enough %ss: %d left", noun, *current)
+		}
+		*current -= amount
+		description = fmt.Sprintf("%s uses %s (%d → %d)", name, plural(amount, noun, noun+"s"), details.Before, *current)
+	} else {
+		*current = maximum
+		if use.Amount > 0 {
+			*current = min(details.Before+use.Amount, maximum)
+		}
+		description = fmt.Sprintf("%s regains %s (%d → %d)", name, plural(*current-details.Before, noun, noun+"s"), details.Before, *current)
+	}
+	details.After = *current
+	if note := strings.TrimSpace(use.Note); note != "" {
+		description += ": " + note
+	}
+
+	if _, err = tx.Exec(
+		"UPDATE encounter_characters SET legendary_actions = $1, legendary_resistances = $2 WHERE encounter_id = $3 AND character_id = $4",
+		counters.Actions, counters.Resistances, use.EncounterID, use.CharacterID,
+	); err != nil {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, err
+	}
+	entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: use.EncounterID,
+		ActorID:     use.CharacterID,
+		ActionType:  LedgerLegendary,
+		Description: description,
+		Details:     details,
+	})
+	if err != nil {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return LegendaryCounters{}, EncounterLedgerEntry{}, err
+	}
+	return counters, entry, nil
+}
+
+// setLegendaryPools gives a freshly spawned NPC its template's legendary
+// pools, full.
+func setLegendaryPools(db *sql.DB, encounterID, characterID int, t NpcTemplate) error {
+	actions, resistances := t.legendaryPools()
+	if actions == 0 && resistances == 0 {
+		return nil
+	}
+	_, err := db.Exec(
+		"UPDATE encounter_characters SET legendary_actions = $1, legendary_actions_max = $1, legendary_resistances = $2, legendary_resistances_max = $2 WHERE encounter_id = $3 AND character_id = $4",
+		actions, resistances, encounterID, characterID,
+	)
+	return err
+}
+
+// legendaryPools returns the template's legendary actions and resistances, 0
+// for a pool left unset.
+func (t NpcTemplate) legendaryPools() (actions, resistances int) {
+	if t.LegendaryActions != nil {
+		actions = *t.LegendaryActions
+	}
+	if t.LegendaryResistances != nil {
+		resistances = *t.LegendaryResistances
+	}
+	return actions, resistances
+}
+
+// legendarySnapshot is a combatant's legendary actions before the start of
+// its turn refilled them.
+type legendarySnapshot struct {
+	CharacterID int `json:"character_id"`
+	Actions     int `json:"actions"`
+}
+
+// refillLegendaryActions refills the legendary actions of the combatants
+// whose turn is starting, returning the counts it replaced so the refill can
+// be rewound. Combatants already at their max are left out.
+func refillLegendaryActions(tx *sql.Tx, encounterID int, characterIDs []int) ([]legendarySnapshot, error) {
+	// The self-join reads each row as it was before the update.
+	rows, err := tx.Query(
+		"UPDATE encounter_characters ec SET legendary_actions = ec.legendary_actions_max FROM encounter_characters old WHERE old.encounter_id = ec.encounter_id AND old.character_id = ec.character_id AND ec.encounter_id = $1 AND ec.character_id = ANY($2) AND ec.legendary_actions < ec.legendary_actions_max RETURNING ec.character_id, old.legendary_actions",
+		encounterID, int64Array(characterIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refilled []legendarySnapshot
+	for rows.Next() {
+		var l legendarySnapshot
+		if err := rows.Scan(&l.CharacterID, &l.Actions); err != nil {
+			return nil, err
+		}
+		refilled = append(refilled, l)
+	}
+	return refilled, rows.Err()
+}
+
+func restoreLegendaryActions(tx *sql.Tx, encounterID int, l legendarySnapshot) error {
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET legendary_actions = $1 WHERE encounter_id = $2 AND character_id = $3",
+		l.Actions, encounterID, l.CharacterID,
+	)
+	return err
+}
@@ -0,0 +1,173 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	selectLegendaryQ = "SELECT c.name, ec.legendary_actions, ec.legendary_actions_max, ec.legendary_resistances, ec.legendary_resistances_max FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id WHERE ec.encounter_id = $1 AND ec.character_id = $2 FOR UPDATE OF ec"
+	saveLegendaryQ   = "UPDATE encounter_characters SET legendary_actions = $1, legendary_resistances = $2 WHERE encounter_id = $3 AND character_id = $4"
+)
+
+func legendaryRow(actions, actionsMax, resistances, resistancesMax int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "legendary_actions", "legendary_actions_max", "legendary_resistances", "legendary_resistances_max"}).
+		AddRow("Adult Red Dragon", actions, actionsMax, resistances, resistancesMax)
+}
+
+func TestSpendAndRestoreLegendary(t *testing.T) {
+	cases := []struct {
+		name        string
+		spend       bool
+		use         LegendaryUse
+		want        LegendaryCounters
+		description string
+		details     string
+	}{
+		{"spend actions", true, LegendaryUse{Pool: LegendaryAction, Amount: 2, Note: "Wing Attack"},
+			LegendaryCounters{Actions: 1, ActionsMax: 3, Resistances: 2, ResistancesMax: 3},
+			"Adult Red Dragon uses 2 legendary actions (3 → 1): Wing Attack", `{"pool":"action","before":3,"after":1}`},
+		{"spend defaults to one", true, LegendaryUse{Pool: LegendaryResistance},
+			LegendaryCounters{Actions: 3, ActionsMax: 3, Resistances: 1, ResistancesMax: 3},
+			"Adult Red Dragon uses 1 legendary resistance (2 → 1)", `{"pool":"resistance","before":2,"after":1}`},
+		{"restore refills", false, LegendaryUse{Pool: LegendaryResistance},
+			LegendaryCounters{Actions: 3, ActionsMax: 3, Resistances: 3, ResistancesMax: 3},
+			"Adult Red Dragon regains 1 legendary resistance (2 → 3)", `{"pool":"resistance","before":2,"after":3}`},
+		{"restore stops at max", false, LegendaryUse{Pool: LegendaryResistance, Amount: 5},
+			LegendaryCounters{Actions: 3, ActionsMax: 3, Resistances: 3, ResistancesMax: 3},
+			"Adult Red Dragon regains 1 legendary resistance (2 → 3)", `{"pool":"resistance","before":2,"after":3}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer db.Close()
+			dao := NewEncounterCharacterDAO(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(q(selectLegendaryQ)).WithArgs(7, 5).WillReturnRows(legendaryRow(3, 3, 2, 3))
+			mock.ExpectExec(q(saveLegendaryQ)).WithArgs(c.want.Actions, c.want.Resistances, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("INSERT INTO encounter_ledger").
+				WithArgs(7, 5, 0, LedgerLegendary, 0, c.description, c.details).
+				WillReturnRows(ledgerRow())
+			mock.ExpectCommit()
+
+			use := c.use
+			use.EncounterID, use.CharacterID = 7, 5
+			change := dao.RestoreLegendary
+			if c.spend {
+				change = dao.SpendLegendary
+			}
+			counters, _, err := change(use)
+			if err != nil {
+				t.Fatalf("returned error: %v", err)
+			}
+			if counters != c.want {
+				t.Errorf("counters = %+v, want %+v", counters, c.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet sqlmock expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSpendLegendary_Rejects(t *testing.T) {
+	cases := []struct {
+		name string
+		row  *sqlmock.Rows
+		use  LegendaryUse
+		want string
+	}{
+		{"more than are left", legendaryRow(1, 3, 0, 0), LegendaryUse{Pool: LegendaryAction, Amount: 2}, "not enough legendary actions: 1 left"},
+		{"no such pool", legendaryRow(3, 3, 0, 0), LegendaryUse{Pool: LegendaryResistance}, "Adult Red Dragon has no legendary resistances"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer db.Close()
+			dao := NewEncounterCharacterDAO(db)
+
+			// Nothing is written, the ledger included.
+			mock.ExpectBegin()
+			mock.ExpectQuery(q(selectLegendaryQ)).WithArgs(7, 5).WillReturnRows(c.row)
+			mock.ExpectRollback()
+
+			use := c.use
+			use.EncounterID, use.CharacterID = 7, 5
+			_, _, err = dao.SpendLegendary(use)
+			if err == nil || err.Error() != c.want {
+				t.Errorf("error = %v, want %q", err, c.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet sqlmock expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestAdvanceTurn_RefillsLegendaryActionsAndPreviousTurnRestoresThem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Order [5,2,8]: the dragon (2) spent a legendary action on 5's turn and
+	// gets it back as its own turn starts. The snapshot keeps the old count.
+	snapshot := turnSnapshot{
+		ActiveCharacterID: 5,
+		State:             CombatState{Round: 3, TurnIndex: 0, CombatStarted: true},
+		Legendary:         []legendarySnapshot{{CharacterID: 2, Actions: 2}},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(resumeTurnsQ)).WithArgs(7, "{2}").WillReturnRows(sqlmock.NewRows([]string{"character_id"}))
+	mock.ExpectQuery(q(refillLegendaryQ)).WithArgs(7, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "legendary_actions"}).AddRow(2, 2))
+	mock.ExpectQuery(q(rechargeResourcesQ)).WithArgs(7, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id", "name", "name", "current_uses", "recharge", "recharge_on"}))
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 5).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{2}", "{5}")
+	mock.ExpectExec(q(pushHistoryQ)).WithArgs(7, snapshotJSON(t, snapshot)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := dao.AdvanceTurn(7, nil, nil); err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+
+	// Rewinding puts the spent action back the way it was.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 1, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(4, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q("UPDATE encounter_characters SET legendary_actions = $1 WHERE encounter_id = $2 AND character_id = $3")).
+		WithArgs(2, 7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := dao.PreviousTurn(7, nil); err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
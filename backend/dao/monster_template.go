@@ -6,6 +6,8 @@ import (
 	"log"
 	"regexp"
 	"strconv"
+
+	"github.com/lib/pq"
 )
 
 type StatBlock struct {
@@ -31,6 +33,14 @@ type NpcTemplate struct {
 	ArmorClass  int
 	MaxHP       int
 	OwnerID     string
+	DamageDefenses
+	// LegendaryActions and LegendaryResistances are the legendary pools each
+	// NPC spawned from the template starts with; 0 for none. A save that leaves
+	// one nil keeps the stored pool (0 for a new template).
+	LegendaryActions     *int
+	LegendaryResistances *int
+	// Resources are the limited-use resources spawned NPCs get a copy of.
+	Resources Resources
 }
 
 type NpcTemplateDAO interface {
@@ -51,7 +61,7 @@ func NewNpcTemplateDAO(db *sql.DB) NpcTemplateDAO {
 }
 
 func (dao *npcTemplateDAOImpl) GetAll() ([]NpcTemplate, error) {
-	rows, err := dao.db.Query(`SELECT id, name, description, base_stats, armor_class, max_hp, COALESCE(owner_id, '') FROM npc_templates`)
+	rows, err := dao.db.Query(`SELECT id, name, description, base_stats, armor_class, max_hp, COALESCE(owner_id, ''), damage_resistances, damage_vulnerabilities, damage_immunities, legendary_actions, legendary_resistances, resources FROM npc_templates`)
 	if err != nil {
 		return nil, err
 	}
@@ -60,7 +70,7 @@ func (dao *npcTemplateDAOImpl) GetAll() ([]NpcTemplate, error) {
 	for rows.Next() {
 		var t NpcTemplate
 		var statsStr string
-		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &statsStr, &t.ArmorClass, &t.MaxHP, &t.OwnerID); err != nil {
+		if err := rows.Scan(&t.ID, &t.Name, &t.Description, &statsStr, &t.ArmorClass, &t.MaxHP, &t.OwnerID, pq.Array(&t.Resistances), pq.Array(&t.Vulnerabilities), pq.Array(&t.Immunities), &t.LegendaryActions, &t.LegendaryResistances, &t.Resources); err != nil {
 			return nil, err
 		}
 		stats, err := parseStatBlock(statsStr)
@@ -76,7 +86,7 @@ func (dao *npcTemplateDAOImpl) GetAll() ([]NpcTemplate, error) {
 func (dao *npcTemplateDAOImpl) GetByID(id int) (NpcTemplate, error) {
 	var t NpcTemplate
 	var statsStr string
-	err := dao.db.QueryRow(`SELECT id, name, description, base_stats, armor_class, max_hp, COALESCE(owner_id, '') FROM npc_templates WHERE id = $1`, id).Scan(&t.ID, &t.Name, &t.Description, &statsStr, &t.ArmorClass, &t.MaxHP, &t.OwnerID)
+	err := dao.db.QueryRow(`SELECT id, name, description, base_stats, armor_class, max_hp, COALESCE(owner_id, ''), damage_resistances, damage_vulnerabilities, damage_immunities, legendary_actions, legendary_resistances, resources FROM npc_templates WHERE id = $1`, id).Scan(&t.ID, &t.Name, &t.Description, &statsStr, &t.ArmorClass, &t.MaxHP, &t.OwnerID, pq.Array(&t.Resistances), pq.Array(&t.Vulnerabilities), pq.Array(&t.Immunities), &t.LegendaryActions, &t.LegendaryResistances, &t.Resources)
 	if err != nil {
 		return t, err
 	}
@@ -121,9 +131,13 @@ func parseStatBlock(s string) (StatBlock, error) {
 func (dao *npcTemplateDAOImpl) Create(template NpcTemplate) (int, error) {
 	var id int
 	log.Printf("Creating npc template with base stats: %+v", template.BaseStats)
+	defenses := template.DamageDefenses.normalized()
+	actions, resistances := template.legendaryPools()
 	err := dao.db.QueryRow(
-		`INSERT INTO npc_templates (name, description, base_stats, armor_class, max_hp, owner_id) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		`INSERT INTO npc_templates (name, description, base_stats, armor_class, max_hp, owner_id, damage_resistances, damage_vulnerabilities, damage_immunities, legendary_actions, legendary_resistances, resources) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
 		template.Name, template.Description, template.BaseStats.String(), template.ArmorClass, template.MaxHP, template.OwnerID,
+		pq.Array(defenses.Resistances), pq.Array(defenses.Vulnerabilities), pq.Array(defenses.Immunities),
+		actions, resistances, template.Resources,
 	).Scan(&id)
 	return id, err
 }
@@ -131,11 +145,16 @@ func (dao *npcTemplateDAOImpl) Create(template NpcTemplate) (int, error) {
 // UpdateByOwner updates a template only when it belongs to ownerID; owner_id is
 // not in the SET clause so ownership can never be reassigned. Returns false when
 // no row matched (wrong id or not owned by the caller). Shared seed templates
-// have a NULL owner and so are read-only to signed-in users.
+// have a NULL owner and so are read-only to signed-in users. Damage defense
+// lists, legendary pools and resources left nil keep their stored values.
 func (dao *npcTemplateDAOImpl) UpdateByOwner(template NpcTemplate, ownerID string) (bool, error) {
+	defenses := template.DamageDefenses
 	result, err := dao.db.Exec(
-		`UPDATE npc_templates SET name = $1, description = $2, base_stats = $3, armor_class = $4, max_hp = $5 WHERE id = $6 AND COALESCE(owner_id, '') = $7`,
-		template.Name, template.Description, template.BaseStats.String(), template.ArmorClass, template.MaxHP, template.ID, ownerID,
+		`UPDATE npc_templates SET name = $1, description = $2, base_stats = $3, armor_class = $4, max_hp = $5, `+keepDefenseSQL(6)+`, legendary_actions = COALESCE($9, legendary_actions), legendary_resistances = COALESCE($10, legendary_resistances), resources = COALESCE($11, resources) WHERE id = $12 AND COALESCE(owner_id, '') = $13`,
+		template.Name, template.Description, template.BaseStats.String(), template.ArmorClass, template.MaxHP,
+		pq.Array(defenses.Resistances), pq.Array(defenses.Vulnerabilities), pq.Array(defenses.Immunities),
+		template.LegendaryActions, template.LegendaryResistances, template.Resources.orKeep(),
+		template.ID, ownerID,
 	)
 	if err != nil {
 		return false, err
@@ -173,6 +192,9 @@ func (dao *npcTemplateDAOImpl) AddCharacterToEncounterFromTemplate(templateID in
 		OwnerID:       encounter.OwnerID,
 		Type:          "npc",
 		NpcTemplateID: &t.ID,
+		// A copy, so editing this NPC's defenses leaves the template alone.
+		DamageDefenses: t.DamageDefenses,
+		Resources:      t.Resources,
 		// Add other fields as needed
 	}
 	characterDAO := NewCharacterDAO(dao.db)
@@ -186,6 +208,14 @@ func (dao *npcTemplateDAOImpl) AddCharacterToEncounterFromTemplate(templateID in
 	if err != nil {
 		return Character{}, err
 	}
+	// Joining an existing group of this template's NPCs keeps spawns from
+	// scattering the turn order.
+	if err = joinInitiativeGroup(dao.db, encounterID, newID); err != nil {
+		return Character{}, err
+	}
+	if err = setLegendaryPools(dao.db, encounterID, newID, t); err != nil {
+		return Character{}, err
+	}
 
 	return character, nil
 }
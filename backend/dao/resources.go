@@ -0,0 +1,435 @@
+package dao
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+	"go-initiative-tracker/dice"
+)
+
+// LedgerResource is the action type of ledger entries that spend or restore a
+// limited-use resource.
+const LedgerResource = "resource"
+
+// LedgerRecharge is the action type of a recharge roll's ledger entry.
+const LedgerRecharge = "recharge"
+
+// How a resource gets its uses back.
+const (
+	RechargeShortRest = "short_rest"
+	RechargeLongRest  = "long_rest"
+	RechargeTurnStart = "turn_start" // refills at the start of the creature's turn
+	RechargeRoll      = "recharge"   // refills on a d6 roll of RechargeOn or higher at the start of the creature's turn
+)
+
+// ValidRecharges lists the accepted recharge rules.
+var ValidRecharges = []string{RechargeShortRest, RechargeLongRest, RechargeTurnStart, RechargeRoll}
+
+// rechargeThreshold is the lowest d6 that recharges a RechargeRoll resource
+// that names no threshold of its own: "Recharge 5-6".
+const rechargeThreshold = 5
+
+// Limits on the resources a character or template may list.
+const (
+	MaxResources    = 20
+	MaxResourceUses = 99
+)
+
+// Resource is a limited-use pool such as spell slots, ki points or a "3/day"
+// ability. On a library character or NPC template it is a definition, kept
+// full; in an encounter roster ID identifies the combatant's own copy and
+// Current counts its uses left.
+//
+// RechargeOn is the lowest d6 that recharges a RechargeRoll ability, e.g. 6
+// for a dragon's "Recharge 6" breath. Available, set in the roster for those
+// abilities only, says whether the ability can be used right now.
+type Resource struct {
+	ID         int    `json:"ID,omitempty"`
+	Name       string `json:"Name"`
+	Current    int    `json:"Current"`
+	Max        int    `json:"Max"`
+	Recharge   string `json:"Recharge"`
+	RechargeOn int    `json:"RechargeOn,omitempty"`
+	Available  *bool  `json:"Available,omitempty"`
+}
+
+// markAvailability sets Available on a recharge ability in the roster.
+func (r *Resource) markAvailability() {
+	if r.Recharge == RechargeRoll {
+		available := r.Current > 0
+		r.Available = &available
+	}
+}
+
+// threshold returns the lowest d6 that recharges the ability.
+func (r Resource) threshold() int {
+	if r.RechargeOn == 0 {
+		return rechargeThreshold
+	}
+	return r.RechargeOn
+}
+
+// Resources is a list of resource definitions, stored as a JSONB column.
+type Resources []Resource
+
+// Validate checks resource definitions before they are stored. Names must be
+// unique, whatever their case.
+func (r Resources) Validate() error {
+	if len(r) > MaxResources {
+		return fmt.Errorf("at most %d resources", MaxResources)
+	}
+	seen := make(map[string]bool, len(r))
+	for _, res := range r {
+		name := strings.ToLower(strings.TrimSpace(res.Name))
+		switch {
+		case name == "":
+			return fmt.Errorf("resource name is required")
+		case seen[name]:
+			return fmt.Errorf("duplicate resource %s", res.Name)
+		case res.Max < 1 || res.Max > MaxResourceUses:
+			return fmt.Errorf("resource max must be between 1 and %d", MaxResourceUses)
+		case !slices.Contains(ValidRecharges, res.Recharge):
+			return fmt.Errorf("unknown recharge rule %q", res.Recharge)
+		case res.RechargeOn != 0 && res.Recharge != RechargeRoll:
+			return fmt.Errorf("only recharge abilities take a recharge threshold")
+		case res.RechargeOn != 0 && (res.RechargeOn < 2 || res.RechargeOn > 6):
+			return fmt.Errorf("recharge threshold must be between 2 and 6")
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// normalized returns the definitions as stored: full, with no instance state,
+// every recharge ability's threshold spelled out, and never nil so the column
+// gets '[]'.
+func (r Resources) normalized() Resources {
+	defs := make(Resources, len(r))
+	for i, res := range r {
+		defs[i] = Resource{Name: strings.TrimSpace(res.Name), Current: res.Max, Max: res.Max, Recharge: res.Recharge}
+		if res.Recharge == RechargeRoll {
+			defs[i].RechargeOn = res.threshold()
+		}
+	}
+	return defs
+}
+
+// Value stores the definitions as a JSON string; lib/pq would send raw bytes
+// as bytea, which jsonb rejects.
+func (r Resources) Value() (driver.Value, error) {
+	data, err := json.Marshal(r.normalized())
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// orKeep is the parameter an update writes the definitions from: NULL when
+// the save left them out (nil), for a COALESCE to keep the stored ones.
+func (r Resources) orKeep() any {
+	if r == nil {
+		return nil
+	}
+	return r
+}
+
+// Scan reads the definitions from the JSONB column.
+func (r *Resources) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("cannot scan %T into Resources", src)
+}
+
+// ResourceUse is one spend or restore of a combatant's resource in an
+// encounter. Amount 0 restores the resource to its max; a spend always uses
+// at least one. Note, when set, is appended to the ledger description, e.g.
+// the spell cast.
+type ResourceUse struct {
+	EncounterID int
+	ResourceID  int
+	Amount      int
+	Note        string
+}
+
+// ResourceDetails is the ledger detail of a resource entry.
+type ResourceDetails struct {
+	ResourceID int    `json:"resource_id"`
+	Resource   string `json:"resource"`
+	Before     int    `json:"before"`
+	After      int    `json:"after"`
+	Rest       string `json:"rest,omitempty"`
+}
+
+// SpendResource uses Amount (at least 1) of a combatant's resource and logs
+// it to the ledger. It fails when not enough uses are left.
+func (dao *encounterCharacterDAOImpl) SpendResource(use ResourceUse) (Resource, EncounterLedgerEntry, error) {
+	return dao.changeResource(use, true)
+}
+
+// RestoreResource gives back Amount uses of a resource, or refills it when
+// Amount is 0, never past its max, and logs it to the ledger.
+func (dao *encounterCharacterDAOImpl) RestoreResource(use ResourceUse) (Resource, EncounterLedgerEntry, error) {
+	return dao.changeResource(use, false)
+}
+
+func (dao *encounterCharacterDAOImpl) changeResource(use ResourceUse, spend bool) (Resource, EncounterLedgerEntry, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return Resource{}, EncounterLedgerEntry{}, err
+	}
+	defer tx.Rollback()
+
+	res := Resource{ID: use.ResourceID}
+	var owner string
+	var characterID int
+	err = tx.QueryRow(
+		"SELECT c.name, er.character_id, er.name, er.current_uses, er.max_uses, er.recharge, er.recharge_on FROM encounter_resources er JOIN characters c ON c.id = er.character_id WHERE er.encounter_id = $1 AND er.id = $2 FOR UPDATE OF er",
+		use.EncounterID, use.ResourceID,
+	).Scan(&owner, &characterID, &res.Name, &res.Current, &res.Max, &res.Recharge, &res.RechargeOn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Resource{}, EncounterLedgerEntry{}, fmt.Errorf("resource not in encounter")
+	}
+	if err != nil {
+		return Resource{}, EncounterLedgerEntry{}, err
+	}
+
+	details := ResourceDetails{ResourceID: res.ID, Resource: res.Name, Before: res.Current}
+	verb := "regains"
+	if spend {
+		amount := max(use.Amount, 1)
+		if amount > res.Current {
+			return Resource{}, EncounterLedgerEntry{}, fmt.Errorf("not enough %s: %d left", res.Name, res.Current)
+		}
+		res.Current -= amount
+		verb = "uses"
+	} else {
+		res.Current = res.Max
+		if use.Amount > 0 {
+			res.Current = min(details.Before+use.Amount, res.Max)
+		}
+	}
+	details.After = res.Current
+	res.markAvailability()
+	description := describeResource(owner, verb, details)
+	if note := strings.TrimSpace(use.Note); note != "" {
+		description += ": " + note
+	}
+
+	if err = saveResourceUses(tx, res.ID, res.Current); err != nil {
+		return Resource{}, EncounterLedgerEntry{}, err
+	}
+	entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: use.EncounterID,
+		ActorID:     characterID,
+		ActionType:  LedgerResource,
+		Description: description,
+		Details:     details,
+	})
+	if err != nil {
+		return Resource{}, EncounterLedgerEntry{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Resource{}, EncounterLedgerEntry{}, err
+	}
+	return res, entry, nil
+}
+
+// restRecharges lists the recharge rules each kind of rest restores. Whatever
+// comes back on a short rest also comes back on a long one, and abilities that
+// recharge during combat are certainly back after it.
+var restRecharges = map[string][]string{
+	RechargeShortRest: {RechargeShortRest, RechargeTurnStart, RechargeRoll},
+	RechargeLongRest:  {RechargeShortRest, RechargeLongRest, RechargeTurnStart, RechargeRoll},
+}
+
+// Rest refills the resources a short or long rest restores, for one combatant
+// or, when characterID is 0, for everyone in the encounter. Each refilled
+// resource gets a ledger entry; resources already full are left out.
+func (dao *encounterCharacterDAOImpl) Rest(encounterID, characterID int, rest string) ([]EncounterLedgerEntry, error) {
+	recharges, ok := restRecharges[rest]
+	if !ok {
+		return nil, fmt.Errorf("unknown rest %q", rest)
+	}
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The self-join reads each row as it was before the update.
+	rows, err := tx.Query(
+		"UPDATE encounter_resources er SET current_uses = er.max_uses FROM encounter_resources old, characters c WHERE old.id = er.id AND c.id = er.character_id AND er.encounter_id = $1 AND ($2 = 0 OR er.character_id = $2) AND er.recharge = ANY($3) AND er.current_uses < er.max_uses RETURNING er.character_id, c.name, er.id, er.name, old.current_uses, er.max_uses",
+		encounterID, characterID, pq.Array(recharges),
+	)
+	if err != nil {
+		return nil, err
+	}
+	type restored struct {
+		characterID int
+		owner       string
+		details     ResourceDetails
+	}
+	var refilled []restored
+	for rows.Next() {
+		r := restored{details: ResourceDetails{Rest: rest}}
+		if err := rows.Scan(&r.characterID, &r.owner, &r.details.ResourceID, &r.details.Resource, &r.details.Before, &r.details.After); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		refilled = append(refilled, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := []EncounterLedgerEntry{}
+	for _, r := range refilled {
+		entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+			EncounterID: encounterID,
+			ActorID:     r.characterID,
+			ActionType:  LedgerResource,
+			Description: describeResource(r.owner, "regains", r.details) + " on a " + strings.ReplaceAll(rest, "_", " "),
+			Details:     r.details,
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// describeResource renders a resource entry for the ledger, e.g. "Monk uses 2
+// Ki (4 → 2)".
+func describeResource(owner, verb string, d ResourceDetails) string {
+	return fmt.Sprintf("%s %s %d %s (%d → %d)", owner, verb, max(d.Before-d.After, d.After-d.Before), d.Resource, d.Before, d.After)
+}
+
+func saveResourceUses(tx *sql.Tx, resourceID, current int) error {
+	_, err := tx.Exec("UPDATE encounter_resources SET current_uses = $1 WHERE id = $2", current, resourceID)
+	return err
+}
+
+// RechargeDetails is the ledger detail of a recharge roll.
+type RechargeDetails struct {
+	ResourceID int    `json:"resource_id"`
+	Resource   string `json:"resource"`
+	Roll       int    `json:"roll"`
+	RechargeOn int    `json:"recharge_on"`
+	Recharged  bool   `json:"recharged"`
+}
+
+// resourceSnapshot is a resource's uses before the start of its owner's turn
+// recharged it.
+type resourceSnapshot struct {
+	ID      int `json:"id"`
+	Current int `json:"current"`
+}
+
+// rechargeResources evaluates the recharge rules of the combatants whose turn
+// is starting: turn_start resources refill, and each spent recharge ability
+// gets a d6 roll, logged to the ledger, and refills when the roll meets its
+// threshold. roll may be nil to leave those rolls to the DM. It returns the
+// uses it replaced, so the recharge can be rewound, and the ledger entries.
+func rechargeResources(tx *sql.Tx, encounterID int, characterIDs []int, roll dice.Roller) ([]resourceSnapshot, []EncounterLedgerEntry, error) {
+	rows, err := tx.Query(
+		"SELECT er.id, er.character_id, c.name, er.name, er.current_uses, er.recharge, er.recharge_on FROM encounter_resources er JOIN characters c ON c.id = er.character_id WHERE er.encounter_id = $1 AND er.character_id = ANY($2) AND er.recharge IN ('turn_start', 'recharge') AND er.current_uses < er.max_uses ORDER BY er.id FOR UPDATE OF er",
+		encounterID, int64Array(characterIDs),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	type due struct {
+		characterID int
+		owner       string
+		res         Resource
+	}
+	var spent []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.res.ID, &d.characterID, &d.owner, &d.res.Name, &d.res.Current, &d.res.Recharge, &d.res.RechargeOn); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		spent = append(spent, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var recharged []resourceSnapshot
+	var entries []EncounterLedgerEntry
+	for _, d := range spent {
+		if d.res.Recharge == RechargeRoll {
+			if roll == nil {
+				continue
+			}
+			details := RechargeDetails{ResourceID: d.res.ID, Resource: d.res.Name, Roll: roll(6), RechargeOn: d.res.threshold()}
+			details.Recharged = details.Roll >= details.RechargeOn
+			entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+				EncounterID: encounterID,
+				ActorID:     d.characterID,
+				ActionType:  LedgerRecharge,
+				Description: describeRecharge(d.owner, details),
+				Details:     details,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, entry)
+			if !details.Recharged {
+				continue
+			}
+		}
+		if _, err := tx.Exec("UPDATE encounter_resources SET current_uses = max_uses WHERE id = $1", d.res.ID); err != nil {
+			return nil, nil, err
+		}
+		recharged = append(recharged, resourceSnapshot{ID: d.res.ID, Current: d.res.Current})
+	}
+	return recharged, entries, nil
+}
+
+// describeRecharge renders a recharge roll for the ledger, e.g. "Young Red
+// Dragon rolls 5 to recharge Fire Breath (5-6): recharged".
+func describeRecharge(owner string, d RechargeDetails) string {
+	needs := fmt.Sprintf("%d-6", d.RechargeOn)
+	if d.RechargeOn == 6 {
+		needs = "6"
+	}
+	outcome := "still spent"
+	if d.Recharged {
+		outcome = "recharged"
+	}
+	return fmt.Sprintf("%s rolls %d to recharge %s (%s): %s", owner, d.Roll, d.Resource, needs, outcome)
+}
+
+// instanceResourcesSQL gives each combatant in the added CTE, which has just
+// joined an encounter, its own copy of its character's resources, full.
+const instanceResourcesSQL = "INSERT INTO encounter_resources (encounter_id, character_id, name, current_uses, max_uses, recharge, recharge_on) SELECT a.encounter_id, a.character_id, r.\"Name\", r.\"Max\", r.\"Max\", r.\"Recharge\", COALESCE(r.\"RechargeOn\", 0) FROM added a JOIN characters c ON c.id = a.character_id CROSS JOIN jsonb_to_recordset(c.resources) AS r(\"Name\" TEXT, \"Max\" INTEGER, \"Recharge\" TEXT, \"RechargeOn\" INTEGER)"
+
+// int64Array converts ids for an = ANY($n) parameter.
+func int64Array(ids []int) pq.Int64Array {
+	arr := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		arr[i] = int64(id)
+	}
+	return arr
+}
@@ -0,0 +1,280 @@
+package dao
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	selectResourceQ = "SELECT c.name, er.character_id, er.name, er.current_uses, er.max_uses, er.recharge, er.recharge_on FROM encounter_resources er JOIN characters c ON c.id = er.character_id WHERE er.encounter_id = $1 AND er.id = $2 FOR UPDATE OF er"
+	saveResourceQ   = "UPDATE encounter_resources SET current_uses = $1 WHERE id = $2"
+	restQ           = "UPDATE encounter_resources er SET current_uses = er.max_uses FROM encounter_resources old, characters c WHERE old.id = er.id AND c.id = er.character_id AND er.encounter_id = $1 AND ($2 = 0 OR er.character_id = $2) AND er.recharge = ANY($3) AND er.current_uses < er.max_uses RETURNING er.character_id, c.name, er.id, er.name, old.current_uses, er.max_uses"
+	refillResourceQ = "UPDATE encounter_resources SET current_uses = max_uses WHERE id = $1"
+)
+
+func resourceRow(current, maximum int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"name", "character_id", "name", "current_uses", "max_uses", "recharge", "recharge_on"}).
+		AddRow("Monk", 5, "Ki", current, maximum, RechargeShortRest, 0)
+}
+
+func TestResourcesValidate(t *testing.T) {
+	cases := []struct {
+		name      string
+		resources Resources
+		wantErr   bool
+	}{
+		{"none", nil, false},
+		{"valid", Resources{{Name: "Ki", Max: 4, Recharge: RechargeShortRest}, {Name: "Fire Breath", Max: 1, Recharge: RechargeRoll}}, false},
+		{"missing name", Resources{{Name: " ", Max: 1, Recharge: RechargeLongRest}}, true},
+		{"duplicate name", Resources{{Name: "Ki", Max: 4, Recharge: RechargeShortRest}, {Name: "ki", Max: 2, Recharge: RechargeLongRest}}, true},
+		{"zero max", Resources{{Name: "Ki", Max: 0, Recharge: RechargeShortRest}}, true},
+		{"unknown recharge", Resources{{Name: "Ki", Max: 4, Recharge: "dawn"}}, true},
+		{"recharge 6", Resources{{Name: "Fire Breath", Max: 1, Recharge: RechargeRoll, RechargeOn: 6}}, false},
+		{"threshold off the die", Resources{{Name: "Fire Breath", Max: 1, Recharge: RechargeRoll, RechargeOn: 7}}, true},
+		{"threshold without a roll", Resources{{Name: "Ki", Max: 4, Recharge: RechargeShortRest, RechargeOn: 5}}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.resources.Validate(); (err != nil) != c.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestResourcesValueStoresFullDefinitions(t *testing.T) {
+	value, err := Resources{{ID: 3, Name: " Ki ", Current: 1, Max: 4, Recharge: RechargeShortRest}}.Value()
+	if err != nil {
+		t.Fatalf("Value returned error: %v", err)
+	}
+	want := `[{"Name":"Ki","Current":4,"Max":4,"Recharge":"short_rest"}]`
+	if value != want {
+		t.Errorf("Value() = %v, want %s", value, want)
+	}
+	value, _ = Resources{{Name: "Fire Breath", Max: 1, Recharge: RechargeRoll}}.Value()
+	if want := `[{"Name":"Fire Breath","Current":1,"Max":1,"Recharge":"recharge","RechargeOn":5}]`; value != want {
+		t.Errorf("Value() = %v, want %s", value, want)
+	}
+	if value, _ := Resources(nil).Value(); value != "[]" {
+		t.Errorf("nil Value() = %v, want []", value)
+	}
+}
+
+func TestMarkAvailability(t *testing.T) {
+	breath := Resource{Name: "Fire Breath", Current: 0, Max: 1, Recharge: RechargeRoll}
+	breath.markAvailability()
+	if breath.Available == nil || *breath.Available {
+		t.Errorf("spent breath Available = %v, want false", breath.Available)
+	}
+	breath.Current = 1
+	breath.markAvailability()
+	if breath.Available == nil || !*breath.Available {
+		t.Errorf("recharged breath Available = %v, want true", breath.Available)
+	}
+	ki := Resource{Name: "Ki", Current: 0, Max: 4, Recharge: RechargeShortRest}
+	ki.markAvailability()
+	if ki.Available != nil {
+		t.Errorf("Ki Available = %v, want unset", *ki.Available)
+	}
+}
+
+func TestSpendAndRestoreResource(t *testing.T) {
+	cases := []struct {
+		name        string
+		spend       bool
+		use         ResourceUse
+		want        int
+		description string
+		details     string
+	}{
+		{"spend", true, ResourceUse{Amount: 2, Note: "Flurry of Blows"}, 1,
+			"Monk uses 2 Ki (3 → 1): Flurry of Blows", `{"resource_id":9,"resource":"Ki","before":3,"after":1}`},
+		{"spend defaults to one", true, ResourceUse{}, 2,
+			"Monk uses 1 Ki (3 → 2)", `{"resource_id":9,"resource":"Ki","before":3,"after":2}`},
+		{"restore refills", false, ResourceUse{}, 4,
+			"Monk regains 1 Ki (3 → 4)", `{"resource_id":9,"resource":"Ki","before":3,"after":4}`},
+		{"restore stops at max", false, ResourceUse{Amount: 5}, 4,
+			"Monk regains 1 Ki (3 → 4)", `{"resource_id":9,"resource":"Ki","before":3,"after":4}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to open mock database: %v", err)
+			}
+			defer db.Close()
+			dao := NewEncounterCharacterDAO(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(q(selectResourceQ)).WithArgs(7, 9).WillReturnRows(resourceRow(3, 4))
+			mock.ExpectExec(q(saveResourceQ)).WithArgs(c.want, 9).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("INSERT INTO encounter_ledger").
+				WithArgs(7, 5, 0, LedgerResource, 0, c.description, c.details).
+				WillReturnRows(ledgerRow())
+			mock.ExpectCommit()
+
+			use := c.use
+			use.EncounterID, use.ResourceID = 7, 9
+			change := dao.RestoreResource
+			if c.spend {
+				change = dao.SpendResource
+			}
+			res, _, err := change(use)
+			if err != nil {
+				t.Fatalf("returned error: %v", err)
+			}
+			if res.Current != c.want || res.Max != 4 || res.Name != "Ki" {
+				t.Errorf("resource = %+v, want Ki at %d/4", res, c.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unmet sqlmock expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSpendResource_NotEnoughLeft(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Nothing is written, the ledger included.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectResourceQ)).WithArgs(7, 9).WillReturnRows(resourceRow(1, 4))
+	mock.ExpectRollback()
+
+	_, _, err = dao.SpendResource(ResourceUse{EncounterID: 7, ResourceID: 9, Amount: 2})
+	if err == nil || err.Error() != "not enough Ki: 1 left" {
+		t.Errorf("error = %v, want not enough Ki", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRest_RefillsWhatTheRestRestores(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(restQ)).WithArgs(7, 0, `{"short_rest","turn_start","recharge"}`).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "name", "id", "name", "current_uses", "max_uses"}).
+			AddRow(5, "Monk", 9, "Ki", 1, 4))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 5, 0, LedgerResource, 0, "Monk regains 3 Ki (1 → 4) on a short rest",
+			`{"resource_id":9,"resource":"Ki","before":1,"after":4,"rest":"short_rest"}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	entries, err := dao.Rest(7, 0, RechargeShortRest)
+	if err != nil {
+		t.Fatalf("Rest returned error: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d entries, want 1", len(entries))
+	}
+	if _, err := dao.Rest(7, 0, "nap"); err == nil {
+		t.Error("expected an error for an unknown rest")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestAdvanceTurn_RechargesResourcesAndPreviousTurnRestoresThem(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// Order [5,2,8]: as 2's turn starts its turn_start resource (10) refills,
+	// its breath weapon (11, Recharge 5-6) recharges on a 5 and its frightful
+	// roar (12, Recharge 6) stays spent on a 5. Each roll is logged.
+	rolls := []int{5, 5}
+	roll := func(sides int) int {
+		if sides != 6 {
+			t.Errorf("rolled a d%d, want a d6", sides)
+		}
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}
+	snapshot := turnSnapshot{
+		ActiveCharacterID: 5,
+		State:             CombatState{Round: 3, TurnIndex: 0, CombatStarted: true},
+		Resources:         []resourceSnapshot{{ID: 10, Current: 0}, {ID: 11, Current: 0}},
+		Ledger:            []int{21, 22},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 0, true))
+	mock.ExpectQuery(q(selectOrderedQ)).WithArgs(7).WillReturnRows(orderedRows(5, 2, 8))
+	mock.ExpectQuery(q(selectActiveQ)).WithArgs(7).WillReturnRows(activeRow(5))
+	mock.ExpectQuery(q(skipPolicyQ)).WithArgs(7).WillReturnRows(policyRow(SkipPolicyNone))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q(resumeTurnsQ)).WithArgs(7, "{2}").WillReturnRows(sqlmock.NewRows([]string{"character_id"}))
+	mock.ExpectQuery(q(refillLegendaryQ)).WithArgs(7, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "legendary_actions"}))
+	mock.ExpectQuery(q(rechargeResourcesQ)).WithArgs(7, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id", "name", "name", "current_uses", "recharge", "recharge_on"}).
+			AddRow(10, 2, "Young Red Dragon", "Wing Beat", 0, RechargeTurnStart, 0).
+			AddRow(11, 2, "Young Red Dragon", "Fire Breath", 0, RechargeRoll, 0).
+			AddRow(12, 2, "Young Red Dragon", "Frightful Roar", 0, RechargeRoll, 6))
+	mock.ExpectExec(q(refillResourceQ)).WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 0, LedgerRecharge, 0, "Young Red Dragon rolls 5 to recharge Fire Breath (5-6): recharged",
+			`{"resource_id":11,"resource":"Fire Breath","roll":5,"recharge_on":5,"recharged":true}`).
+		WillReturnRows(ledgerRowWithID(21))
+	mock.ExpectExec(q(refillResourceQ)).WithArgs(11).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 0, LedgerRecharge, 0, "Young Red Dragon rolls 5 to recharge Frightful Roar (6): still spent",
+			`{"resource_id":12,"resource":"Frightful Roar","roll":5,"recharge_on":6,"recharged":false}`).
+		WillReturnRows(ledgerRowWithID(22))
+	mock.ExpectQuery(q(selectTimedQ)).WithArgs(7, 5).WillReturnRows(conditionRows())
+	expectEffectTicks(mock, 7, "{2}", "{5}")
+	mock.ExpectExec(q(pushHistoryQ)).WithArgs(7, snapshotJSON(t, snapshot)).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(q(tickConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(expireConditionsQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 1, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	turn, err := dao.AdvanceTurn(7, nil, roll)
+	if err != nil {
+		t.Fatalf("AdvanceTurn returned error: %v", err)
+	}
+	if len(turn.Recharges) != 2 {
+		t.Errorf("got %d recharge entries, want 2", len(turn.Recharges))
+	}
+
+	// Rewinding spends the recharged uses again and drops both rolls from the
+	// ledger.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectStateQ)).WithArgs(7).WillReturnRows(stateRow(3, 1, true))
+	mock.ExpectQuery(q(popHistoryQ)).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).AddRow(4, []byte(snapshotJSON(t, snapshot))))
+	mock.ExpectExec(q(saveResourceQ)).WithArgs(0, 10).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveResourceQ)).WithArgs(0, 11).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteLedgerQ)).WithArgs(7, "{21,22}").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q(updateAllOffQ)).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateOnQ)).WithArgs(7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(saveStateQ)).WithArgs(3, 0, true, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(deleteHistoryQ)).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if _, err := dao.PreviousTurn(7, nil); err != nil {
+		t.Fatalf("PreviousTurn returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
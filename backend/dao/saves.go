@@ -0,0 +1,233 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go-initiative-tracker/dice"
+
+	"github.com/lib/pq"
+)
+
+// LedgerSave is the action type of a saving throw's ledger entry.
+const LedgerSave = "save"
+
+// Abilities are the six stat_block fields, in stat_block order.
+var Abilities = []string{"strength", "dexterity", "constitution", "intelligence", "wisdom", "charisma"}
+
+// ParseAbility accepts an ability's full name or its three-letter
+// abbreviation, in any case, and returns the full lowercase name.
+func ParseAbility(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, ability := range Abilities {
+		if name == ability || name == ability[:3] {
+			return ability, true
+		}
+	}
+	return "", false
+}
+
+// abilityModifierSQL is a combatant's modifier for ability, one of Abilities,
+// computed and aliased like dexModifierSQL.
+func abilityModifierSQL(ability string) string {
+	return fmt.Sprintf("FLOOR((COALESCE((c.stats).%[1]s, (t.base_stats).%[1]s, 10) - 10) / 2.0)::int", ability)
+}
+
+// SavingThrow is a group saving throw, such as everyone caught in a Fireball
+// rolling Dexterity. Damage, when set, is rolled once for the whole group;
+// a target that fails takes all of it, one that succeeds takes half (rounding
+// down) if HalfOnSuccess and none otherwise. Damage only reaches HP when
+// Apply is set. Modes gives per-target advantage or disadvantage; RollD20 and
+// RollDamage are the random sources, and ConcentrationD20 is as for HPChange.
+type SavingThrow struct {
+	EncounterID      int
+	ActorID          int // who forced the save; may be 0
+	TargetIDs        []int
+	Ability          string // one of Abilities
+	DC               int
+	Label            string // e.g. the spell, prefixed to ledger descriptions
+	Damage           *dice.Expression
+	DamageType       string
+	HalfOnSuccess    bool
+	Apply            bool
+	Modes            map[int]RollMode
+	RollD20          func() int
+	RollDamage       dice.Roller
+	ConcentrationD20 func() int
+}
+
+// SaveRoll is how one target's save went, and doubles as its ledger entry's
+// details. AutoFail names the condition that made it fail without a roll, in
+// which case Dice is empty. Damage is what the target takes from the group's
+// damage roll after the save, before its own defenses; Applied is set once
+// that damage has been applied.
+type SaveRoll struct {
+	Ability  string         `json:"ability"`
+	DC       int            `json:"dc"`
+	Dice     []int          `json:"dice,omitempty"`
+	Natural  int            `json:"natural,omitempty"`
+	Modifier int            `json:"modifier"`
+	Total    int            `json:"total"`
+	AutoFail string         `json:"auto_fail,omitempty"`
+	Success  bool           `json:"success"`
+	Damage   int            `json:"damage,omitempty"`
+	Applied  *DamageDetails `json:"applied,omitempty"`
+}
+
+// SaveResult is one target's saving throw with its hit points either side of
+// it and the ledger entry that recorded it. Concentration is as for HPResult.
+type SaveResult struct {
+	TargetID   int    `json:"target_id"`
+	TargetName string `json:"target_name"`
+	SaveRoll
+	Before        int                  `json:"before"`
+	After         int                  `json:"after"`
+	TempBefore    int                  `json:"temp_before"`
+	TempAfter     int                  `json:"temp_after"`
+	Entry         EncounterLedgerEntry `json:"entry"`
+	Concentration *ConcentrationCheck  `json:"concentration,omitempty"`
+}
+
+// GroupSaveResult is every target's save plus the damage roll they shared,
+// nil when the save dealt no damage.
+type GroupSaveResult struct {
+	DamageRoll *dice.Result `json:"damage_roll,omitempty"`
+	Saves      []SaveResult `json:"saves"`
+}
+
+// RollSaves rolls save.Ability saving throws for every target in one
+// transaction (see rollSave). A target with a condition that fails such saves
+// automatically (Paralyzed, Stunned and so on for Strength and Dexterity)
+// fails without rolling. Every save writes one ledger entry. As in
+// Attack, targets are locked and resolved in id order.
+func (dao *encounterCharacterDAOImpl) RollSaves(save SavingThrow) (GroupSaveResult, error) {
+	if !slices.Contains(Abilities, save.Ability) {
+		return GroupSaveResult{}, fmt.Errorf("unknown ability %q", save.Ability)
+	}
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return GroupSaveResult{}, err
+	}
+	defer tx.Rollback()
+
+	var group GroupSaveResult
+	if save.Damage != nil {
+		rolled := save.Damage.Roll(save.RollDamage)
+		group.DamageRoll = &rolled
+	}
+	targetIDs := slices.Clone(save.TargetIDs)
+	slices.Sort(targetIDs)
+	group.Saves = make([]SaveResult, 0, len(targetIDs))
+	for _, targetID := range targetIDs {
+		target, err := lockHPTarget(tx, save.EncounterID, targetID)
+		if err != nil {
+			return GroupSaveResult{}, err
+		}
+		roll, err := rollSave(tx, save.EncounterID, targetID, save.Ability, save.DC, save.Modes[targetID], save.RollD20)
+		if err != nil {
+			return GroupSaveResult{}, err
+		}
+
+		description := describeSave(target.name, roll)
+		if group.DamageRoll != nil {
+			roll.Damage = group.DamageRoll.Total
+			if roll.Success {
+				roll.Damage = 0
+				if save.HalfOnSuccess {
+					roll.Damage = group.DamageRoll.Total / 2
+				}
+			}
+			if save.Apply && roll.Damage > 0 {
+				applied := target.takeDamage(roll.Damage, save.DamageType, false)
+				roll.Applied = &applied
+				description += ": " + describeDamage(target.name, applied, target.result)
+				if err := target.save(tx, save.EncounterID); err != nil {
+					return GroupSaveResult{}, err
+				}
+			}
+		}
+		if label := strings.TrimSpace(save.Label); label != "" {
+			description = label + ": " + description
+		}
+
+		hp := target.result
+		entry, err := insertLedgerEntry(tx, EncounterLedgerInsert{
+			EncounterID: save.EncounterID,
+			ActorID:     save.ActorID,
+			TargetID:    targetID,
+			ActionType:  LedgerSave,
+			HPChange:    hp.After - hp.Before,
+			Description: description,
+			Details:     roll,
+		})
+		if err != nil {
+			return GroupSaveResult{}, err
+		}
+		damageTaken := 0
+		if roll.Applied != nil {
+			damageTaken = roll.Applied.FinalDamage
+		}
+		concentration, err := checkConcentration(tx, save.EncounterID, target, damageTaken, save.ConcentrationD20)
+		if err != nil {
+			return GroupSaveResult{}, err
+		}
+		group.Saves = append(group.Saves, SaveResult{
+			TargetID:      targetID,
+			TargetName:    target.name,
+			SaveRoll:      roll,
+			Before:        hp.Before,
+			After:         hp.After,
+			TempBefore:    hp.TempBefore,
+			TempAfter:     hp.TempAfter,
+			Entry:         entry,
+			Concentration: concentration,
+		})
+	}
+	if err := tx.Commit(); err != nil {
+		return GroupSaveResult{}, err
+	}
+	return group, nil
+}
+
+// rollSave rolls one combatant's saving throw. Its modifier comes from its
+// stat_block, falling back to its NPC template's base_stats, and a condition
+// that fails the save automatically means no roll is made.
+func rollSave(tx *sql.Tx, encounterID, characterID int, ability string, dc int, mode RollMode, rollD20 func() int) (SaveRoll, error) {
+	roll := SaveRoll{Ability: ability, DC: dc}
+	var conditions []string
+	if err := tx.QueryRow(
+		"SELECT "+abilityModifierSQL(ability)+", ARRAY(SELECT cc.condition FROM encounter_character_conditions cc WHERE cc.encounter_id = ec.encounter_id AND cc.character_id = ec.character_id ORDER BY cc.condition) FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1 AND ec.character_id = $2",
+		encounterID, characterID,
+	).Scan(&roll.Modifier, pq.Array(&conditions)); err != nil {
+		return SaveRoll{}, err
+	}
+	for _, condition := range conditions {
+		// Both rulesets fail the same saves automatically.
+		if effects, ok := effectsOf(Ruleset2014, condition, nil); ok && slices.Contains(effects.AutoFailSaves, ability) {
+			roll.AutoFail = condition
+			return roll, nil
+		}
+	}
+	roll.Dice, roll.Natural = rollD20Test(mode, rollD20)
+	roll.Total = roll.Natural + roll.Modifier
+	roll.Success = roll.Total >= dc
+	return roll, nil
+}
+
+// describeSave renders the outcome half of a save's ledger entry, e.g.
+// "Goblin fails a DC 15 Dexterity save (9)" or "Goblin fails a DC 15
+// Dexterity save (Paralyzed)".
+func describeSave(name string, roll SaveRoll) string {
+	outcome := "fails"
+	if roll.Success {
+		outcome = "succeeds on"
+	}
+	why := fmt.Sprint(roll.Total)
+	if roll.AutoFail != "" {
+		why = roll.AutoFail
+	}
+	ability := strings.ToUpper(roll.Ability[:1]) + roll.Ability[1:]
+	return fmt.Sprintf("%s %s a DC %d %s save (%s)", name, outcome, roll.DC, ability, why)
+}
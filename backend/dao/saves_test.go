@@ -0,0 +1,124 @@
+package dao
+
+import (
+	"testing"
+
+	"go-initiative-tracker/dice"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const selectDexSaveQ = "SELECT FLOOR((COALESCE((c.stats).dexterity, (t.base_stats).dexterity, 10) - 10) / 2.0)::int, ARRAY(SELECT cc.condition FROM encounter_character_conditions cc WHERE cc.encounter_id = ec.encounter_id AND cc.character_id = ec.character_id ORDER BY cc.condition) FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1 AND ec.character_id = $2"
+
+func saveRow(modifier int, conditions string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"modifier", "conditions"}).AddRow(modifier, conditions)
+}
+
+func TestRollSaves_AutoFailAndHalfDamageOnSuccess(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	// The Fireball rolls 11 once. The paralyzed goblin fails without rolling
+	// and takes all of it; Aragorn's 12 + 2 beats DC 13 and halves it to 5.
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 4).WillReturnRows(hpRow("Goblin", 7, 7, 0))
+	mock.ExpectQuery(q(selectDexSaveQ)).WithArgs(7, 4).WillReturnRows(saveRow(2, "{Paralyzed,Prone}"))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(0, 0, 0, 0, false, false, 7, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 4, LedgerSave, -7,
+			"Fireball: Goblin fails a DC 13 Dexterity save (Paralyzed): Goblin takes 11 fire damage (7 → 0)",
+			`{"ability":"dexterity","dc":13,"modifier":2,"total":0,"auto_fail":"Paralyzed","success":false,"damage":11,"applied":{"damage_type":"Fire","raw_damage":11,"final_damage":11}}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 5).WillReturnRows(hpRow("Aragorn", 30, 20, 0))
+	mock.ExpectQuery(q(selectDexSaveQ)).WithArgs(7, 5).WillReturnRows(saveRow(2, "{}"))
+	mock.ExpectExec(q(saveHPQ)).WithArgs(15, 0, 0, 0, false, false, 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 2, 5, LedgerSave, -5,
+			"Fireball: Aragorn succeeds on a DC 13 Dexterity save (14): Aragorn takes 5 fire damage (20 → 15)",
+			`{"ability":"dexterity","dc":13,"dice":[12],"natural":12,"modifier":2,"total":14,"success":true,"damage":5,"applied":{"damage_type":"Fire","raw_damage":5,"final_damage":5}}`).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	damage, err := dice.Parse("2d6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	damageRolls := []int{5, 6}
+	group, err := dao.RollSaves(SavingThrow{
+		EncounterID:   7,
+		ActorID:       2,
+		TargetIDs:     []int{5, 4},
+		Ability:       "dexterity",
+		DC:            13,
+		Label:         "Fireball",
+		Damage:        &damage,
+		DamageType:    "Fire",
+		HalfOnSuccess: true,
+		Apply:         true,
+		RollD20:       fixedD20(t, 12),
+		RollDamage: func(int) int {
+			v := damageRolls[0]
+			damageRolls = damageRolls[1:]
+			return v
+		},
+	})
+	if err != nil {
+		t.Fatalf("RollSaves returned error: %v", err)
+	}
+	if group.DamageRoll == nil || group.DamageRoll.Total != 11 || len(group.Saves) != 2 {
+		t.Fatalf("unexpected result: %+v", group)
+	}
+	if goblin := group.Saves[0]; goblin.TargetID != 4 || goblin.AutoFail != "Paralyzed" || goblin.After != 0 {
+		t.Errorf("unexpected goblin save: %+v", goblin)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestRollSaves_WithoutApplyLeavesHPAlone(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock database: %v", err)
+	}
+	defer db.Close()
+	dao := NewEncounterCharacterDAO(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectHPQ)).WithArgs(7, 4).WillReturnRows(hpRow("Goblin", 7, 7, 0))
+	mock.ExpectQuery(q(selectDexSaveQ)).WithArgs(7, 4).WillReturnRows(saveRow(2, "{}"))
+	mock.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(7, 0, 4, LedgerSave, 0, "Goblin fails a DC 15 Dexterity save (5)", sqlmock.AnyArg()).
+		WillReturnRows(ledgerRow())
+	mock.ExpectCommit()
+
+	damage, _ := dice.Parse("10")
+	group, err := dao.RollSaves(SavingThrow{
+		EncounterID: 7, TargetIDs: []int{4}, Ability: "dexterity", DC: 15, Damage: &damage,
+		RollD20: fixedD20(t, 3),
+	})
+	if err != nil {
+		t.Fatalf("RollSaves returned error: %v", err)
+	}
+	if save := group.Saves[0]; save.Damage != 10 || save.Applied != nil || save.After != 7 {
+		t.Errorf("unexpected save: %+v", save)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestParseAbility(t *testing.T) {
+	for input, want := range map[string]string{"DEX": "dexterity", " Wisdom ": "wisdom", "cha": "charisma"} {
+		if got, ok := ParseAbility(input); !ok || got != want {
+			t.Errorf("ParseAbility(%q) = %q, %v; want %q", input, got, ok, want)
+		}
+	}
+	if _, ok := ParseAbility("luck"); ok {
+		t.Error("ParseAbility accepted an unknown ability")
+	}
+}
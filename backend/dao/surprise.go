@@ -0,0 +1,69 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// markSurprised flags each combatant as surprised for the coming first round
+// and writes a ledger entry for it.
+func markSurprised(tx *sql.Tx, encounterID int, characterIDs []int) error {
+	for _, characterID := range characterIDs {
+		var name string
+		err := tx.QueryRow(
+			"UPDATE encounter_characters ec SET surprised = TRUE FROM characters c WHERE c.id = ec.character_id AND ec.encounter_id = $1 AND ec.character_id = $2 RETURNING c.name",
+			encounterID, characterID,
+		).Scan(&name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("character not in encounter")
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = insertLedgerEntry(tx, EncounterLedgerInsert{
+			EncounterID: encounterID,
+			ActorID:     characterID,
+			ActionType:  "surprised",
+			Description: name + " is surprised and loses their first turn",
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// surprisedAmong returns which of characterIDs are still surprised in order.
+func surprisedAmong(order []turnSlot, characterIDs []int) []int {
+	var surprised []int
+	for _, s := range order {
+		if s.Surprised && slices.Contains(characterIDs, s.CharacterID) {
+			surprised = append(surprised, s.CharacterID)
+		}
+	}
+	return surprised
+}
+
+// clearSurprise ends the surprise of combatants whose skipped first turn has
+// just ended.
+func clearSurprise(tx *sql.Tx, encounterID int, characterIDs []int) error {
+	for _, characterID := range characterIDs {
+		if _, err := tx.Exec(
+			"UPDATE encounter_characters SET surprised = FALSE WHERE encounter_id = $1 AND character_id = $2",
+			encounterID, characterID,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setSurprised puts a cleared surprise back, for PreviousTurn.
+func setSurprised(tx *sql.Tx, encounterID, characterID int) error {
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET surprised = TRUE WHERE encounter_id = $1 AND character_id = $2",
+		encounterID, characterID,
+	)
+	return err
+}
@@ -0,0 +1,38 @@
+package dao
+
+import "database/sql"
+
+// TurnConflictError is returned by a turn-changing method whose caller expected
+// a different combatant to hold the turn, typically because a co-DM changed the
+// turn first. Current is where combat actually stands, so the caller can
+// refresh instead of guessing.
+type TurnConflictError struct {
+	Current TurnResult
+}
+
+func (e *TurnConflictError) Error() string {
+	return "turn conflict: the expected combatant no longer holds the turn"
+}
+
+// checkExpectedTurn compares who holds the turn with expectedActiveID, the
+// active character id the caller last saw (0 for none, which an initiative
+// event's turn also matches). A nil expectation always passes. Call it after
+// loadCombatState has locked the encounter, passing the state it read.
+func checkExpectedTurn(tx *sql.Tx, encounterID int, state CombatState, expectedActiveID *int) error {
+	if expectedActiveID == nil {
+		return nil
+	}
+	activeID, activeEventID, err := loadActiveTurn(tx, encounterID)
+	if err != nil {
+		return err
+	}
+	if activeID == *expectedActiveID {
+		return nil
+	}
+	return &TurnConflictError{Current: TurnResult{
+		ActiveCharacterID: activeID,
+		ActiveEventID:     activeEventID,
+		Round:             state.Round,
+		TurnIndex:         state.TurnIndex,
+	}}
+}
@@ -0,0 +1,271 @@
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go-initiative-tracker/dice"
+)
+
+// Why a combatant is out of the turn rotation (encounter_characters.held).
+const (
+	HoldDelay = "delay" // waiting to act later in the round
+	HoldReady = "ready" // waiting on a trigger with a readied action
+)
+
+// Where a held combatant rejoins the rotation, relative to the current actor.
+const (
+	RejoinBefore = "before" // acts now, then the current actor carries on
+	RejoinAfter  = "after"  // acts once the current actor's turn ends
+)
+
+// Hold takes a combatant out of the turn rotation to delay or ready (hold is
+// HoldDelay or HoldReady). If it is the combatant's turn, the turn passes on
+// without ending, so its conditions tick when it eventually acts. The change
+// is logged and can be rewound with PreviousTurn. Members of an initiative
+// group act together and cannot hold on their own. roll rolls the recharge
+// dice of whoever takes the turn (see advance).
+func (dao *encounterCharacterDAOImpl) Hold(encounterID, characterID int, hold string, expectedActiveID *int, roll dice.Roller) (TurnResult, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer tx.Rollback()
+
+	state, err := loadCombatState(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if err = checkExpectedTurn(tx, encounterID, state, expectedActiveID); err != nil {
+		return TurnResult{}, err
+	}
+
+	before, err := loadCombatant(tx, encounterID, characterID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TurnResult{}, fmt.Errorf("character not in encounter")
+	}
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if before.Held != nil {
+		return TurnResult{}, fmt.Errorf("character is already holding")
+	}
+	activeID, activeEventID, err := loadActiveTurn(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+
+	result, err := tx.Exec(
+		"UPDATE encounter_characters SET held = $1 WHERE encounter_id = $2 AND character_id = $3 AND NOT grouped",
+		hold, encounterID, characterID,
+	)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return TurnResult{}, err
+	} else if n == 0 {
+		return TurnResult{}, fmt.Errorf("character acts with an initiative group")
+	}
+
+	snapshot := turnSnapshot{Combatants: []combatantSnapshot{before}}
+	var turn TurnResult
+	if activeID == characterID {
+		if turn, err = advance(tx, encounterID, false, snapshot, roll); err != nil {
+			return TurnResult{}, err
+		}
+	} else {
+		snapshot.ActiveCharacterID = activeID
+		snapshot.ActiveEventID = activeEventID
+		snapshot.State = state
+		if err = pushTurnHistory(tx, encounterID, snapshot); err != nil {
+			return TurnResult{}, err
+		}
+		turn = TurnResult{ActiveCharacterID: activeID, ActiveEventID: activeEventID, Round: state.Round, TurnIndex: state.TurnIndex}
+	}
+
+	description := "delays their turn"
+	if hold == HoldReady {
+		description = "readies an action"
+	}
+	if _, err = insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActorID:     characterID,
+		ActionType:  hold,
+		Description: description,
+	}); err != nil {
+		return TurnResult{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return TurnResult{}, err
+	}
+	return turn, nil
+}
+
+// Rejoin brings a held combatant back into the rotation immediately before or
+// after the current actor (position is RejoinBefore or RejoinAfter), taking
+// the current actor's initiative. Everyone sharing that initiative count gets a
+// turn_rank pinning their present order with the rejoiner slotted in, so
+// AdvanceTurn carries on from the new position. Rejoining before makes the
+// combatant active at once and starts their turn; the current actor's turn
+// resumes after theirs rather than starting again. roll rolls the rejoiner's
+// recharge dice (see advance).
+func (dao *encounterCharacterDAOImpl) Rejoin(encounterID, characterID int, position string, expectedActiveID *int, roll dice.Roller) (TurnResult, error) {
+	tx, err := dao.db.Begin()
+	if err != nil {
+		return TurnResult{}, err
+	}
+	defer tx.Rollback()
+
+	state, err := loadCombatState(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if err = checkExpectedTurn(tx, encounterID, state, expectedActiveID); err != nil {
+		return TurnResult{}, err
+	}
+	order, err := loadTurnOrder(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	idx := slotIndex(order, characterID)
+	if idx < 0 {
+		return TurnResult{}, fmt.Errorf("character not in encounter")
+	}
+	if !order[idx].Held {
+		return TurnResult{}, fmt.Errorf("character is not holding")
+	}
+	activeID, activeEventID, err := loadActiveTurn(tx, encounterID)
+	if err != nil {
+		return TurnResult{}, err
+	}
+	if activeEventID != 0 {
+		return TurnResult{}, fmt.Errorf("an initiative event holds the turn")
+	}
+	current := activeIndex(order, activeID, 0)
+	if current < 0 {
+		return TurnResult{}, fmt.Errorf("no one holds the turn")
+	}
+	// An initiative group's turn is its first member's.
+	activeID = order[current].CharacterID
+	initiative := order[current].Initiative
+
+	// The rejoiner's new neighbours: everyone else in the rotation at the
+	// current actor's initiative, in their present order, with the rejoiner
+	// beside the actor.
+	var group []int
+	for _, s := range order {
+		if s.Initiative != initiative || s.Held || s.EventID != 0 {
+			continue
+		}
+		if s.CharacterID == activeID && position == RejoinAfter {
+			group = append(group, activeID, characterID)
+		} else if s.CharacterID == activeID {
+			group = append(group, characterID, activeID)
+		} else {
+			group = append(group, s.CharacterID)
+		}
+	}
+
+	snapshot := turnSnapshot{ActiveCharacterID: activeID, State: state}
+	for i, id := range group {
+		before, err := loadCombatant(tx, encounterID, id)
+		if err != nil {
+			return TurnResult{}, err
+		}
+		snapshot.Combatants = append(snapshot.Combatants, before)
+		if _, err = tx.Exec(
+			"UPDATE encounter_characters SET initiative = $1, turn_rank = $2, held = NULL WHERE encounter_id = $3 AND character_id = $4",
+			initiative, len(group)-i, encounterID, id,
+		); err != nil {
+			return TurnResult{}, err
+		}
+	}
+
+	formerActorID := activeID
+	var recharges, expired []EncounterLedgerEntry
+	if position == RejoinBefore {
+		if _, err = activate(tx, encounterID, characterID); err != nil {
+			return TurnResult{}, err
+		}
+		activeID = characterID
+		// The rejoiner's turn starts now. The interrupted turn has already
+		// started, so it is marked to resume: the advance back to it must not
+		// refill, recharge or tick for it a second time.
+		starting := []int{characterID}
+		if snapshot.Legendary, snapshot.Resources, recharges, err = startTurn(tx, encounterID, starting, roll); err != nil {
+			return TurnResult{}, err
+		}
+		snapshot.Ledger = ledgerIDs(recharges)
+		if snapshot.Effects, expired, err = tickEffects(tx, encounterID, starting, nil); err != nil {
+			return TurnResult{}, err
+		}
+		snapshot.Ledger = append(snapshot.Ledger, ledgerIDs(expired)...)
+		for _, s := range turnMembers(order, current) {
+			if err = setResuming(tx, encounterID, s.CharacterID, true); err != nil {
+				return TurnResult{}, err
+			}
+			snapshot.Resuming = append(snapshot.Resuming, s.CharacterID)
+		}
+	}
+	if err = pushTurnHistory(tx, encounterID, snapshot); err != nil {
+		return TurnResult{}, err
+	}
+	if order, err = loadTurnOrder(tx, encounterID); err != nil {
+		return TurnResult{}, err
+	}
+	state.TurnIndex = slotIndex(order, activeID)
+	if err = saveCombatState(tx, encounterID, state); err != nil {
+		return TurnResult{}, err
+	}
+
+	if _, err = insertLedgerEntry(tx, EncounterLedgerInsert{
+		EncounterID: encounterID,
+		ActorID:     characterID,
+		TargetID:    formerActorID,
+		ActionType:  "rejoin",
+		Description: fmt.Sprintf("rejoins the initiative %s the target's turn, at %d", position, initiative),
+	}); err != nil {
+		return TurnResult{}, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return TurnResult{}, err
+	}
+	return TurnResult{ActiveCharacterID: activeID, Round: state.Round, TurnIndex: state.TurnIndex, Recharges: recharges, ExpiredEffects: expired, EffectsChanged: len(snapshot.Effects) > 0}, nil
+}
+
+// setResuming marks (or unmarks) a combatant whose turn a rejoin interrupted,
+// so the next advance to them carries the turn on instead of starting it.
+func setResuming(tx *sql.Tx, encounterID, characterID int, resuming bool) error {
+	_, err := tx.Exec(
+		"UPDATE encounter_characters SET resuming = $1 WHERE encounter_id = $2 AND character_id = $3",
+		resuming, encounterID, characterID,
+	)
+	return err
+}
+
+// resumeTurns clears the resuming mark of those characterIDs that have one
+// and returns them: their turn already started before a rejoin interrupted it.
+func resumeTurns(tx *sql.Tx, encounterID int, characterIDs []int) ([]int, error) {
+	rows, err := tx.Query(
+		"UPDATE encounter_characters SET resuming = FALSE WHERE encounter_id = $1 AND character_id = ANY($2) AND resuming RETURNING character_id",
+		encounterID, int64Array(characterIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var resumed []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		resumed = append(resumed, id)
+	}
+	return resumed, rows.Err()
+}
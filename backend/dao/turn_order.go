@@ -0,0 +1,279 @@
+package dao
+
+import (
+	"cmp"
+	"database/sql"
+	"slices"
+)
+
+// dexModifierSQL is a combatant's Dexterity modifier, read from the stat_block
+// on the character row and falling back to its NPC template's base_stats (then
+// to a plain 10, i.e. +0). Dividing by 2.0 and flooring keeps odd scores below
+// 10 rounding down, so a 9 is -1 rather than integer division's 0. Queries using
+// it alias characters as c and npc_templates as t.
+const dexModifierSQL = "FLOOR((COALESCE((c.stats).dexterity, (t.base_stats).dexterity, 10) - 10) / 2.0)::int"
+
+// groupSQL is a combatant's initiative group: its NPC template id when it is
+// grouped, else 0. Aliases as for dexModifierSQL, with ec for
+// encounter_characters.
+const groupSQL = "COALESCE(CASE WHEN ec.grouped THEN c.npc_template_id END, 0)"
+
+// disabledSQL is true when an encounter_characters row (aliased ec) has a
+// condition that takes it out of the fight for SkipPolicyDisabled.
+const disabledSQL = "EXISTS (SELECT 1 FROM encounter_character_conditions cc WHERE cc.encounter_id = ec.encounter_id AND cc.character_id = ec.character_id AND cc.condition IN ('Unconscious', 'Petrified'))"
+
+// turnSlot is one position in the initiative order, carrying the keys
+// compareTurnOrder needs plus what a skip policy looks at. A slot belongs either
+// to a combatant (CharacterID) or to an initiative event such as a lair action
+// (EventID); the other id is 0.
+type turnSlot struct {
+	CharacterID   int
+	EventID       int
+	Initiative    int
+	TurnRank      int
+	DexModifier   int
+	TiebreakOrder *int
+	IsNPC         bool
+	CurrentHP     int
+	Disabled      bool // Unconscious or Petrified
+	Held          bool // delaying or readying, so out of the rotation
+	Surprised     bool // loses its round-one turn
+	// GroupID is the NPC template id of the initiative group the combatant
+	// acts with, or 0. unit is the id of the group's leader (its lowest
+	// character id), set by groupSlots; it keeps a group together in the order.
+	GroupID int
+	unit    int
+}
+
+// sameGroup reports whether two slots belong to one initiative group.
+func sameGroup(a, b turnSlot) bool {
+	return a.GroupID != 0 && a.GroupID == b.GroupID
+}
+
+// groupSlots gives every member of an initiative group its leader's sort keys,
+// so the group sorts as one block wherever the leader would.
+func groupSlots(slots []turnSlot) {
+	leaders := make(map[int]turnSlot)
+	for _, s := range slots {
+		if s.GroupID == 0 {
+			continue
+		}
+		if leader, ok := leaders[s.GroupID]; !ok || s.CharacterID < leader.CharacterID {
+			leaders[s.GroupID] = s
+		}
+	}
+	for i := range slots {
+		s := &slots[i]
+		s.unit = s.CharacterID
+		if leader, ok := leaders[s.GroupID]; ok && s.GroupID != 0 {
+			s.Initiative, s.TurnRank, s.DexModifier, s.TiebreakOrder = leader.Initiative, leader.TurnRank, leader.DexModifier, leader.TiebreakOrder
+			s.unit = leader.CharacterID
+		}
+	}
+}
+
+// sortByTurnOrder sorts items into initiative order, grouping as groupSlots
+// does, where slot describes each item's place in the order.
+func sortByTurnOrder[T any](items []T, slot func(T) turnSlot) {
+	slots := make([]turnSlot, len(items))
+	positions := make([]int, len(items))
+	for i, item := range items {
+		slots[i] = slot(item)
+		positions[i] = i
+	}
+	groupSlots(slots)
+	slices.SortStableFunc(positions, func(a, b int) int { return compareTurnOrder(slots[a], slots[b]) })
+
+	sorted := make([]T, len(items))
+	for i, pos := range positions {
+		sorted[i] = items[pos]
+	}
+	copy(items, sorted)
+}
+
+// skippedBy reports whether policy passes over this combatant. A surprised
+// combatant is passed over whatever the policy.
+func (s turnSlot) skippedBy(policy string) bool {
+	if s.Surprised {
+		return true
+	}
+	switch policy {
+	case SkipPolicyDefeatedNPCs:
+		return s.IsNPC && s.CurrentHP <= 0
+	case SkipPolicyDisabled:
+		return s.Disabled
+	}
+	return false
+}
+
+// compareTurnOrder is the one definition of initiative order, shared by
+// StartCombat, AdvanceTurn and the encounter roster so they can never disagree.
+// Higher initiative goes first. Within a count, a higher turn_rank goes first;
+// ranks are only set when a delaying combatant rejoins, and pin the order it
+// rejoined into. Remaining ties go to the higher Dexterity modifier (the 5e
+// rule), then to the DM's manual tiebreak order (lower first, and a chosen
+// position before none), and finally to character id so the order is total.
+// Initiative events lose every tie to combatants, as lair actions do. Members
+// of an initiative group share their leader's keys (see groupSlots) and are
+// kept together by comparing leaders before character ids.
+func compareTurnOrder(a, b turnSlot) int {
+	if c := cmp.Compare(b.Initiative, a.Initiative); c != 0 {
+		return c
+	}
+	switch {
+	case a.EventID != 0 && b.EventID != 0:
+		return cmp.Compare(a.EventID, b.EventID)
+	case a.EventID != 0:
+		return 1
+	case b.EventID != 0:
+		return -1
+	}
+	if c := cmp.Compare(b.TurnRank, a.TurnRank); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(b.DexModifier, a.DexModifier); c != 0 {
+		return c
+	}
+	switch {
+	case a.TiebreakOrder != nil && b.TiebreakOrder != nil:
+		if c := cmp.Compare(*a.TiebreakOrder, *b.TiebreakOrder); c != 0 {
+			return c
+		}
+	case a.TiebreakOrder != nil:
+		return -1
+	case b.TiebreakOrder != nil:
+		return 1
+	}
+	if c := cmp.Compare(a.unit, b.unit); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.CharacterID, b.CharacterID)
+}
+
+// loadTurnOrder returns the encounter's combatants and initiative events in
+// initiative order.
+func loadTurnOrder(tx *sql.Tx, encounterID int) ([]turnSlot, error) {
+	rows, err := tx.Query(
+		"SELECT ec.character_id, COALESCE(ec.initiative, 0), ec.turn_rank, "+dexModifierSQL+", ec.tiebreak_order, c.type = 'npc', COALESCE(ec.current_hp, c.max_hp), "+disabledSQL+", ec.held IS NOT NULL, 0, "+groupSQL+", ec.surprised FROM encounter_characters ec JOIN characters c ON c.id = ec.character_id LEFT JOIN npc_templates t ON t.id = c.npc_template_id WHERE ec.encounter_id = $1"+
+			" UNION ALL SELECT 0, initiative_count, 0, 0, NULL, FALSE, 0, FALSE, FALSE, id, 0, FALSE FROM encounter_initiative_events WHERE encounter_id = $1",
+		encounterID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var order []turnSlot
+	for rows.Next() {
+		var s turnSlot
+		if err := rows.Scan(&s.CharacterID, &s.Initiative, &s.TurnRank, &s.DexModifier, &s.TiebreakOrder, &s.IsNPC, &s.CurrentHP, &s.Disabled, &s.Held, &s.EventID, &s.GroupID, &s.Surprised); err != nil {
+			return nil, err
+		}
+		order = append(order, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	groupSlots(order)
+	slices.SortFunc(order, compareTurnOrder)
+	return order, nil
+}
+
+// slotIndex returns characterID's position in order, or -1 if it has none.
+func slotIndex(order []turnSlot, characterID int) int {
+	if characterID == 0 {
+		return -1
+	}
+	return slices.IndexFunc(order, func(s turnSlot) bool { return s.CharacterID == characterID })
+}
+
+// activeIndex returns the position of whoever holds the turn, a combatant or
+// an initiative event (as loaded by loadActiveTurn), or -1 if no one does. For
+// an initiative group it is the position of the group's first member.
+func activeIndex(order []turnSlot, characterID, eventID int) int {
+	if eventID != 0 {
+		return slices.IndexFunc(order, func(s turnSlot) bool { return s.EventID == eventID })
+	}
+	idx := slotIndex(order, characterID)
+	for idx > 0 && sameGroup(order[idx-1], order[idx]) {
+		idx--
+	}
+	return idx
+}
+
+// turnMembers returns the slots that take the turn starting at order[i]: the
+// whole initiative group when it is one, else just that slot.
+func turnMembers(order []turnSlot, i int) []turnSlot {
+	end := i + 1
+	for end < len(order) && sameGroup(order[i], order[end]) {
+		end++
+	}
+	return order[i:end]
+}
+
+// nextTurn picks who acts after the slot at index current: the next slot in
+// order, passing over anyone policy skips. An initiative group is one turn,
+// starting at its first member; it is skipped only when policy skips every
+// member, and then all of them are reported. Held combatants (delaying or
+// readying) are passed over silently; they are out of the rotation rather than
+// skipped. wrapped reports that the order went back past the top, i.e. a new
+// round. If current is -1 (no one active yet) the search starts at the top
+// without wrapping. When policy would skip every combatant not held, nothing
+// is skipped and the first of them is used, so the turn still moves. ok is
+// false only when every slot is held.
+func nextTurn(order []turnSlot, current int, policy string) (next int, wrapped bool, skipped []int, ok bool) {
+	start := current + 1
+	if current >= 0 {
+		start = current + len(turnMembers(order, current))
+	}
+	fallback, fallbackWrapped := -1, false
+	for step := range len(order) {
+		i := start + step
+		if i >= len(order) {
+			i -= len(order)
+			wrapped = true
+		}
+		if order[i].Held || (i > 0 && sameGroup(order[i-1], order[i])) {
+			continue
+		}
+		members := turnMembers(order, i)
+		if !slices.ContainsFunc(members, func(s turnSlot) bool { return !s.skippedBy(policy) }) {
+			if fallback < 0 {
+				fallback, fallbackWrapped = i, wrapped
+			}
+			for _, s := range members {
+				skipped = append(skipped, s.CharacterID)
+			}
+			continue
+		}
+		return i, wrapped, skipped, true
+	}
+	if fallback < 0 {
+		return 0, false, nil, false
+	}
+	return fallback, fallbackWrapped, nil, true
+}
+
+// resyncTurnIndex points turn_index back at the active combatant after
+// something reshuffled the order mid-fight (new initiatives, a new tiebreak).
+// It is a no-op before combat starts or while no one holds the turn.
+func resyncTurnIndex(tx *sql.Tx, encounterID int) error {
+	state, err := loadCombatState(tx, encounterID)
+	if err != nil || !state.CombatStarted {
+		return err
+	}
+	order, err := loadTurnOrder(tx, encounterID)
+	if err != nil {
+		return err
+	}
+	activeID, activeEventID, err := loadActiveTurn(tx, encounterID)
+	if err != nil {
+		return err
+	}
+	idx := activeIndex(order, activeID, activeEventID)
+	if idx < 0 || idx == state.TurnIndex {
+		return nil
+	}
+	state.TurnIndex = idx
+	return saveCombatState(tx, encounterID, state)
+}
@@ -0,0 +1,124 @@
+package dao
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCompareTurnOrder(t *testing.T) {
+	first, second := 0, 1
+	slots := []turnSlot{
+		{CharacterID: 1, Initiative: 12, DexModifier: 0},
+		{CharacterID: 2, Initiative: 15, DexModifier: 0},
+		// Ties on 12 with character 1 but has the better Dexterity.
+		{CharacterID: 3, Initiative: 12, DexModifier: 3},
+		// Tie on initiative and Dexterity: the DM's order decides, and a chosen
+		// position beats none.
+		{CharacterID: 4, Initiative: 12, DexModifier: 0, TiebreakOrder: &second},
+		{CharacterID: 5, Initiative: 12, DexModifier: 0, TiebreakOrder: &first},
+		// Negative modifiers still compare numerically.
+		{CharacterID: 6, Initiative: 12, DexModifier: -1},
+	}
+	slices.SortFunc(slots, compareTurnOrder)
+
+	var got []int
+	for _, s := range slots {
+		got = append(got, s.CharacterID)
+	}
+	want := []int{2, 3, 5, 4, 1, 6}
+	if !slices.Equal(got, want) {
+		t.Errorf("turn order = %v, want %v", got, want)
+	}
+}
+
+func TestNextTurn(t *testing.T) {
+	order := []turnSlot{
+		{CharacterID: 1, Held: true},
+		{CharacterID: 2, IsNPC: true, CurrentHP: 0},
+		{CharacterID: 3, Disabled: true},
+		{CharacterID: 4, IsNPC: true, CurrentHP: 0},
+	}
+	cases := []struct {
+		name        string
+		current     int
+		policy      string
+		wantNext    int
+		wantWrapped bool
+		wantSkipped []int
+	}{
+		{"no policy takes the next slot", 1, SkipPolicyNone, 2, false, nil},
+		{"defeated NPCs are passed over", 3, SkipPolicyDefeatedNPCs, 2, true, []int{2}},
+		{"disabled combatants are passed over", 1, SkipPolicyDisabled, 3, false, []int{3}},
+		{"skipping across the top starts a new round", 2, SkipPolicyDefeatedNPCs, 2, true, []int{4, 2}},
+		{"first advance starts at the top", -1, SkipPolicyNone, 1, false, nil},
+		{"held combatants are left out, not skipped", 3, SkipPolicyNone, 1, true, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			next, wrapped, skipped, ok := nextTurn(order, tc.current, tc.policy)
+			if !ok || next != tc.wantNext || wrapped != tc.wantWrapped || !slices.Equal(skipped, tc.wantSkipped) {
+				t.Errorf("nextTurn = (%d, %v, %v), want (%d, %v, %v)", next, wrapped, skipped, tc.wantNext, tc.wantWrapped, tc.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestNextTurn_EveryoneSkippedStillMoves(t *testing.T) {
+	order := []turnSlot{
+		{CharacterID: 1, IsNPC: true},
+		{CharacterID: 2, IsNPC: true},
+	}
+	next, wrapped, skipped, ok := nextTurn(order, 1, SkipPolicyDefeatedNPCs)
+	if !ok || next != 0 || !wrapped || skipped != nil {
+		t.Errorf("nextTurn = (%d, %v, %v, %v), want (0, true, [], true)", next, wrapped, skipped, ok)
+	}
+}
+
+func TestNextTurn_EveryoneHeld(t *testing.T) {
+	order := []turnSlot{{CharacterID: 1, Held: true}, {CharacterID: 2, Held: true}}
+	if _, _, _, ok := nextTurn(order, 0, SkipPolicyNone); ok {
+		t.Error("nextTurn found a combatant although everyone is held")
+	}
+}
+
+func TestGroupSlots_KeepsGroupTogether(t *testing.T) {
+	// Goblins 2 and 9 share template 4; 9's own keys would put the Orc (5)
+	// between them, but the group sorts as one block on its leader's keys.
+	slots := []turnSlot{
+		{CharacterID: 9, Initiative: 10, GroupID: 4},
+		{CharacterID: 5, Initiative: 12},
+		{CharacterID: 2, Initiative: 14, GroupID: 4},
+		{CharacterID: 7, Initiative: 14, DexModifier: -1},
+	}
+	groupSlots(slots)
+	slices.SortFunc(slots, compareTurnOrder)
+
+	var got []int
+	for _, s := range slots {
+		got = append(got, s.CharacterID)
+	}
+	want := []int{2, 9, 7, 5}
+	if !slices.Equal(got, want) {
+		t.Errorf("turn order = %v, want %v", got, want)
+	}
+}
+
+func TestNextTurn_GroupTakesOneTurn(t *testing.T) {
+	order := []turnSlot{
+		{CharacterID: 1},
+		{CharacterID: 2, GroupID: 4, IsNPC: true, CurrentHP: 0},
+		{CharacterID: 3, GroupID: 4, IsNPC: true, CurrentHP: 5},
+		{CharacterID: 6, GroupID: 8, IsNPC: true, CurrentHP: 0},
+		{CharacterID: 7, GroupID: 8, IsNPC: true, CurrentHP: 0},
+	}
+	// The group of 2 and 3 acts at its first slot even though 2 is down.
+	if next, _, skipped, _ := nextTurn(order, 0, SkipPolicyDefeatedNPCs); next != 1 || skipped != nil {
+		t.Errorf("from 1: next = %d, skipped %v; want 1, none", next, skipped)
+	}
+	// From the group's turn the order moves past all its members; the fully
+	// defeated group 8 is skipped as a whole.
+	next, wrapped, skipped, _ := nextTurn(order, 1, SkipPolicyDefeatedNPCs)
+	if next != 0 || !wrapped || !slices.Equal(skipped, []int{6, 7}) {
+		t.Errorf("from group: nextTurn = (%d, %v, %v), want (0, true, [6 7])", next, wrapped, skipped)
+	}
+}
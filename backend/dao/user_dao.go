@@ -2,6 +2,7 @@ package dao
 
 import (
 	"database/sql"
+	"errors"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
@@ -50,3 +51,25 @@ func (dao UserDAO) GetUserByUsername(username string) (User, error) {
 	err := row.Scan(&user.DiscordID, &user.Username, &user.Discriminator, &user.Avatar)
 	return user, err
 }
+
+// GetRuleset returns the user's preferred ruleset for new encounters, the
+// 2014 rules for anyone who has never signed in.
+func (dao UserDAO) GetRuleset(discordID string) (string, error) {
+	var ruleset string
+	err := dao.db.QueryRow(`SELECT ruleset FROM users WHERE discord_id = $1`, discordID).Scan(&ruleset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Ruleset2014, nil
+	}
+	return ruleset, err
+}
+
+// SetRuleset saves the user's preferred ruleset. Returns false when the user
+// does not exist.
+func (dao UserDAO) SetRuleset(discordID, ruleset string) (bool, error) {
+	result, err := dao.db.Exec(`UPDATE users SET ruleset = $1 WHERE discord_id = $2`, ruleset, discordID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
@@ -0,0 +1,248 @@
+// Package dice parses and rolls tabletop dice expressions such as "2d6+3",
+// "1d20adv", "4d6kh3" and "8d6/2".
+//
+// An expression is one or more terms joined by + or -, optionally followed by
+// /N to divide the total (rounding down, as 5e halves damage). A term is
+// either a flat number or NdS: N dice with S sides, N defaulting to 1. A dice
+// term may end in one modifier:
+//
+//	adv, dis  roll a single die twice and keep the higher or lower
+//	khK, klK  keep only the K highest or lowest dice
+package dice
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// Limits keep a single expression from rolling an unreasonable number of dice.
+const (
+	MaxDice  = 100
+	MaxSides = 1000
+	MaxTerms = 20
+)
+
+// Roller returns a uniformly random integer in [1, sides]. It is injected into
+// Roll so callers, and tests, decide where the randomness comes from.
+type Roller func(sides int) int
+
+// Random is the default Roller.
+func Random(sides int) int {
+	return rand.IntN(sides) + 1
+}
+
+// Term is one part of an expression. Sides is 0 for a flat number, whose
+// value is Count. Sign is +1 or -1. Keep is how many dice count toward the
+// term (0 keeps all of them); KeepLowest picks the lowest rather than the
+// highest.
+type Term struct {
+	Sign       int
+	Count      int
+	Sides      int
+	Keep       int
+	KeepLowest bool
+	// Mode is "adv" or "dis" when the term was written with one of those
+	// suffixes, which roll as 2dSkh1 and 2dSkl1.
+	Mode string
+}
+
+// Expression is a parsed dice expression. Divisor is 1 unless the expression
+// ends in /N.
+type Expression struct {
+	Terms   []Term
+	Divisor int
+}
+
+// Parse reads a dice expression. Matching is case insensitive, and whitespace
+// is allowed between tokens but not inside a number.
+func Parse(input string) (Expression, error) {
+	s := strings.ToLower(strings.TrimSpace(input))
+	if s == "" {
+		return Expression{}, fmt.Errorf("empty dice expression")
+	}
+	p := &parser{s: s}
+	expr := Expression{Divisor: 1}
+	sign := 1
+	if p.peek() == '-' || p.peek() == '+' {
+		if p.next() == '-' {
+			sign = -1
+		}
+	}
+	for {
+		term, err := p.term(sign)
+		if err != nil {
+			return Expression{}, err
+		}
+		expr.Terms = append(expr.Terms, term)
+		if len(expr.Terms) > MaxTerms {
+			return Expression{}, fmt.Errorf("too many terms (max %d)", MaxTerms)
+		}
+		if p.peek() != '+' && p.peek() != '-' {
+			break
+		}
+		sign = 1
+		if p.next() == '-' {
+			sign = -1
+		}
+	}
+	if p.peek() == '/' {
+		p.next()
+		divisor, ok := p.number()
+		if !ok || divisor < 1 {
+			return Expression{}, fmt.Errorf("expected a positive divisor after /")
+		}
+		expr.Divisor = divisor
+	}
+	if !p.done() {
+		return Expression{}, fmt.Errorf("unexpected %q at position %d", p.s[p.pos:], p.pos+1)
+	}
+	return expr, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) done() bool {
+	p.skipSpace()
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) next() byte {
+	c := p.peek()
+	p.pos++
+	return c
+}
+
+// number reads a run of digits, reporting false when there are none.
+func (p *parser) number() (int, bool) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return 0, false
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func (p *parser) consume(word string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], word) {
+		p.pos += len(word)
+		return true
+	}
+	return false
+}
+
+func (p *parser) term(sign int) (Term, error) {
+	count, hasCount := p.number()
+	if p.peek() != 'd' {
+		if !hasCount {
+			return Term{}, fmt.Errorf("expected a number or dice at position %d", p.pos+1)
+		}
+		return Term{Sign: sign, Count: count}, nil
+	}
+	p.next()
+	if !hasCount {
+		count = 1
+	}
+	sides, ok := p.number()
+	if !ok {
+		return Term{}, fmt.Errorf("expected the number of sides after d at position %d", p.pos+1)
+	}
+	if count < 1 || count > MaxDice {
+		return Term{}, fmt.Errorf("dice count must be between 1 and %d", MaxDice)
+	}
+	if sides < 2 || sides > MaxSides {
+		return Term{}, fmt.Errorf("dice sides must be between 2 and %d", MaxSides)
+	}
+	term := Term{Sign: sign, Count: count, Sides: sides}
+
+	switch {
+	case p.consume("adv"), p.consume("dis"):
+		if count != 1 {
+			return Term{}, fmt.Errorf("advantage and disadvantage apply to a single die")
+		}
+		term.Mode = p.s[p.pos-3 : p.pos]
+		term.Count, term.Keep, term.KeepLowest = 2, 1, term.Mode == "dis"
+	case p.consume("kh"), p.consume("kl"):
+		term.KeepLowest = p.s[p.pos-1] == 'l'
+		keep, ok := p.number()
+		if !ok || keep < 1 || keep > count {
+			return Term{}, fmt.Errorf("keep must be between 1 and the number of dice")
+		}
+		if keep < count {
+			term.Keep = keep
+		}
+	}
+	return term, nil
+}
+
+// String renders the expression in canonical form, e.g. "4d6kh3+2".
+func (e Expression) String() string {
+	var b strings.Builder
+	for i, t := range e.Terms {
+		if t.Sign < 0 {
+			b.WriteByte('-')
+		} else if i > 0 {
+			b.WriteByte('+')
+		}
+		b.WriteString(t.String())
+	}
+	if e.Divisor > 1 {
+		fmt.Fprintf(&b, "/%d", e.Divisor)
+	}
+	return b.String()
+}
+
+// String renders the term without its sign.
+func (t Term) String() string {
+	switch {
+	case t.Sides == 0:
+		return strconv.Itoa(t.Count)
+	case t.Mode != "":
+		return fmt.Sprintf("1d%d%s", t.Sides, t.Mode)
+	case t.Keep > 0 && t.KeepLowest:
+		return fmt.Sprintf("%dd%dkl%d", t.Count, t.Sides, t.Keep)
+	case t.Keep > 0:
+		return fmt.Sprintf("%dd%dkh%d", t.Count, t.Sides, t.Keep)
+	}
+	return fmt.Sprintf("%dd%d", t.Count, t.Sides)
+}
+
+// Critical returns the expression as rolled on a critical hit: every dice term
+// rolls twice as many dice (keeping twice as many), flat terms are unchanged.
+func (e Expression) Critical() Expression {
+	crit := Expression{Terms: make([]Term, len(e.Terms)), Divisor: e.Divisor}
+	for i, t := range e.Terms {
+		if t.Sides > 0 {
+			t.Count *= 2
+			t.Keep *= 2
+			t.Mode = ""
+		}
+		crit.Terms[i] = t
+	}
+	return crit
+}
@@ -0,0 +1,111 @@
+package dice
+
+import (
+	"testing"
+)
+
+// sequence returns a Roller that yields values in order, ignoring sides.
+func sequence(values ...int) Roller {
+	return func(int) int {
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+}
+
+func TestParseCanonicalForm(t *testing.T) {
+	cases := map[string]string{
+		"2d6+3":      "2d6+3",
+		" 1D20 ADV ": "1d20adv",
+		"d20dis-1":   "1d20dis-1",
+		"4d6kh3":     "4d6kh3",
+		"4d6kh4":     "4d6",
+		"2d20kl1+5":  "2d20kl1+5",
+		"8d6/2":      "8d6/2",
+		"-2+1d4":     "-2+1d4",
+	}
+	for input, want := range cases {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", input, err)
+			continue
+		}
+		if got := expr.String(); got != want {
+			t.Errorf("Parse(%q).String() = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestParseRejectsInvalidExpressions(t *testing.T) {
+	for _, input := range []string{
+		"", "d", "2d", "2d1", "2x6", "2d6+", "3d6adv", "4d6kh5", "4d6kh0",
+		"8d6/0", "8d6/", "101d6", "1d1001", "2d6 3",
+	} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestCriticalDoublesDiceOnly(t *testing.T) {
+	expr, err := Parse("2d6+2d8kh1-1d4+3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := expr.Critical().String(), "4d6+4d8kh2-2d4+3"; got != want {
+		t.Errorf("Critical() = %q, want %q", got, want)
+	}
+}
+
+func TestRoll(t *testing.T) {
+	cases := []struct {
+		input string
+		rolls []int
+		total int
+		kept  []bool
+	}{
+		{"2d6+3", []int{4, 5}, 12, []bool{true, true}},
+		{"1d20adv", []int{7, 15}, 15, []bool{false, true}},
+		{"1d20dis", []int{7, 15}, 7, []bool{true, false}},
+		{"4d6kh3", []int{3, 1, 6, 3}, 12, []bool{true, false, true, true}},
+		{"8d6/2", []int{1, 2, 3, 4, 5, 6, 1, 1}, 11, nil},
+		{"1d4-5/2", []int{2}, -2, nil},
+	}
+	for _, c := range cases {
+		result, err := Roll(c.input, sequence(c.rolls...))
+		if err != nil {
+			t.Fatalf("Roll(%q): %v", c.input, err)
+		}
+		if result.Total != c.total {
+			t.Errorf("Roll(%q) total = %d, want %d", c.input, result.Total, c.total)
+		}
+		dice := result.Terms[0].Dice
+		if len(dice) != len(c.rolls) {
+			t.Errorf("Roll(%q) rolled %d dice, want %d", c.input, len(dice), len(c.rolls))
+			continue
+		}
+		for i, keep := range c.kept {
+			if dice[i].Kept != keep {
+				t.Errorf("Roll(%q) die %d kept = %v, want %v", c.input, i, dice[i].Kept, keep)
+			}
+		}
+	}
+}
+
+func TestResultDescribe(t *testing.T) {
+	result, err := Roll("4d6kh3-1", sequence(6, 5, 1, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := result.Describe(), "4d6kh3 [6, 5, (1), 4] - 1 = 14"; got != want {
+		t.Errorf("Describe() = %q, want %q", got, want)
+	}
+}
+
+func TestRandomStaysInRange(t *testing.T) {
+	for range 1000 {
+		if v := Random(6); v < 1 || v > 6 {
+			t.Fatalf("Random(6) = %d", v)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package dice
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Die is one die rolled for a term. Kept is false for dice dropped by adv,
+// dis, kh or kl.
+type Die struct {
+	Sides int  `json:"sides"`
+	Value int  `json:"value"`
+	Kept  bool `json:"kept"`
+}
+
+// TermResult is how one term rolled: its dice, if any, and its signed
+// contribution to the total.
+type TermResult struct {
+	Term     string `json:"term"`
+	Dice     []Die  `json:"dice,omitempty"`
+	Subtotal int    `json:"subtotal"`
+}
+
+// Result is a rolled expression with its full breakdown, shaped for the
+// ledger's details column.
+type Result struct {
+	Expression string       `json:"expression"`
+	Terms      []TermResult `json:"terms"`
+	Divisor    int          `json:"divisor,omitempty"`
+	Total      int          `json:"total"`
+}
+
+// Roll parses input and rolls it with rng.
+func Roll(input string, rng Roller) (Result, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		return Result{}, err
+	}
+	return expr.Roll(rng), nil
+}
+
+// Roll rolls every term with rng. The total is the sum of the terms divided
+// by the divisor, rounding down.
+func (e Expression) Roll(rng Roller) Result {
+	result := Result{Expression: e.String(), Terms: make([]TermResult, 0, len(e.Terms))}
+	sum := 0
+	for _, t := range e.Terms {
+		tr := t.roll(rng)
+		sum += tr.Subtotal
+		result.Terms = append(result.Terms, tr)
+	}
+	result.Total = sum
+	if e.Divisor > 1 {
+		result.Divisor = e.Divisor
+		// Round toward negative infinity, so -7/2 is -4 as well as 7/2 being 3.
+		result.Total = sum / e.Divisor
+		if sum%e.Divisor != 0 && sum < 0 {
+			result.Total--
+		}
+	}
+	return result
+}
+
+func (t Term) roll(rng Roller) TermResult {
+	label := t.String()
+	if t.Sign < 0 {
+		label = "-" + label
+	}
+	if t.Sides == 0 {
+		return TermResult{Term: label, Subtotal: t.Sign * t.Count}
+	}
+
+	dice := make([]Die, t.Count)
+	for i := range dice {
+		dice[i] = Die{Sides: t.Sides, Value: rng(t.Sides), Kept: true}
+	}
+	if t.Keep > 0 {
+		// Rank the dice, then drop everything past the first Keep. Ties go to
+		// the earlier die so the breakdown reads naturally.
+		order := make([]int, len(dice))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortStableFunc(order, func(a, b int) int {
+			if t.KeepLowest {
+				return dice[a].Value - dice[b].Value
+			}
+			return dice[b].Value - dice[a].Value
+		})
+		for _, i := range order[t.Keep:] {
+			dice[i].Kept = false
+		}
+	}
+	subtotal := 0
+	for _, d := range dice {
+		if d.Kept {
+			subtotal += d.Value
+		}
+	}
+	return TermResult{Term: label, Dice: dice, Subtotal: t.Sign * subtotal}
+}
+
+// Describe renders the breakdown for a log line, e.g.
+// "4d6kh3 [6, 5, (1), 4] + 2 = 17". Dropped dice are shown in parentheses.
+func (r Result) Describe() string {
+	var b strings.Builder
+	for i, tr := range r.Terms {
+		if i > 0 {
+			if strings.HasPrefix(tr.Term, "-") {
+				b.WriteString(" - ")
+			} else {
+				b.WriteString(" + ")
+			}
+		}
+		b.WriteString(strings.TrimPrefix(tr.Term, "-"))
+		if len(tr.Dice) > 0 {
+			b.WriteString(" [")
+			for j, d := range tr.Dice {
+				if j > 0 {
+					b.WriteString(", ")
+				}
+				if d.Kept {
+					b.WriteString(strconv.Itoa(d.Value))
+				} else {
+					fmt.Fprintf(&b, "(%d)", d.Value)
+				}
+			}
+			b.WriteString("]")
+		}
+	}
+	if r.Divisor > 1 {
+		fmt.Fprintf(&b, " / %d", r.Divisor)
+	}
+	fmt.Fprintf(&b, " = %d", r.Total)
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-initiative-tracker/dao"
+	"go-initiative-tracker/dice"
+	"net/http"
+	"strings"
+)
+
+// rollDie is the dice roller behind every server-side roll; tests replace it
+// to make results deterministic.
+var rollDie dice.Roller = dice.Random
+
+// apiRollDiceHandler rolls a dice expression such as "2d6+3", "1d20adv",
+// "4d6kh3" or "8d6/2" and returns every die alongside the total. With an
+// encounter_id the roll is also posted to that encounter's ledger as a "roll"
+// entry whose details carry the full breakdown.
+func apiRollDiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		Expression  string `json:"expression"`
+		EncounterID int    `json:"encounter_id"`
+		ActorID     int    `json:"actor_id"`
+		Label       string `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	expr, err := dice.Parse(req.Expression)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid dice expression: "+err.Error())
+		return
+	}
+	if req.EncounterID < 0 || req.ActorID < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or actor id")
+		return
+	}
+	if req.EncounterID > 0 && !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+	// The roll is logged under the actor, who must be one of the encounter's
+	// combatants.
+	if req.EncounterID > 0 && req.ActorID > 0 {
+		if _, err := encounterCharacterDAO.GetByEncounterAndCharacter(req.EncounterID, req.ActorID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+				return
+			}
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load character")
+			return
+		}
+	}
+
+	result := expr.Roll(rollDie)
+	response := map[string]any{"status": "success", "roll": result}
+	if req.EncounterID > 0 {
+		description := "Rolled " + result.Describe()
+		if label := strings.TrimSpace(req.Label); label != "" {
+			description = label + ": " + result.Describe()
+		}
+		entry, err := encounterLedgerDAO.Create(dao.EncounterLedgerInsert{
+			EncounterID: req.EncounterID,
+			ActorID:     req.ActorID,
+			ActionType:  dao.LedgerRoll,
+			Description: description,
+			Details:     result,
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to add combat log entry")
+			return
+		}
+		events.publish(req.EncounterID, "ledger")
+		response["entry"] = entry
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// stubDice makes rollDie return values in order and returns a restore func.
+func stubDice(t *testing.T, values ...int) func() {
+	t.Helper()
+	prev := rollDie
+	rollDie = func(int) int {
+		if len(values) == 0 {
+			t.Fatal("rolled more dice than stubbed")
+		}
+		v := values[0]
+		values = values[1:]
+		return v
+	}
+	return func() { rollDie = prev }
+}
+
+func TestRollDiceReturnsBreakdownWithoutEncounter(t *testing.T) {
+	defer stubDice(t, 6, 2, 5, 4)()
+
+	rr, req := postJSON("/dice/roll", `{"expression":"4d6kh3+1"}`)
+	apiRollDiceHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	var body struct {
+		Roll struct {
+			Expression string `json:"expression"`
+			Total      int    `json:"total"`
+			Terms      []struct {
+				Dice []struct {
+					Value int  `json:"value"`
+					Kept  bool `json:"kept"`
+				} `json:"dice"`
+			} `json:"terms"`
+		} `json:"roll"`
+		Entry *json.RawMessage `json:"entry"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Roll.Expression != "4d6kh3+1" || body.Roll.Total != 16 {
+		t.Errorf("unexpected roll: %+v", body.Roll)
+	}
+	if dropped := body.Roll.Terms[0].Dice[1]; dropped.Value != 2 || dropped.Kept {
+		t.Errorf("expected the 2 to be dropped, got %+v", dropped)
+	}
+	if body.Entry != nil {
+		t.Error("a roll without an encounter must not create a ledger entry")
+	}
+}
+
+func TestRollDicePostsToLedger(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	defer stubDice(t, 4, 5)()
+	ch := events.subscribe(1)
+	defer events.unsubscribe(1, ch)
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("FROM encounter_characters WHERE encounter_id").WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"encounter_id", "character_id", "initiative", "current_hp", "is_active"}).AddRow(1, 2, 12, 20, false))
+	m.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(1, 2, 0, "roll", 0, "Longsword: 2d6 [4, 5] + 3 = 12",
+			`{"expression":"2d6+3","terms":[{"term":"2d6","dice":[{"sides":6,"value":4,"kept":true},{"sides":6,"value":5,"kept":true}],"subtotal":9},{"term":"3","subtotal":3}],"total":12}`).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "encounter_id", "actor_id", "actor_name", "target_id",
+			"target_name", "action_type", "hp_change", "description", "details", "created_at",
+		}).AddRow(1, 1, 2, "Hero", 0, "", "roll", 0, "Longsword: 2d6 [4, 5] + 3 = 12", nil, "2026-07-18T00:00:00Z"))
+
+	rr, req := postJSON("/dice/roll", `{"expression":"2d6+3","encounter_id":1,"actor_id":2,"label":"Longsword"}`)
+	authed(req, "dm1")
+	apiRollDiceHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+	select {
+	case msg := <-ch:
+		if msg != "ledger" {
+			t.Errorf("published %q, want ledger", msg)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+}
+
+func TestRollDiceRejectsActorFromAnotherEncounter(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("FROM encounter_characters WHERE encounter_id").WithArgs(1, 9).
+		WillReturnError(sql.ErrNoRows)
+
+	rr, req := postJSON("/dice/roll", `{"expression":"1d20","encounter_id":1,"actor_id":9}`)
+	authed(req, "dm1")
+	apiRollDiceHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusNotFound)
+	assertMet(t, m)
+}
+
+func TestRollDiceRejectsInvalidExpression(t *testing.T) {
+	rr, req := postJSON("/dice/roll", `{"expression":"3d6adv"}`)
+	apiRollDiceHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
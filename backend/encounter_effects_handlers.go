@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"go-initiative-tracker/dao"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// apiEncounterEffectsHandler lists an encounter's battlefield effects (Wall of
+// Fire, Cloudkill and the like) with the rounds each has left. Clients refetch
+// it on an "effects" event.
+func apiEncounterEffectsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	encounterID, err := strconv.Atoi(r.URL.Query().Get("encounter_id"))
+	if err != nil || encounterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter id")
+		return
+	}
+	if !requireEncounterAccess(w, r, encounterID) {
+		return
+	}
+
+	effects, err := encounterEffectDAO.ListByEncounter(encounterID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to load encounter effects")
+		return
+	}
+	if effects == nil {
+		effects = []dao.EncounterEffect{}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "effects": effects})
+}
+
+// apiAddEncounterEffectHandler adds a battlefield effect to an encounter. A
+// timed effect ticks down once a round at the start or end (tick_at, "end" by
+// default) of tick_character_id's turn, or its owner's when that is omitted;
+// leaving out duration_rounds keeps it until removed.
+func apiAddEncounterEffectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID     int    `json:"encounter_id"`
+		Name            string `json:"name"`
+		Description     string `json:"description"`
+		OwnerID         int    `json:"owner_id"`
+		DurationRounds  *int   `json:"duration_rounds"`
+		TickCharacterID int    `json:"tick_character_id"`
+		TickAt          string `json:"tick_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.OwnerID < 0 || req.TickCharacterID < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or combatant id")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+	if req.DurationRounds != nil && *req.DurationRounds <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Duration must be greater than 0")
+		return
+	}
+	if req.TickAt != "" && !slices.Contains(dao.ValidEffectTicks, req.TickAt) {
+		writeJSONError(w, http.StatusBadRequest, "tick_at must be start or end")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	effect, err := encounterEffectDAO.Create(dao.EncounterEffect{
+		EncounterID:     req.EncounterID,
+		Name:            req.Name,
+		Description:     strings.TrimSpace(req.Description),
+		OwnerID:         req.OwnerID,
+		DurationRounds:  req.DurationRounds,
+		TickCharacterID: req.TickCharacterID,
+		TickAt:          req.TickAt,
+	})
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "needs a combatant"):
+			writeJSONError(w, http.StatusBadRequest, "A timed effect needs an owner or tick_character_id")
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Combatant not in encounter")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to add encounter effect")
+		}
+		return
+	}
+
+	events.publish(req.EncounterID, "effects")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "effect": effect})
+}
+
+// apiRemoveEncounterEffectHandler removes a battlefield effect by id, scoped
+// to its encounter so a stale id cannot touch another fight.
+func apiRemoveEncounterEffectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int `json:"encounter_id"`
+		EffectID    int `json:"effect_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.EffectID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or effect id")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	removed, err := encounterEffectDAO.Delete(req.EncounterID, req.EffectID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to remove encounter effect")
+		return
+	}
+	if !removed {
+		writeJSONError(w, http.StatusNotFound, "Encounter effect not found")
+		return
+	}
+
+	events.publish(req.EncounterID, "effects")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
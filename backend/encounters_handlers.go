@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"go-initiative-tracker/dao"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -51,6 +52,19 @@ func apiSaveEncounterHandler(w http.ResponseWriter, r *http.Request) {
 	if enc.OwnerID == "" {
 		enc.OwnerID = getDiscordIDFromRequest(r)
 	}
+	// Without an explicit ruleset the encounter follows its owner's preference.
+	if enc.Ruleset != "" && !slices.Contains(dao.ValidRulesets, enc.Ruleset) {
+		writeJSONError(w, http.StatusBadRequest, "Ruleset must be 2014 or 2024")
+		return
+	}
+	if enc.Ruleset == "" && enc.OwnerID != "" {
+		ruleset, err := userDAO.GetRuleset(enc.OwnerID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to load preferences")
+			return
+		}
+		enc.Ruleset = ruleset
+	}
 	newID, err := encounterDAO.CreateEncounter(enc)
 	if err != nil {
 		writeJSONError(w, http.StatusInternalServerError, "Failed to create encounter")
@@ -61,6 +75,49 @@ func apiSaveEncounterHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]any{"status": "success", "encounter": enc})
 }
 
+// apiEncounterSettingsHandler updates an encounter's combat settings. Fields
+// left out of the payload keep their current value.
+func apiEncounterSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int     `json:"encounter_id"`
+		SkipPolicy  *string `json:"skip_policy"`
+		Ruleset     *string `json:"ruleset"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter id")
+		return
+	}
+	if req.SkipPolicy != nil && !slices.Contains(dao.ValidSkipPolicies, *req.SkipPolicy) {
+		writeJSONError(w, http.StatusBadRequest, "Skip policy must be none, defeated_npcs or disabled")
+		return
+	}
+	if req.Ruleset != nil && !slices.Contains(dao.ValidRulesets, *req.Ruleset) {
+		writeJSONError(w, http.StatusBadRequest, "Ruleset must be 2014 or 2024")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	if _, err := encounterDAO.UpdateSettings(req.EncounterID, dao.EncounterSettingsUpdate{SkipPolicy: req.SkipPolicy, Ruleset: req.Ruleset}); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update encounter settings")
+		return
+	}
+
+	events.publish(req.EncounterID, "combat")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
 func apiDeleteEncounterHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
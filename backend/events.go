@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"sync"
@@ -62,6 +63,18 @@ func (h *eventHub) publish(encounterID int, msg string) {
 	}
 }
 
+// publishJSON publishes v encoded as JSON, for events that carry details
+// beyond the usual one-word nudge (such as an HP change's before and after
+// values). Clients that only listen for "something changed" treat it like
+// any other message.
+func (h *eventHub) publishJSON(encounterID int, v any) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	h.publish(encounterID, string(msg))
+}
+
 // apiEncounterEventsHandler streams encounter change notifications to a viewer as
 // Server-Sent Events. It broadcasts a nudge ("something changed"), not state; the
 // client responds by re-fetching characters and the ledger. Access is gated the
@@ -23,8 +23,8 @@ func newFullMock(t *testing.T) (sqlmock.Sqlmock, func()) {
 	if err != nil {
 		t.Fatalf("failed to open mock database: %v", err)
 	}
-	prevChar, prevEnc, prevEC, prevLedger, prevNpc, prevFriend, prevUser :=
-		characterDAO, encounterDAO, encounterCharacterDAO, encounterLedgerDAO, npcTemplateDAO, friendshipDAO, userDAO
+	prevChar, prevEnc, prevEC, prevLedger, prevNpc, prevFriend, prevUser, prevEvents, prevEffects :=
+		characterDAO, encounterDAO, encounterCharacterDAO, encounterLedgerDAO, npcTemplateDAO, friendshipDAO, userDAO, initiativeEventDAO, encounterEffectDAO
 	characterDAO = dao.NewCharacterDAO(mockDB)
 	encounterDAO = dao.NewEncounterDAO(mockDB)
 	encounterCharacterDAO = dao.NewEncounterCharacterDAO(mockDB)
@@ -32,9 +32,11 @@ func newFullMock(t *testing.T) (sqlmock.Sqlmock, func()) {
 	npcTemplateDAO = dao.NewNpcTemplateDAO(mockDB)
 	friendshipDAO = dao.NewFriendshipDAO(mockDB)
 	userDAO = dao.NewUserDAO(mockDB)
+	initiativeEventDAO = dao.NewInitiativeEventDAO(mockDB)
+	encounterEffectDAO = dao.NewEncounterEffectDAO(mockDB)
 	return m, func() {
-		characterDAO, encounterDAO, encounterCharacterDAO, encounterLedgerDAO, npcTemplateDAO, friendshipDAO, userDAO =
-			prevChar, prevEnc, prevEC, prevLedger, prevNpc, prevFriend, prevUser
+		characterDAO, encounterDAO, encounterCharacterDAO, encounterLedgerDAO, npcTemplateDAO, friendshipDAO, userDAO, initiativeEventDAO, encounterEffectDAO =
+			prevChar, prevEnc, prevEC, prevLedger, prevNpc, prevFriend, prevUser, prevEvents, prevEffects
 		mockDB.Close()
 	}
 }
@@ -80,6 +82,7 @@ func TestHandlersRejectWrongMethod(t *testing.T) {
 		{"encounters", apiEncountersHandler, http.MethodPost},
 		{"encounters/save", apiSaveEncounterHandler, http.MethodGet},
 		{"encounters/delete", apiDeleteEncounterHandler, http.MethodGet},
+		{"encounters/settings", apiEncounterSettingsHandler, http.MethodGet},
 		{"characters", apiCharactersHandler, http.MethodPost},
 		{"characters/library", apiLibraryCharactersHandler, http.MethodPost},
 		{"characters/library/save", apiSaveLibraryCharacterHandler, http.MethodGet},
@@ -87,9 +90,36 @@ func TestHandlersRejectWrongMethod(t *testing.T) {
 		{"combat/start", apiStartCombatHandler, http.MethodGet},
 		{"combat/setup", apiResetCombatHandler, http.MethodGet},
 		{"combat/next-turn", apiNextTurnHandler, http.MethodGet},
+		{"combat/previous-turn", apiPreviousTurnHandler, http.MethodGet},
 		{"combat/set-active", apiSetActiveHandler, http.MethodGet},
+		{"combat/tiebreak", apiTiebreakHandler, http.MethodGet},
+		{"combat/roll-initiative", apiRollInitiativeHandler, http.MethodGet},
+		{"combat/delay", apiDelayHandler, http.MethodGet},
+		{"combat/ready", apiReadyHandler, http.MethodGet},
+		{"combat/rejoin", apiRejoinHandler, http.MethodGet},
+		{"combat/group", apiInitiativeGroupHandler, http.MethodGet},
+		{"initiative-events/add", apiAddInitiativeEventHandler, http.MethodGet},
+		{"initiative-events/remove", apiRemoveInitiativeEventHandler, http.MethodGet},
 		{"ledger", apiEncounterLedgerHandler, http.MethodPost},
 		{"ledger/add", apiAddEncounterLedgerHandler, http.MethodGet},
+		{"damage-types/catalog", apiDamageTypeCatalogHandler, http.MethodPost},
+		{"conditions/custom", apiCustomConditionsHandler, http.MethodPost},
+		{"conditions/custom/save", apiSaveCustomConditionHandler, http.MethodGet},
+		{"conditions/custom/delete", apiDeleteCustomConditionHandler, http.MethodGet},
+		{"hp/apply", apiApplyHPHandler, http.MethodGet},
+		{"attack", apiAttackHandler, http.MethodGet},
+		{"saves", apiGroupSaveHandler, http.MethodGet},
+		{"concentration/set", apiSetConcentrationHandler, http.MethodGet},
+		{"concentration/end", apiEndConcentrationHandler, http.MethodGet},
+		{"death-save", apiDeathSaveHandler, http.MethodGet},
+		{"legendary/spend", apiSpendLegendaryHandler, http.MethodGet},
+		{"legendary/restore", apiRestoreLegendaryHandler, http.MethodGet},
+		{"resources/spend", apiSpendResourceHandler, http.MethodGet},
+		{"resources/restore", apiRestoreResourceHandler, http.MethodGet},
+		{"effects", apiEncounterEffectsHandler, http.MethodPost},
+		{"effects/add", apiAddEncounterEffectHandler, http.MethodGet},
+		{"effects/remove", apiRemoveEncounterEffectHandler, http.MethodGet},
+		{"dice/roll", apiRollDiceHandler, http.MethodGet},
 		{"events", apiEncounterEventsHandler, http.MethodPost},
 		{"npcs/templates", apiNpcTemplatesHandler, http.MethodPost},
 		{"npcs/templates/save", apiSaveNpcTemplateHandler, http.MethodGet},
@@ -103,6 +133,8 @@ func TestHandlersRejectWrongMethod(t *testing.T) {
 		{"members", apiEncounterMembersHandler, http.MethodPost},
 		{"members/add", apiAddEncounterMemberHandler, http.MethodGet},
 		{"members/remove", apiRemoveEncounterMemberHandler, http.MethodGet},
+		{"me/preferences", apiPreferencesHandler, http.MethodPost},
+		{"me/preferences/save", apiSavePreferencesHandler, http.MethodGet},
 		{"version", apiVersionHandler, http.MethodPost},
 	}
 	for _, tc := range cases {
@@ -135,9 +167,25 @@ func TestHandlersRejectInvalidJSON(t *testing.T) {
 		{"combat/start", apiStartCombatHandler, "/encounters/combat/start"},
 		{"combat/set-active", apiSetActiveHandler, "/encounters/combat/set-active"},
 		{"ledger/add", apiAddEncounterLedgerHandler, "/encounters/ledger/add"},
+		{"conditions/custom/save", apiSaveCustomConditionHandler, "/conditions/custom/save"},
+		{"conditions/custom/delete", apiDeleteCustomConditionHandler, "/conditions/custom/delete"},
+		{"hp/apply", apiApplyHPHandler, "/encounters/hp/apply"},
+		{"attack", apiAttackHandler, "/encounters/attack"},
+		{"saves", apiGroupSaveHandler, "/encounters/saves"},
+		{"concentration/set", apiSetConcentrationHandler, "/encounters/concentration/set"},
+		{"concentration/end", apiEndConcentrationHandler, "/encounters/concentration/end"},
+		{"death-save", apiDeathSaveHandler, "/encounters/death-save"},
+		{"legendary/spend", apiSpendLegendaryHandler, "/encounters/legendary/spend"},
+		{"legendary/restore", apiRestoreLegendaryHandler, "/encounters/legendary/restore"},
+		{"resources/spend", apiSpendResourceHandler, "/encounters/resources/spend"},
+		{"resources/restore", apiRestoreResourceHandler, "/encounters/resources/restore"},
+		{"effects/add", apiAddEncounterEffectHandler, "/encounters/effects/add"},
+		{"effects/remove", apiRemoveEncounterEffectHandler, "/encounters/effects/remove"},
+		{"dice/roll", apiRollDiceHandler, "/dice/roll"},
 		{"npcs/save", apiSaveNpcTemplateHandler, "/npcs/templates/save"},
 		{"npcs/delete", apiDeleteNpcTemplateHandler, "/npcs/templates/delete"},
 		{"npcs/create-character", apiCreateCharacterFromTemplateHandler, "/npcs/templates/create-character"},
+		{"me/preferences/save", apiSavePreferencesHandler, "/me/preferences/save"},
 		{"members/add", apiAddEncounterMemberHandler, "/encounters/members/add"},
 		{"members/remove", apiRemoveEncounterMemberHandler, "/encounters/members/remove"},
 	}
@@ -305,10 +353,16 @@ func TestSetActiveAllowsOwner(t *testing.T) {
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
 	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(1, 0, true))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = FALSE").WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 3))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = TRUE").WithArgs(1, 7).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("SELECT ec.character_id, COALESCE").WithArgs(1).
+		WillReturnRows(turnOrderRows(5, 7))
+	m.ExpectExec("UPDATE encounters SET round").WithArgs(1, 1, true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 	m.ExpectCommit()
 
 	rr, req := postJSON("/encounters/combat/set-active", `{"encounter_id":1,"character_id":7}`)
@@ -335,6 +389,8 @@ func TestSetActiveCharacterNotInEncounterIs404(t *testing.T) {
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
 	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(1, 0, true))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = FALSE").WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 3))
 	// The target isn't in the encounter, so the second UPDATE affects no rows.
@@ -356,18 +412,36 @@ func TestNextTurnAllowsOwner(t *testing.T) {
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
 	m.ExpectBegin()
-	m.ExpectQuery("SELECT character_id FROM encounter_characters").WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"character_id"}).AddRow(5).AddRow(2).AddRow(8))
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(1, 0, true))
+	m.ExpectQuery("SELECT ec.character_id, COALESCE").WithArgs(1).
+		WillReturnRows(turnOrderRows(5, 2, 8))
 	m.ExpectQuery("AND is_active = TRUE").WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(5))
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "event_id"}).AddRow(5, 0))
+	m.ExpectQuery("SELECT skip_policy FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"skip_policy"}).AddRow("none"))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = FALSE").WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 3))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = TRUE").WithArgs(1, 2).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("SET resuming = FALSE").WithArgs(1, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"character_id"}))
+	m.ExpectQuery("SET legendary_actions = ec.legendary_actions_max").WithArgs(1, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "legendary_actions"}))
+	m.ExpectQuery("FROM encounter_resources").WithArgs(1, "{2}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id", "name", "name", "current_uses", "recharge", "recharge_on"}))
+	m.ExpectQuery("FROM encounter_character_conditions").WithArgs(1, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "character_id", "condition", "duration_rounds", "level", "note"}))
+	m.ExpectQuery("FROM encounter_effects").WithArgs(1, "{2}", "{5}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "name", "description", "owner_id", "duration_rounds", "tick_character_id", "tick_at"}))
+	m.ExpectExec("INSERT INTO encounter_turn_history").WithArgs(1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	m.ExpectExec("UPDATE encounter_character_conditions SET duration_rounds").WithArgs(1, 5).
 		WillReturnResult(sqlmock.NewResult(0, 0))
 	m.ExpectExec("DELETE FROM encounter_character_conditions").WithArgs(1, 5).
 		WillReturnResult(sqlmock.NewResult(0, 0))
+	m.ExpectExec("UPDATE encounters SET round").WithArgs(1, 1, true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
 	m.ExpectCommit()
 
 	rr, req := postJSON("/encounters/combat/next-turn", `{"encounter_id":1}`)
@@ -375,6 +449,287 @@ func TestNextTurnAllowsOwner(t *testing.T) {
 	apiNextTurnHandler(rr, req)
 
 	assertStatus(t, rr, http.StatusOK)
+	var body struct {
+		Active    int `json:"active_character_id"`
+		Round     int `json:"round"`
+		TurnIndex int `json:"turn_index"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Active != 2 || body.Round != 1 || body.TurnIndex != 1 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+	assertMet(t, m)
+}
+
+func TestPreviousTurnAllowsOwner(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(2, 1, true))
+	m.ExpectQuery("FROM encounter_turn_history").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "snapshot"}).
+			AddRow(4, []byte(`{"active_character_id":5,"state":{"Round":2,"TurnIndex":0,"CombatStarted":true}}`)))
+	m.ExpectExec("UPDATE encounter_characters SET is_active = FALSE").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	m.ExpectExec("UPDATE encounter_characters SET is_active = TRUE").WithArgs(1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec("UPDATE encounters SET round").WithArgs(2, 0, true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec("DELETE FROM encounter_turn_history").WithArgs(4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/combat/previous-turn", `{"encounter_id":1}`)
+	authed(req, "dm1")
+	apiPreviousTurnHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestNextTurnWithStaleExpectationConflicts(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(2, 1, true))
+	m.ExpectQuery("AND is_active = TRUE").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "event_id"}).AddRow(2, 0))
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/combat/next-turn", `{"encounter_id":1,"expected_active_character_id":5}`)
+	authed(req, "dm1")
+	apiNextTurnHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusConflict)
+	var body struct {
+		Status    string `json:"status"`
+		Active    int    `json:"active_character_id"`
+		Round     int    `json:"round"`
+		TurnIndex int    `json:"turn_index"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Status != "error" || body.Active != 2 || body.Round != 2 || body.TurnIndex != 1 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+	assertMet(t, m)
+}
+
+func TestPreviousTurnWithNoHistoryConflicts(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(0, 0, false))
+	m.ExpectQuery("FROM encounter_turn_history").WithArgs(1).WillReturnError(sql.ErrNoRows)
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/combat/previous-turn", `{"encounter_id":1}`)
+	authed(req, "dm1")
+	apiPreviousTurnHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusConflict)
+	assertMet(t, m)
+}
+
+func TestTiebreakAllowsOwner(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectExec("SET tiebreak_order = NULL").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	m.ExpectExec("SET tiebreak_order = \\$1").WithArgs(0, 1, 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec("SET tiebreak_order = \\$1").WithArgs(1, 1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(0, 0, false))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/combat/tiebreak", `{"encounter_id":1,"character_ids":[8,5]}`)
+	authed(req, "dm1")
+	apiTiebreakHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestTiebreakRejectsDuplicateCharacter(t *testing.T) {
+	rr, req := postJSON("/encounters/combat/tiebreak", `{"encounter_id":1,"character_ids":[8,8]}`)
+	authed(req, "dm1")
+	apiTiebreakHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestRollInitiativeAllowsOwner(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	defer stubDice(t, 11, 11)()
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT ec.character_id, c.name, c.type").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "name", "type", "unset", "dex_modifier", "group_id"}).
+			AddRow(5, "Goblin", "npc", true, 2, 0))
+	m.ExpectExec("UPDATE encounter_characters SET initiative").WithArgs(13, 1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(0, 0, false))
+	m.ExpectQuery("INSERT INTO encounter_ledger").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encounter_id", "actor_id", "actor_name", "target_id", "target_name", "action_type", "hp_change", "description", "details", "created_at"}).
+			AddRow(1, 1, 0, "", 0, "", "initiative", 0, "", nil, "2026-01-01T00:00:00Z"))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/combat/roll-initiative", `{"encounter_id":1,"scope":"npcs","advantage":[5]}`)
+	authed(req, "dm1")
+	apiRollInitiativeHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	var body struct {
+		Rolls []struct {
+			CharacterID int   `json:"character_id"`
+			Dice        []int `json:"dice"`
+			Total       int   `json:"total"`
+		} `json:"rolls"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if len(body.Rolls) != 1 || body.Rolls[0].Total != 13 || len(body.Rolls[0].Dice) != 2 {
+		t.Errorf("rolls = %+v, want one advantage roll totalling 13", body.Rolls)
+	}
+	assertMet(t, m)
+}
+
+func TestRollInitiativeRejectsUnknownScope(t *testing.T) {
+	rr, req := postJSON("/encounters/combat/roll-initiative", `{"encounter_id":1,"scope":"pcs"}`)
+	authed(req, "dm1")
+	apiRollInitiativeHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestDelayCharacterNotInEncounterIs404(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(1, 0, true))
+	m.ExpectQuery("SELECT initiative, turn_rank, held FROM encounter_characters").WithArgs(1, 99).
+		WillReturnError(sql.ErrNoRows)
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/combat/delay", `{"encounter_id":1,"character_id":99}`)
+	authed(req, "dm1")
+	apiDelayHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusNotFound)
+	assertMet(t, m)
+}
+
+func TestRejoinRejectsUnknownPosition(t *testing.T) {
+	rr, req := postJSON("/encounters/combat/rejoin", `{"encounter_id":1,"character_id":5,"position":"later"}`)
+	authed(req, "dm1")
+	apiRejoinHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestInitiativeGroupUnknownTemplateIs404(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("FROM encounter_characters ec JOIN characters c").WithArgs(1, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id", "initiative", "held", "is_active"}))
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/combat/group", `{"encounter_id":1,"npc_template_id":9,"grouped":true}`)
+	authed(req, "dm1")
+	apiInitiativeGroupHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusNotFound)
+	assertMet(t, m)
+}
+
+func TestStartCombatRejectsDuplicateSurprised(t *testing.T) {
+	rr, req := postJSON("/encounters/combat/start", `{"encounter_id":1,"surprised":[5,5]}`)
+	authed(req, "dm1")
+	apiStartCombatHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestAddInitiativeEventDefaultsToCount20(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("INSERT INTO encounter_initiative_events").WithArgs(1, "Lair action", 20, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(0, 0, false))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/initiative-events/add", `{"encounter_id":1,"name":" Lair action "}`)
+	authed(req, "dm1")
+	apiAddInitiativeEventHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	var body struct {
+		Event dao.InitiativeEvent `json:"event"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Event.ID != 3 || body.Event.Initiative != 20 || body.Event.Kind != dao.KindEvent {
+		t.Errorf("event = %+v, want id 3 on count 20", body.Event)
+	}
+	assertMet(t, m)
+}
+
+func TestAddInitiativeEventRequiresName(t *testing.T) {
+	rr, req := postJSON("/encounters/initiative-events/add", `{"encounter_id":1,"name":"  "}`)
+	authed(req, "dm1")
+	apiAddInitiativeEventHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestRemoveInitiativeEventNotFound(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectExec("DELETE FROM encounter_initiative_events").WithArgs(1, 9).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/initiative-events/remove", `{"encounter_id":1,"event_id":9}`)
+	authed(req, "dm1")
+	apiRemoveInitiativeEventHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusNotFound)
 	assertMet(t, m)
 }
 
@@ -383,8 +738,18 @@ func TestResetCombatAllowsOwner(t *testing.T) {
 	defer restore()
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT round, turn_index, combat_started FROM encounters").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"round", "turn_index", "combat_started"}).AddRow(1, 0, true))
 	m.ExpectExec("UPDATE encounter_characters SET is_active = FALSE").WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(0, 4))
+	m.ExpectExec("UPDATE encounters SET round").WithArgs(0, 0, false, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectExec("SET held = NULL").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	m.ExpectExec("DELETE FROM encounter_turn_history").WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	m.ExpectCommit()
 
 	rr, req := postJSON("/encounters/combat/setup", `{"encounter_id":1}`)
 	authed(req, "dm1")
@@ -396,13 +761,85 @@ func TestResetCombatAllowsOwner(t *testing.T) {
 
 // --- Encounters CRUD --------------------------------------------------------
 
+func TestEncounterSettingsUpdatesSkipPolicy(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectExec("UPDATE encounters SET skip_policy").WithArgs("defeated_npcs", nil, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr, req := postJSON("/encounters/settings", `{"encounter_id":1,"skip_policy":"defeated_npcs"}`)
+	authed(req, "dm1")
+	apiEncounterSettingsHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestEncounterSettingsUpdatesRuleset(t *testing.T) {
+	m, restore := newEncounterMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectExec("UPDATE encounters SET skip_policy").WithArgs(nil, "2024", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr, req := postJSON("/encounters/settings", `{"encounter_id":1,"ruleset":"2024"}`)
+	authed(req, "dm1")
+	apiEncounterSettingsHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestEncounterSettingsRejectsUnknownRuleset(t *testing.T) {
+	rr, req := postJSON("/encounters/settings", `{"encounter_id":1,"ruleset":"5.5"}`)
+	authed(req, "dm1")
+	apiEncounterSettingsHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSaveEncounterDefaultsToOwnersRuleset(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("SELECT ruleset FROM users").WithArgs("dm1").
+		WillReturnRows(sqlmock.NewRows([]string{"ruleset"}).AddRow("2024"))
+	m.ExpectQuery("INSERT INTO encounters").WithArgs("Goblins", "dm1", "", "2024").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+
+	rr, req := postJSON("/encounters/save", `{"Name":"Goblins"}`)
+	authed(req, "dm1")
+	apiSaveEncounterHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+}
+
+func TestSavePreferencesRejectsUnknownRuleset(t *testing.T) {
+	rr, req := postJSON("/me/preferences/save", `{"ruleset":"4e"}`)
+	authed(req, "dm1")
+	apiSavePreferencesHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestEncounterSettingsRejectsUnknownSkipPolicy(t *testing.T) {
+	rr, req := postJSON("/encounters/settings", `{"encounter_id":1,"skip_policy":"everyone"}`)
+	authed(req, "dm1")
+	apiEncounterSettingsHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
 func TestEncountersListLoggedOutReturnsOnlyUnowned(t *testing.T) {
 	m, restore := newFullMock(t)
 	defer restore()
 	// Logged-out visitors only see owner-less encounters.
 	m.ExpectQuery("owner_id IS NULL OR owner_id = ''").WillReturnRows(
-		sqlmock.NewRows([]string{"id", "name", "owner_id", "description"}).
-			AddRow(1, "Goblins", "", "ambush"),
+		sqlmock.NewRows([]string{"id", "name", "owner_id", "description", "round", "turn_index", "combat_started", "skip_policy", "ruleset"}).
+			AddRow(1, "Goblins", "", "ambush", 2, 1, true, "none", "2014"),
 	)
 
 	rr, req := getReq("/encounters")
@@ -415,7 +852,7 @@ func TestEncountersListLoggedOutReturnsOnlyUnowned(t *testing.T) {
 func TestSaveEncounterCreates(t *testing.T) {
 	m, restore := newFullMock(t)
 	defer restore()
-	m.ExpectQuery("INSERT INTO encounters").WithArgs("Goblins", "", "").
+	m.ExpectQuery("INSERT INTO encounters").WithArgs("Goblins", "", "", "2014").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
 
 	rr, req := postJSON("/encounters/save", `{"Name":"Goblins"}`)
@@ -462,6 +899,7 @@ func TestCharactersListLoggedOut(t *testing.T) {
 		sqlmock.NewRows([]string{
 			"id", "name", "armor_class", "to_hit_modifier", "max_hp",
 			"current_hp", "initiative", "is_active", "owner_id", "type", "npc_template_id",
+			"damage_resistances", "damage_vulnerabilities", "damage_immunities", "resources",
 		}),
 	)
 
@@ -513,6 +951,8 @@ func TestAddCharacterDuplicateConflict(t *testing.T) {
 func TestRemoveCharacterAllowsOwner(t *testing.T) {
 	m, restore := newFullMock(t)
 	defer restore()
+	ch := events.subscribe(1)
+	defer events.unsubscribe(1, ch)
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
 	m.ExpectExec("DELETE FROM encounter_characters").WithArgs(1, 5).
@@ -524,6 +964,9 @@ func TestRemoveCharacterAllowsOwner(t *testing.T) {
 
 	assertStatus(t, rr, http.StatusOK)
 	assertMet(t, m)
+	if n := len(ch); n != 2 {
+		t.Errorf("published %d events, want character and effects", n)
+	}
 }
 
 func TestSaveLibraryCharacterCreates(t *testing.T) {
@@ -581,6 +1024,7 @@ func TestLibraryCharactersLoggedInScopesToOwner(t *testing.T) {
 		sqlmock.NewRows([]string{
 			"id", "name", "armor_class", "to_hit_modifier", "max_hp",
 			"current_hp", "initiative", "is_active", "owner_id", "type",
+			"damage_resistances", "damage_vulnerabilities", "damage_immunities", "resources",
 		}),
 	)
 
@@ -602,7 +1046,7 @@ func TestEncounterLedgerReadAllowsOwner(t *testing.T) {
 	m.ExpectQuery("FROM encounter_ledger").WithArgs(1, 50).WillReturnRows(
 		sqlmock.NewRows([]string{
 			"id", "encounter_id", "actor_id", "actor_name", "target_id",
-			"target_name", "action_type", "hp_change", "description", "created_at",
+			"target_name", "action_type", "hp_change", "description", "details", "created_at",
 		}),
 	)
 
@@ -622,8 +1066,8 @@ func TestAddLedgerEntryAllowsOwner(t *testing.T) {
 	m.ExpectQuery("INSERT INTO encounter_ledger").WillReturnRows(
 		sqlmock.NewRows([]string{
 			"id", "encounter_id", "actor_id", "actor_name", "target_id",
-			"target_name", "action_type", "hp_change", "description", "created_at",
-		}).AddRow(1, 1, 5, "Hero", 0, "", "attack", -3, "", "2026-07-18T00:00:00Z"),
+			"target_name", "action_type", "hp_change", "description", "details", "created_at",
+		}).AddRow(1, 1, 5, "Hero", 0, "", "attack", -3, "", nil, "2026-07-18T00:00:00Z"),
 	)
 
 	rr, req := postJSON("/encounters/ledger/add", `{"encounter_id":1,"actor_id":5,"action_type":"attack","hp_change":-3}`)
@@ -640,13 +1084,425 @@ func TestAddLedgerEntryRejectsBadEncounterID(t *testing.T) {
 	assertStatus(t, rr, http.StatusBadRequest)
 }
 
+// --- HP -------------------------------------------------------------------
+
+func TestApplyHPDamageClampsLogsAndPublishes(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	ch := events.subscribe(1)
+	defer events.unsubscribe(1, ch)
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT c.name, c.armor_class, c.max_hp").WithArgs(1, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "armor_class", "max_hp", "current_hp", "temp_hp", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "concentration", "concentration_condition_ids", "is_pc", "death_save_successes", "death_save_failures", "stable", "dead"}).
+			AddRow("Goblin", 15, 7, 5, 0, "{}", "{}", "{}", nil, "{}", false, 0, 0, false, false))
+	m.ExpectExec("UPDATE encounter_characters SET current_hp").WithArgs(0, 0, 0, 0, false, false, 1, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(1, 2, 4, dao.HPDamage, -5, "Goblin takes 8 damage (5 → 0)", `{"raw_damage":8,"final_damage":8}`).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "encounter_id", "actor_id", "actor_name", "target_id",
+			"target_name", "action_type", "hp_change", "description", "details", "created_at",
+		}).AddRow(1, 1, 2, "Hero", 4, "Goblin", "damage", -5, "Goblin takes 8 damage (5 → 0)", nil, "2026-07-18T00:00:00Z"))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/hp/apply", `{"encounter_id":1,"character_id":4,"actor_id":2,"kind":"damage","amount":8}`)
+	authed(req, "dm1")
+	apiApplyHPHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+	select {
+	case msg := <-ch:
+		var event struct {
+			Type   string `json:"type"`
+			Before int    `json:"before"`
+			After  int    `json:"after"`
+		}
+		if err := json.Unmarshal([]byte(msg), &event); err != nil {
+			t.Fatalf("decode event %q: %v", msg, err)
+		}
+		if event.Type != "hp" || event.Before != 5 || event.After != 0 {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	default:
+		t.Fatal("no event published")
+	}
+}
+
+func TestApplyHPRejectsDamageTypeOnHealing(t *testing.T) {
+	rr, req := postJSON("/encounters/hp/apply", `{"encounter_id":1,"character_id":4,"kind":"healing","amount":3,"damage_type":"Fire"}`)
+	authed(req, "dm1")
+	apiApplyHPHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSaveNpcTemplateRejectsUnknownDamageType(t *testing.T) {
+	rr, req := postJSON("/npcs/templates/save", `{"Name":"Imp","Resistances":["Hellfire"]}`)
+	authed(req, "u1")
+	apiSaveNpcTemplateHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSaveNpcTemplateRejectsOversizedLegendaryPools(t *testing.T) {
+	rr, req := postJSON("/npcs/templates/save", `{"Name":"Tarrasque","LegendaryActions":11}`)
+	authed(req, "u1")
+	apiSaveNpcTemplateHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestSaveNpcTemplateRejectsUnknownRecharge(t *testing.T) {
+	rr, req := postJSON("/npcs/templates/save", `{"Name":"Young Red Dragon","Resources":[{"Name":"Fire Breath","Max":1,"Recharge":"dawn"}]}`)
+	authed(req, "u1")
+	apiSaveNpcTemplateHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestApplyHPRejectsUnknownKind(t *testing.T) {
+	rr, req := postJSON("/encounters/hp/apply", `{"encounter_id":1,"character_id":4,"kind":"poke","amount":3}`)
+	authed(req, "dm1")
+	apiApplyHPHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestAttackHitAppliesDamageAndPublishesOnce(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	defer stubDice(t, 12, 6)()
+	ch := events.subscribe(1)
+	defer events.unsubscribe(1, ch)
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT c.name, c.to_hit_modifier").WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "to_hit_modifier"}).AddRow("Hero", 4))
+	m.ExpectQuery("SELECT c.name, c.armor_class, c.max_hp").WithArgs(1, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "armor_class", "max_hp", "current_hp", "temp_hp", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "concentration", "concentration_condition_ids", "is_pc", "death_save_successes", "death_save_failures", "stable", "dead"}).
+			AddRow("Goblin", 15, 7, 7, 0, "{}", "{}", "{}", nil, "{}", false, 0, 0, false, false))
+	m.ExpectExec("UPDATE encounter_characters SET current_hp").WithArgs(0, 0, 0, 0, false, false, 1, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("INSERT INTO encounter_ledger").
+		WithArgs(1, 2, 4, dao.LedgerAttack, -7, "Hero hits Goblin (16 vs AC 15): Goblin takes 8 slashing damage (7 → 0)", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "encounter_id", "actor_id", "actor_name", "target_id",
+			"target_name", "action_type", "hp_change", "description", "details", "created_at",
+		}).AddRow(1, 1, 2, "Hero", 4, "Goblin", "attack", -7, "Hero hits Goblin (16 vs AC 15): Goblin takes 8 slashing damage (7 → 0)", nil, "2026-07-18T00:00:00Z"))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/attack", `{"encounter_id":1,"attacker_id":2,"target_ids":[4],"damage":"1d6+2","damage_type":"Slashing"}`)
+	authed(req, "dm1")
+	apiAttackHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+	if n := len(ch); n != 1 {
+		t.Fatalf("published %d events, want 1", n)
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(<-ch), &event); err != nil || event.Type != "attack" {
+		t.Errorf("unexpected event %+v (%v)", event, err)
+	}
+}
+
+func TestGroupSaveRollsEachTargetAndPublishesOnce(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	defer stubDice(t, 14, 14)()
+	ch := events.subscribe(1)
+	defer events.unsubscribe(1, ch)
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	for _, id := range []int{4, 5} {
+		m.ExpectQuery("SELECT c.name, c.armor_class, c.max_hp").WithArgs(1, id).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "armor_class", "max_hp", "current_hp", "temp_hp", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "concentration", "concentration_condition_ids", "is_pc", "death_save_successes", "death_save_failures", "stable", "dead"}).
+				AddRow("Goblin", 15, 7, 7, 0, "{}", "{}", "{}", nil, "{}", false, 0, 0, false, false))
+		m.ExpectQuery(`\(c.stats\).wisdom`).WithArgs(1, id).
+			WillReturnRows(sqlmock.NewRows([]string{"modifier", "conditions"}).AddRow(-1, "{}"))
+		m.ExpectQuery("INSERT INTO encounter_ledger").
+			WithArgs(1, 0, id, dao.LedgerSave, 0, "Goblin succeeds on a DC 12 Wisdom save (13)", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{
+				"id", "encounter_id", "actor_id", "actor_name", "target_id",
+				"target_name", "action_type", "hp_change", "description", "details", "created_at",
+			}).AddRow(id, 1, 0, "", id, "Goblin", "save", 0, "Goblin succeeds on a DC 12 Wisdom save (13)", nil, "2026-07-18T00:00:00Z"))
+	}
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/saves", `{"encounter_id":1,"target_ids":[5,4],"ability":"WIS","dc":12}`)
+	authed(req, "dm1")
+	apiGroupSaveHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+	if n := len(ch); n != 1 {
+		t.Errorf("published %d events, want 1", n)
+	}
+}
+
+func TestGroupSaveRejectsUnknownAbility(t *testing.T) {
+	rr, req := postJSON("/encounters/saves", `{"encounter_id":1,"target_ids":[4],"ability":"luck","dc":12}`)
+	authed(req, "dm1")
+	apiGroupSaveHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestEndConcentrationWhenNotConcentratingConflicts(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT c.name, c.armor_class, c.max_hp").WithArgs(1, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "armor_class", "max_hp", "current_hp", "temp_hp", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "concentration", "concentration_condition_ids", "is_pc", "death_save_successes", "death_save_failures", "stable", "dead"}).
+			AddRow("Fighter", 18, 30, 30, 0, "{}", "{}", "{}", nil, "{}", false, 0, 0, false, false))
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/concentration/end", `{"encounter_id":1,"character_id":4}`)
+	authed(req, "dm1")
+	apiEndConcentrationHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusConflict)
+	assertMet(t, m)
+}
+
+func TestSetConcentrationReportsTheSpell(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT c.name, c.armor_class, c.max_hp").WithArgs(1, 4).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "armor_class", "max_hp", "current_hp", "temp_hp", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "concentration", "concentration_condition_ids", "is_pc", "death_save_successes", "death_save_failures", "stable", "dead"}).
+			AddRow("Cleric", 18, 30, 30, 0, "{}", "{}", "{}", nil, "{}", false, 0, 0, false, false))
+	m.ExpectQuery("SELECT COUNT").WithArgs(1, "{11}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	m.ExpectExec("SET concentration = ").WithArgs("Bless", "{11}", 1, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	m.ExpectQuery("INSERT INTO encounter_ledger").
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "encounter_id", "actor_id", "actor_name", "target_id",
+			"target_name", "action_type", "hp_change", "description", "details", "created_at",
+		}).AddRow(1, 1, 4, "Cleric", 0, "", "concentration", 0, "Cleric starts concentrating on Bless", nil, "2026-07-18T00:00:00Z"))
+	m.ExpectCommit()
+
+	rr, req := postJSON("/encounters/concentration/set", `{"encounter_id":1,"character_id":4,"spell":"Bless","condition_ids":[11]}`)
+	authed(req, "dm1")
+	apiSetConcentrationHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusOK)
+	assertMet(t, m)
+	// Decoded as raw maps: struct tags would match the keys case-insensitively.
+	var resp struct {
+		Concentration struct {
+			Concentration map[string]any `json:"concentration"`
+		} `json:"concentration"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	got := resp.Concentration.Concentration
+	if ids, _ := got["condition_ids"].([]any); got["spell"] != "Bless" || len(ids) != 1 || ids[0] != float64(11) {
+		t.Errorf("concentration = %v, want spell Bless with condition_ids [11]", got)
+	}
+}
+
+func TestSetConcentrationRequiresSpell(t *testing.T) {
+	rr, req := postJSON("/encounters/concentration/set", `{"encounter_id":1,"character_id":4,"spell":"  "}`)
+	authed(req, "dm1")
+	apiSetConcentrationHandler(rr, req)
+	assertStatus(t, rr, http.StatusBadRequest)
+}
+
+func TestAttackRejectsInvalidRequests(t *testing.T) {
+	for _, body := range []string{
+		`{"encounter_id":1,"attacker_id":2,"target_ids":[],"damage":"1d6"}`,
+		`{"encounter_id":1,"attacker_id":2,"target_ids":[4,4],"damage":"1d6"}`,
+		`{"encounter_id":1,"attacker_id":2,"target_ids":[4],"damage":"1d6","advantage":true,"disadvantage":true}`,
+		`{"encounter_id":1,"attacker_id":2,"target_ids":[4],"damage":"fireball"}`,
+		`{"encounter_id":1,"attacker_id":2,"target_ids":[4],"damage":"1d6","damage_type":"Hellfire"}`,
+	} {
+		rr, req := postJSON("/encounters/attack", body)
+		authed(req, "dm1")
+		apiAttackHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestDeathSaveRejectsInvalidRolls(t *testing.T) {
+	for _, body := range []string{
+		`{"encounter_id":1,"character_id":0}`,
+		`{"encounter_id":1,"character_id":2,"natural":21}`,
+		`{"encounter_id":1,"character_id":2,"natural":-1}`,
+	} {
+		rr, req := postJSON("/encounters/death-save", body)
+		authed(req, "dm1")
+		apiDeathSaveHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLegendaryRejectsInvalidRequests(t *testing.T) {
+	for _, body := range []string{
+		`{"encounter_id":1,"character_id":0,"pool":"action"}`,
+		`{"encounter_id":1,"character_id":2,"pool":"lair"}`,
+		`{"encounter_id":1,"character_id":2,"pool":"action","amount":-1}`,
+		`{"encounter_id":1,"character_id":2,"pool":"resistance","amount":11}`,
+	} {
+		rr, req := postJSON("/encounters/legendary/spend", body)
+		authed(req, "dm1")
+		apiSpendLegendaryHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSpendLegendaryNotEnoughLeftConflicts(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("SELECT c.name, ec.legendary_actions").WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "legendary_actions", "legendary_actions_max", "legendary_resistances", "legendary_resistances_max"}).
+			AddRow("Lich", 0, 3, 3, 3))
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/legendary/spend", `{"encounter_id":1,"character_id":2,"pool":"action"}`)
+	authed(req, "dm1")
+	apiSpendLegendaryHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusConflict)
+	assertMet(t, m)
+}
+
+func TestResourcesRejectInvalidRequests(t *testing.T) {
+	cases := []struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		{apiSpendResourceHandler, `{"encounter_id":1,"resource_id":0}`},
+		{apiSpendResourceHandler, `{"encounter_id":1,"resource_id":3,"amount":-1}`},
+		{apiSpendResourceHandler, `{"encounter_id":1,"resource_id":3,"amount":100}`},
+		// A rest only restores; a spend still needs a resource.
+		{apiSpendResourceHandler, `{"encounter_id":1,"rest":"short_rest"}`},
+		{apiRestoreResourceHandler, `{"encounter_id":1,"character_id":-1,"rest":"long_rest"}`},
+	}
+	for _, c := range cases {
+		rr, req := postJSON("/encounters/resources", c.body)
+		authed(req, "dm1")
+		c.handler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", c.body, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSpendResourceNotEnoughLeftConflicts(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectBegin()
+	m.ExpectQuery("FROM encounter_resources er").WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "character_id", "name", "current_uses", "max_uses", "recharge", "recharge_on"}).
+			AddRow("Monk", 2, "Ki", 0, 4, "short_rest", 0))
+	m.ExpectRollback()
+
+	rr, req := postJSON("/encounters/resources/spend", `{"encounter_id":1,"resource_id":3}`)
+	authed(req, "dm1")
+	apiSpendResourceHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusConflict)
+	assertMet(t, m)
+}
+
+func TestRestoreResourceUnknownRestIsBadRequest(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+
+	rr, req := postJSON("/encounters/resources/restore", `{"encounter_id":1,"rest":"nap"}`)
+	authed(req, "dm1")
+	apiRestoreResourceHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusBadRequest)
+	assertMet(t, m)
+}
+
+func TestEncounterEffectsRejectInvalidRequests(t *testing.T) {
+	cases := []struct {
+		handler http.HandlerFunc
+		body    string
+	}{
+		{apiAddEncounterEffectHandler, `{"encounter_id":1,"name":"  "}`},
+		{apiAddEncounterEffectHandler, `{"encounter_id":1,"name":"Wall of Fire","owner_id":2,"duration_rounds":0}`},
+		{apiAddEncounterEffectHandler, `{"encounter_id":1,"name":"Wall of Fire","owner_id":2,"duration_rounds":10,"tick_at":"midnight"}`},
+		{apiAddEncounterEffectHandler, `{"encounter_id":1,"name":"Wall of Fire","owner_id":-1}`},
+		{apiRemoveEncounterEffectHandler, `{"encounter_id":1,"effect_id":0}`},
+	}
+	for _, c := range cases {
+		rr, req := postJSON("/encounters/effects", c.body)
+		authed(req, "dm1")
+		c.handler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", c.body, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAddEncounterEffectOutsideEncounterNotFound(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectQuery("SELECT COUNT\\(\\*\\) FROM encounter_characters").WithArgs(1, "{9}").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	rr, req := postJSON("/encounters/effects/add", `{"encounter_id":1,"name":"Cloudkill","owner_id":9,"duration_rounds":10}`)
+	authed(req, "dm1")
+	apiAddEncounterEffectHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusNotFound)
+	assertMet(t, m)
+}
+
+func TestRemoveEncounterEffectMissingNotFound(t *testing.T) {
+	m, restore := newFullMock(t)
+	defer restore()
+	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
+		WillReturnRows(encounterRow(1, "dm1"))
+	m.ExpectExec("DELETE FROM encounter_effects").WithArgs(1, 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rr, req := postJSON("/encounters/effects/remove", `{"encounter_id":1,"effect_id":4}`)
+	authed(req, "dm1")
+	apiRemoveEncounterEffectHandler(rr, req)
+
+	assertStatus(t, rr, http.StatusNotFound)
+	assertMet(t, m)
+}
+
 // --- NPC templates ----------------------------------------------------------
 
 func TestNpcTemplatesList(t *testing.T) {
 	m, restore := newFullMock(t)
 	defer restore()
 	m.ExpectQuery("FROM npc_templates").WillReturnRows(
-		sqlmock.NewRows([]string{"id", "name", "description", "base_stats", "armor_class", "max_hp", "owner_id"}),
+		sqlmock.NewRows([]string{"id", "name", "description", "base_stats", "armor_class", "max_hp", "owner_id", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "legendary_actions", "legendary_resistances", "resources"}),
 	)
 
 	rr, req := getReq("/npcs/templates")
@@ -706,15 +1562,19 @@ func TestCreateCharacterFromTemplateAllowsOwner(t *testing.T) {
 		WillReturnRows(encounterRow(1, "dm1"))
 	// Template lookup (valid composite stat_block so parsing succeeds).
 	m.ExpectQuery("FROM npc_templates WHERE id").WithArgs(2).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "base_stats", "armor_class", "max_hp", "owner_id"}).
-			AddRow(2, "Goblin", "", "(8,14,10,10,8,8)", 13, 7, "dm1"))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "base_stats", "armor_class", "max_hp", "owner_id", "damage_resistances", "damage_vulnerabilities", "damage_immunities", "legendary_actions", "legendary_resistances", "resources"}).
+			AddRow(2, "Goblin", "", "(8,14,10,10,8,8)", 13, 7, "dm1", "{Fire}", "{}", "{Poison}", 0, 0, "[]"))
 	// Owner is resolved from the encounter again inside the template flow.
 	m.ExpectQuery("FROM encounters WHERE id").WithArgs(1).
 		WillReturnRows(encounterRow(1, "dm1"))
+	// The spawned NPC gets its own copy of the template's damage defenses.
 	m.ExpectQuery("INSERT INTO characters").
+		WithArgs("Goblin", 13, 0, 7, "dm1", "npc", `{"Fire"}`, "{}", `{"Poison"}`, "[]").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(50))
 	m.ExpectExec("INSERT INTO encounter_characters").WithArgs(1, 50).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	m.ExpectExec("UPDATE encounter_characters ec SET grouped = TRUE").WithArgs(1, 50).
+		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	rr, req := postJSON("/npcs/templates/create-character", `{"npc_template_id":2,"encounter_id":1}`)
 	authed(req, "dm1")
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"go-initiative-tracker/dao"
+	"go-initiative-tracker/dice"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// apiDamageTypeCatalogHandler returns the damage types a hit, resistance,
+// vulnerability or immunity may name, so the frontend can populate its pickers
+// without hardcoding the list.
+func apiDamageTypeCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dao.DamageTypeCatalog())
+}
+
+// apiApplyHPHandler applies damage, healing or temporary hit points to a
+// combatant (kind "damage", "healing" or "temp_hp") and logs it in one
+// transaction, replacing the separate save-character and ledger/add calls that
+// could half succeed. It publishes a single "hp" event carrying the before and
+// after values. Damage may name a damage_type, which the target's resistances,
+// vulnerabilities and immunities then adjust. Damage to a concentrating target
+// logs the Constitution save it must make, rolled here if roll_concentration
+// is set.
+func apiApplyHPHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int    `json:"encounter_id"`
+		CharacterID int    `json:"character_id"`
+		ActorID     int    `json:"actor_id"`
+		Kind        string `json:"kind"`
+		Amount      int    `json:"amount"`
+		DamageType  string `json:"damage_type"`
+		Note        string `json:"note"`
+		// RollConcentration has the server roll the Constitution save damage
+		// forces on a concentrating target instead of leaving it to the DM.
+		RollConcentration bool `json:"roll_concentration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 || req.ActorID < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	kind := strings.ToLower(strings.TrimSpace(req.Kind))
+	if !slices.Contains(dao.ValidHPKinds, kind) {
+		writeJSONError(w, http.StatusBadRequest, "Kind must be damage, healing or temp_hp")
+		return
+	}
+	if req.Amount <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Amount must be greater than 0")
+		return
+	}
+	req.DamageType = strings.TrimSpace(req.DamageType)
+	if req.DamageType != "" && (kind != dao.HPDamage || !dao.IsValidDamageType(req.DamageType)) {
+		writeJSONError(w, http.StatusBadRequest, "Unknown damage type, or a damage type on a non-damage change")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	result, err := encounterCharacterDAO.ApplyHP(dao.HPChange{
+		EncounterID:      req.EncounterID,
+		CharacterID:      req.CharacterID,
+		ActorID:          req.ActorID,
+		Kind:             kind,
+		Amount:           req.Amount,
+		DamageType:       req.DamageType,
+		Note:             req.Note,
+		ConcentrationD20: concentrationRoller(req.RollConcentration),
+	})
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+		case strings.Contains(msg, "is dead"):
+			writeJSONError(w, http.StatusConflict, "Character is dead")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to apply HP change")
+		}
+		return
+	}
+
+	events.publishJSON(req.EncounterID, map[string]any{
+		"type":         "hp",
+		"character_id": result.CharacterID,
+		"before":       result.Before,
+		"after":        result.After,
+		"temp_before":  result.TempBefore,
+		"temp_after":   result.TempAfter,
+	})
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "hp": result})
+}
+
+// apiAttackHandler resolves an attack from one combatant against one or more
+// targets: a d20 (with advantage or disadvantage if asked) plus the attacker's
+// to-hit modifier against each target's armor class, with the damage
+// expression rolled and applied on a hit (dice doubled on a natural 20). Every
+// attack is logged to the ledger, and a single "attack" event is published
+// once they have all landed.
+func apiAttackHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID       int    `json:"encounter_id"`
+		AttackerID        int    `json:"attacker_id"`
+		TargetIDs         []int  `json:"target_ids"`
+		Advantage         bool   `json:"advantage"`
+		Disadvantage      bool   `json:"disadvantage"`
+		Damage            string `json:"damage"`
+		DamageType        string `json:"damage_type"`
+		RollConcentration bool   `json:"roll_concentration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.AttackerID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or attacker id")
+		return
+	}
+	if len(req.TargetIDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "At least one target is required")
+		return
+	}
+	if !uniquePositiveIDs(req.TargetIDs) {
+		writeJSONError(w, http.StatusBadRequest, "Target ids must be positive and unique")
+		return
+	}
+	if req.Advantage && req.Disadvantage {
+		writeJSONError(w, http.StatusBadRequest, "An attack cannot have both advantage and disadvantage")
+		return
+	}
+	damage, err := dice.Parse(req.Damage)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid damage expression: "+err.Error())
+		return
+	}
+	req.DamageType = strings.TrimSpace(req.DamageType)
+	if req.DamageType != "" && !dao.IsValidDamageType(req.DamageType) {
+		writeJSONError(w, http.StatusBadRequest, "Unknown damage type")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	mode := dao.RollNormal
+	if req.Advantage {
+		mode = dao.RollAdvantage
+	} else if req.Disadvantage {
+		mode = dao.RollDisadvantage
+	}
+	results, err := encounterCharacterDAO.Attack(dao.Attack{
+		EncounterID:      req.EncounterID,
+		AttackerID:       req.AttackerID,
+		TargetIDs:        req.TargetIDs,
+		Mode:             mode,
+		Damage:           damage,
+		DamageType:       req.DamageType,
+		RollD20:          rollD20,
+		RollDamage:       rollDie,
+		ConcentrationD20: concentrationRoller(req.RollConcentration),
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not in encounter") {
+			writeJSONError(w, http.StatusNotFound, "Attacker or target not in encounter")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to resolve attack")
+		return
+	}
+
+	events.publishJSON(req.EncounterID, map[string]any{
+		"type":        "attack",
+		"attacker_id": req.AttackerID,
+		"target_ids":  req.TargetIDs,
+	})
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "attacks": results})
+}
+
+// apiGroupSaveHandler rolls a saving throw for every target against a DC, the
+// way a Fireball makes everyone in the blast roll Dexterity. Modifiers come
+// from each target's ability scores and conditions that auto-fail the save
+// are honored. An optional damage expression is rolled once and dealt in full
+// on a failure, or halved on a success when half_on_success is set; it is
+// only applied to HP when apply is set. Every save is logged to the ledger.
+func apiGroupSaveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID       int    `json:"encounter_id"`
+		ActorID           int    `json:"actor_id"`
+		TargetIDs         []int  `json:"target_ids"`
+		Ability           string `json:"ability"`
+		DC                int    `json:"dc"`
+		Label             string `json:"label"`
+		Damage            string `json:"damage"`
+		DamageType        string `json:"damage_type"`
+		HalfOnSuccess     bool   `json:"half_on_success"`
+		Apply             bool   `json:"apply"`
+		Advantage         []int  `json:"advantage"`
+		Disadvantage      []int  `json:"disadvantage"`
+		RollConcentration bool   `json:"roll_concentration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.ActorID < 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or actor id")
+		return
+	}
+	if len(req.TargetIDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "At least one target is required")
+		return
+	}
+	if !uniquePositiveIDs(req.TargetIDs) {
+		writeJSONError(w, http.StatusBadRequest, "Target ids must be positive and unique")
+		return
+	}
+	ability, ok := dao.ParseAbility(req.Ability)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "Ability must be one of strength, dexterity, constitution, intelligence, wisdom or charisma")
+		return
+	}
+	if req.DC <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "DC must be greater than 0")
+		return
+	}
+	var damage *dice.Expression
+	if strings.TrimSpace(req.Damage) != "" {
+		expr, err := dice.Parse(req.Damage)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid damage expression: "+err.Error())
+			return
+		}
+		damage = &expr
+	}
+	req.DamageType = strings.TrimSpace(req.DamageType)
+	if req.DamageType != "" && (damage == nil || !dao.IsValidDamageType(req.DamageType)) {
+		writeJSONError(w, http.StatusBadRequest, "Unknown damage type, or a damage type without damage")
+		return
+	}
+	modes, ok := rollModes(req.Advantage, req.Disadvantage)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "A combatant cannot have both advantage and disadvantage")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	result, err := encounterCharacterDAO.RollSaves(dao.SavingThrow{
+		EncounterID:      req.EncounterID,
+		ActorID:          req.ActorID,
+		TargetIDs:        req.TargetIDs,
+		Ability:          ability,
+		DC:               req.DC,
+		Label:            req.Label,
+		Damage:           damage,
+		DamageType:       req.DamageType,
+		HalfOnSuccess:    req.HalfOnSuccess,
+		Apply:            req.Apply,
+		Modes:            modes,
+		RollD20:          rollD20,
+		RollDamage:       rollDie,
+		ConcentrationD20: concentrationRoller(req.RollConcentration),
+	})
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "not in encounter") {
+			writeJSONError(w, http.StatusNotFound, "Target not in encounter")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "Failed to roll saving throws")
+		return
+	}
+
+	events.publishJSON(req.EncounterID, map[string]any{
+		"type":       "saves",
+		"target_ids": req.TargetIDs,
+	})
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "damage_roll": result.DamageRoll, "saves": result.Saves})
+}
+
+// apiDeathSaveHandler records a death saving throw for a player character at
+// 0 HP, made at the start of its turn. natural is the d20 the player rolled;
+// omit it to have the server roll. A natural 20 brings the character back with
+// 1 HP and a natural 1 counts as two failures.
+func apiDeathSaveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int `json:"encounter_id"`
+		CharacterID int `json:"character_id"`
+		Natural     int `json:"natural"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	if req.Natural < 0 || req.Natural > 20 {
+		writeJSONError(w, http.StatusBadRequest, "Natural roll must be between 1 and 20, or 0 to roll it")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	result, entry, err := encounterCharacterDAO.RollDeathSave(req.EncounterID, req.CharacterID, req.Natural, rollD20)
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+		case strings.Contains(msg, "only player characters"):
+			writeJSONError(w, http.StatusBadRequest, "Only player characters make death saves")
+		case strings.Contains(msg, "dead"), strings.Contains(msg, "not at 0 hp"), strings.Contains(msg, "stable"):
+			writeJSONError(w, http.StatusConflict, "Character is not making death saves")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to record death save")
+		}
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "death_save": result, "entry": entry})
+}
+
+// uniquePositiveIDs reports whether ids are all valid ids with no repeats.
+func uniquePositiveIDs(ids []int) bool {
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if id <= 0 || seen[id] {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// concentrationRoller returns the die for concentration saves when the client
+// asked the server to roll them, nil to leave them to the DM.
+func concentrationRoller(roll bool) func() int {
+	if !roll {
+		return nil
+	}
+	return rollD20
+}
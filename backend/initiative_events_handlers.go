@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"go-initiative-tracker/dao"
+	"net/http"
+	"strings"
+)
+
+// defaultEventInitiative is the count lair actions act on in 5e.
+const defaultEventInitiative = 20
+
+// apiAddInitiativeEventHandler adds an initiative event (a lair action or other
+// entry with an initiative count but no HP or AC) to an encounter's turn order.
+// The initiative count defaults to 20. Like adding a character it changes the
+// roster, so it publishes a "character" event.
+func apiAddInitiativeEventHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int    `json:"encounter_id"`
+		Name        string `json:"name"`
+		Initiative  *int   `json:"initiative"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter id")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+	initiative := defaultEventInitiative
+	if req.Initiative != nil {
+		initiative = *req.Initiative
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	event, err := initiativeEventDAO.Create(dao.InitiativeEvent{
+		EncounterID: req.EncounterID,
+		Name:        req.Name,
+		Initiative:  initiative,
+		Description: strings.TrimSpace(req.Description),
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to add initiative event")
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "event": event})
+}
+
+// apiRemoveInitiativeEventHandler removes an initiative event by id, scoped to
+// its encounter so a stale id cannot touch another fight.
+func apiRemoveInitiativeEventHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req struct {
+		EncounterID int `json:"encounter_id"`
+		EventID     int `json:"event_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.EventID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or event id")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	removed, err := initiativeEventDAO.Delete(req.EncounterID, req.EventID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to remove initiative event")
+		return
+	}
+	if !removed {
+		writeJSONError(w, http.StatusNotFound, "Initiative event not found")
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
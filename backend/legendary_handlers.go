@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"go-initiative-tracker/dao"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// legendaryRequest is the body of the legendary spend and restore endpoints.
+// Pool is "action" or "resistance".
+type legendaryRequest struct {
+	EncounterID int    `json:"encounter_id"`
+	CharacterID int    `json:"character_id"`
+	Pool        string `json:"pool"`
+	Amount      int    `json:"amount"`
+	Note        string `json:"note"`
+}
+
+// apiSpendLegendaryHandler spends legendary actions or a legendary resistance
+// (one unless amount says otherwise) and logs it to the ledger.
+func apiSpendLegendaryHandler(w http.ResponseWriter, r *http.Request) {
+	handleLegendary(w, r, encounterCharacterDAO.SpendLegendary)
+}
+
+// apiRestoreLegendaryHandler gives back amount uses of a legendary pool, or
+// refills it when amount is omitted, e.g. after a long rest.
+func apiRestoreLegendaryHandler(w http.ResponseWriter, r *http.Request) {
+	handleLegendary(w, r, encounterCharacterDAO.RestoreLegendary)
+}
+
+func handleLegendary(w http.ResponseWriter, r *http.Request, change func(dao.LegendaryUse) (dao.LegendaryCounters, dao.EncounterLedgerEntry, error)) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req legendaryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.EncounterID <= 0 || req.CharacterID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or character id")
+		return
+	}
+	if !slices.Contains(dao.ValidLegendaryPools, req.Pool) {
+		writeJSONError(w, http.StatusBadRequest, "Pool must be action or resistance")
+		return
+	}
+	if req.Amount < 0 || req.Amount > dao.MaxLegendaryUses {
+		writeJSONError(w, http.StatusBadRequest, "Invalid amount")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	counters, entry, err := change(dao.LegendaryUse{
+		EncounterID: req.EncounterID,
+		CharacterID: req.CharacterID,
+		Pool:        req.Pool,
+		Amount:      req.Amount,
+		Note:        req.Note,
+	})
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Character not in encounter")
+		case strings.Contains(msg, "has no legendary"):
+			writeJSONError(w, http.StatusBadRequest, "Character has no such legendary pool")
+		case strings.Contains(msg, "not enough"):
+			writeJSONError(w, http.StatusConflict, "Not enough legendary uses left")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update legendary uses")
+		}
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "legendary": counters, "entry": entry})
+}
@@ -24,7 +24,10 @@ var characterDAO dao.CharacterDAO
 var npcTemplateDAO dao.NpcTemplateDAO
 var encounterCharacterDAO dao.EncounterCharacterDAO
 var encounterConditionDAO dao.EncounterConditionDAO
+var customConditionDAO dao.CustomConditionDAO
 var encounterLedgerDAO dao.EncounterLedgerDAO
+var initiativeEventDAO dao.InitiativeEventDAO
+var encounterEffectDAO dao.EncounterEffectDAO
 var encounterDAO dao.EncounterDAO
 var friendshipDAO dao.FriendshipDAO
 var userDAO dao.UserDAO
@@ -61,7 +64,10 @@ func initializeApp(db *sql.DB) {
 	encounterDAO = dao.NewEncounterDAO(db)
 	encounterCharacterDAO = dao.NewEncounterCharacterDAO(db)
 	encounterConditionDAO = dao.NewEncounterConditionDAO(db)
+	customConditionDAO = dao.NewCustomConditionDAO(db)
 	encounterLedgerDAO = dao.NewEncounterLedgerDAO(db)
+	initiativeEventDAO = dao.NewInitiativeEventDAO(db)
+	encounterEffectDAO = dao.NewEncounterEffectDAO(db)
 	npcTemplateDAO = dao.NewNpcTemplateDAO(db)
 	friendshipDAO = dao.NewFriendshipDAO(db)
 	userDAO = dao.NewUserDAO(db)
@@ -177,22 +183,52 @@ func main() {
 	http.Handle("/encounters", loggingMiddleware(http.HandlerFunc(apiEncountersHandler)))
 	http.Handle("/encounters/save", loggingMiddleware(http.HandlerFunc(apiSaveEncounterHandler)))
 	http.Handle("/encounters/delete", loggingMiddleware(http.HandlerFunc(apiDeleteEncounterHandler)))
+	http.Handle("/encounters/settings", loggingMiddleware(http.HandlerFunc(apiEncounterSettingsHandler)))
 	http.Handle("/characters", loggingMiddleware(http.HandlerFunc(apiCharactersHandler)))
 	http.Handle("/characters/library", loggingMiddleware(http.HandlerFunc(apiLibraryCharactersHandler)))
 	http.Handle("/characters/library/save", loggingMiddleware(http.HandlerFunc(apiSaveLibraryCharacterHandler)))
 	http.Handle("/characters/library/delete", loggingMiddleware(http.HandlerFunc(apiDeleteLibraryCharacterHandler)))
 	http.Handle("/me", loggingMiddleware(http.HandlerFunc(apiMeHandler)))
+	http.Handle("/me/preferences", loggingMiddleware(http.HandlerFunc(apiPreferencesHandler)))
+	http.Handle("/me/preferences/save", loggingMiddleware(http.HandlerFunc(apiSavePreferencesHandler)))
 	http.Handle("/version", loggingMiddleware(http.HandlerFunc(apiVersionHandler)))
 	http.Handle("/encounters/combat/start", loggingMiddleware(http.HandlerFunc(apiStartCombatHandler)))
 	http.Handle("/encounters/combat/setup", loggingMiddleware(http.HandlerFunc(apiResetCombatHandler)))
 	http.Handle("/encounters/combat/next-turn", loggingMiddleware(http.HandlerFunc(apiNextTurnHandler)))
+	http.Handle("/encounters/combat/previous-turn", loggingMiddleware(http.HandlerFunc(apiPreviousTurnHandler)))
 	http.Handle("/encounters/combat/set-active", loggingMiddleware(http.HandlerFunc(apiSetActiveHandler)))
+	http.Handle("/encounters/combat/tiebreak", loggingMiddleware(http.HandlerFunc(apiTiebreakHandler)))
+	http.Handle("/encounters/combat/roll-initiative", loggingMiddleware(http.HandlerFunc(apiRollInitiativeHandler)))
+	http.Handle("/encounters/combat/delay", loggingMiddleware(http.HandlerFunc(apiDelayHandler)))
+	http.Handle("/encounters/combat/ready", loggingMiddleware(http.HandlerFunc(apiReadyHandler)))
+	http.Handle("/encounters/combat/rejoin", loggingMiddleware(http.HandlerFunc(apiRejoinHandler)))
+	http.Handle("/encounters/combat/group", loggingMiddleware(http.HandlerFunc(apiInitiativeGroupHandler)))
+	http.Handle("/encounters/initiative-events/add", loggingMiddleware(http.HandlerFunc(apiAddInitiativeEventHandler)))
+	http.Handle("/encounters/initiative-events/remove", loggingMiddleware(http.HandlerFunc(apiRemoveInitiativeEventHandler)))
+	http.Handle("/encounters/effects", loggingMiddleware(http.HandlerFunc(apiEncounterEffectsHandler)))
+	http.Handle("/encounters/effects/add", loggingMiddleware(http.HandlerFunc(apiAddEncounterEffectHandler)))
+	http.Handle("/encounters/effects/remove", loggingMiddleware(http.HandlerFunc(apiRemoveEncounterEffectHandler)))
 	http.Handle("/encounters/conditions/catalog", loggingMiddleware(http.HandlerFunc(apiConditionCatalogHandler)))
 	http.Handle("/encounters/conditions/add", loggingMiddleware(http.HandlerFunc(apiAddConditionHandler)))
 	http.Handle("/encounters/conditions/remove", loggingMiddleware(http.HandlerFunc(apiRemoveConditionHandler)))
+	http.Handle("/conditions/custom", loggingMiddleware(http.HandlerFunc(apiCustomConditionsHandler)))
+	http.Handle("/conditions/custom/save", loggingMiddleware(http.HandlerFunc(apiSaveCustomConditionHandler)))
+	http.Handle("/conditions/custom/delete", loggingMiddleware(http.HandlerFunc(apiDeleteCustomConditionHandler)))
 	http.Handle("/encounters/ledger", loggingMiddleware(http.HandlerFunc(apiEncounterLedgerHandler)))
 	http.Handle("/encounters/events", loggingMiddleware(http.HandlerFunc(apiEncounterEventsHandler)))
 	http.Handle("/encounters/ledger/add", loggingMiddleware(http.HandlerFunc(apiAddEncounterLedgerHandler)))
+	http.Handle("/encounters/damage-types/catalog", loggingMiddleware(http.HandlerFunc(apiDamageTypeCatalogHandler)))
+	http.Handle("/encounters/hp/apply", loggingMiddleware(http.HandlerFunc(apiApplyHPHandler)))
+	http.Handle("/encounters/attack", loggingMiddleware(http.HandlerFunc(apiAttackHandler)))
+	http.Handle("/encounters/saves", loggingMiddleware(http.HandlerFunc(apiGroupSaveHandler)))
+	http.Handle("/encounters/concentration/set", loggingMiddleware(http.HandlerFunc(apiSetConcentrationHandler)))
+	http.Handle("/encounters/concentration/end", loggingMiddleware(http.HandlerFunc(apiEndConcentrationHandler)))
+	http.Handle("/encounters/death-save", loggingMiddleware(http.HandlerFunc(apiDeathSaveHandler)))
+	http.Handle("/encounters/legendary/spend", loggingMiddleware(http.HandlerFunc(apiSpendLegendaryHandler)))
+	http.Handle("/encounters/legendary/restore", loggingMiddleware(http.HandlerFunc(apiRestoreLegendaryHandler)))
+	http.Handle("/encounters/resources/spend", loggingMiddleware(http.HandlerFunc(apiSpendResourceHandler)))
+	http.Handle("/encounters/resources/restore", loggingMiddleware(http.HandlerFunc(apiRestoreResourceHandler)))
+	http.Handle("/dice/roll", loggingMiddleware(http.HandlerFunc(apiRollDiceHandler)))
 	// NPC Template API endpoints
 	http.Handle("/npcs/templates", loggingMiddleware(http.HandlerFunc(apiNpcTemplatesHandler)))
 	http.Handle("/npcs/templates/save", loggingMiddleware(http.HandlerFunc(apiSaveNpcTemplateHandler)))
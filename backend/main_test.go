@@ -148,7 +148,8 @@ func TestGetAllCharacters(t *testing.T) {
 	rows := sqlmock.NewRows([]string{
 		"id", "name", "armor_class", "to_hit_modifier", "max_hp",
 		"current_hp", "initiative", "is_active", "owner_id", "type", "npc_template_id",
-	}).AddRow(1, "Test Character", 15, 2, 100, 100, 0, false, "owner1", "pc", nil)
+		"damage_resistances", "damage_vulnerabilities", "damage_immunities", "resources",
+	}).AddRow(1, "Test Character", 15, 2, 100, 100, 0, false, "owner1", "pc", nil, "{}", "{}", "{}", "[]")
 	mockConn.ExpectQuery("SELECT id, name").WillReturnRows(rows)
 
 	characterDAO := dao.NewCharacterDAO(mockDB)
@@ -174,7 +175,8 @@ func TestGetSampleCharacters(t *testing.T) {
 	rows := sqlmock.NewRows([]string{
 		"id", "name", "armor_class", "to_hit_modifier", "max_hp",
 		"current_hp", "initiative", "is_active", "owner_id", "type", "npc_template_id",
-	}).AddRow(1, "Goblin", 13, 2, 7, 7, 0, false, "", "npc", nil)
+		"damage_resistances", "damage_vulnerabilities", "damage_immunities", "resources",
+	}).AddRow(1, "Goblin", 13, 2, 7, 7, 0, false, "", "npc", nil, "{}", "{}", "{}", "[]")
 	// Only unowned characters are sampled for logged-out visitors.
 	mockConn.ExpectQuery("SELECT id, name").
 		WillReturnRows(rows)
@@ -199,8 +201,8 @@ func TestGetAllEncounters(t *testing.T) {
 	}
 	defer mockDB.Close()
 
-	rows := sqlmock.NewRows([]string{"id", "name", "owner_id", "description"}).
-		AddRow(1, "Goblin Ambush", "dm1", "A group of goblins attack the party.")
+	rows := sqlmock.NewRows([]string{"id", "name", "owner_id", "description", "round", "turn_index", "combat_started", "skip_policy", "ruleset"}).
+		AddRow(1, "Goblin Ambush", "dm1", "A group of goblins attack the party.", 0, 0, false, "none", "2014")
 	mockConn.ExpectQuery("SELECT id, name").WillReturnRows(rows)
 
 	encounterDAO := dao.NewEncounterDAO(mockDB)
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Combat state lives on the encounter rather than being inferred from which
+-- encounter_characters row has is_active set: `round` counts up each time the
+-- turn order wraps (0 = combat not started), `turn_index` is the active slot in
+-- the initiative order, and `combat_started` distinguishes "set up, not yet
+-- fighting" from round 1. StartCombat, AdvanceTurn and ResetCombat keep all
+-- three in step with is_active.
+ALTER TABLE encounters
+    ADD COLUMN IF NOT EXISTS round INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS turn_index INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS combat_started BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE encounters
+    DROP COLUMN IF EXISTS round,
+    DROP COLUMN IF EXISTS turn_index,
+    DROP COLUMN IF EXISTS combat_started;
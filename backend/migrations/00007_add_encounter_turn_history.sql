@@ -0,0 +1,18 @@
+-- +goose Up
+-- One row per AdvanceTurn, holding a JSON snapshot of what that advance changed
+-- (the previously active combatant, the round/turn position and the outgoing
+-- creature's timed conditions before they ticked down). The previous-turn
+-- endpoint pops the newest row to undo a mis-click exactly, including conditions
+-- the tick expired and deleted. Starting or resetting combat clears the history.
+CREATE TABLE IF NOT EXISTS encounter_turn_history (
+    id           SERIAL PRIMARY KEY,
+    encounter_id INTEGER NOT NULL REFERENCES encounters(id) ON DELETE CASCADE,
+    snapshot     JSONB NOT NULL,
+    created_at   TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS encounter_turn_history_encounter_idx
+    ON encounter_turn_history (encounter_id, id);
+
+-- +goose Down
+DROP TABLE IF EXISTS encounter_turn_history;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- DM-chosen position for breaking initiative ties that survive the Dexterity
+-- comparison. Lower goes first; NULL means the DM has not ordered this
+-- combatant, and it falls back to character id.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS tiebreak_order INTEGER;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS tiebreak_order;
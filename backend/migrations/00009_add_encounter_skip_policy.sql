@@ -0,0 +1,9 @@
+-- +goose Up
+-- Which combatants AdvanceTurn passes over: 'none', 'defeated_npcs' (NPCs at
+-- 0 HP) or 'disabled' (anyone Unconscious or Petrified). Validated in Go.
+ALTER TABLE encounters
+    ADD COLUMN IF NOT EXISTS skip_policy TEXT NOT NULL DEFAULT 'none';
+
+-- +goose Down
+ALTER TABLE encounters
+    DROP COLUMN IF EXISTS skip_policy;
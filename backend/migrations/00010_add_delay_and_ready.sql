@@ -0,0 +1,14 @@
+-- +goose Up
+-- held is 'delay' or 'ready' while a combatant has stepped out of the turn
+-- rotation, NULL otherwise. turn_rank orders combatants within one initiative
+-- count ahead of Dexterity, so a combatant rejoining the rotation can be slotted
+-- exactly before or after the current actor; it is 0 until someone rejoins at
+-- that count.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS held TEXT,
+    ADD COLUMN IF NOT EXISTS turn_rank INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS held,
+    DROP COLUMN IF EXISTS turn_rank;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- Turn-order entries that are not creatures: lair actions, environmental
+-- effects and the like act on a fixed initiative count (losing ties to
+-- creatures) and have no HP or AC. is_active mirrors
+-- encounter_characters.is_active; at most one row across the two tables holds
+-- the turn.
+CREATE TABLE IF NOT EXISTS encounter_initiative_events (
+    id               SERIAL PRIMARY KEY,
+    encounter_id     INTEGER NOT NULL REFERENCES encounters(id) ON DELETE CASCADE,
+    name             TEXT NOT NULL,
+    initiative_count INTEGER NOT NULL DEFAULT 20,
+    description      TEXT,
+    is_active        BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMP DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS encounter_initiative_events_encounter_idx ON encounter_initiative_events (encounter_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS encounter_initiative_events;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- grouped puts an NPC in its template's initiative group: every grouped NPC in
+-- an encounter that shares an npc_template_id holds the same initiative and
+-- takes one turn together, while keeping its own HP and conditions.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS grouped BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS grouped;
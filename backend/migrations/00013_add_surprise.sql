@@ -0,0 +1,10 @@
+-- +goose Up
+-- surprised marks a combatant caught unaware when combat starts. It is set by
+-- StartCombat and cleared once the combatant's first turn, which is skipped,
+-- would have ended.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS surprised BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS surprised;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- temp_hp is a combatant's temporary hit points. Damage applied through
+-- /encounters/hp/apply drains it before current_hp; healing never restores it,
+-- and a new grant only replaces it when higher.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS temp_hp INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS temp_hp;
@@ -0,0 +1,31 @@
+-- +goose Up
+-- Damage resistances, vulnerabilities and immunities, as lists of the damage
+-- types in dao.ValidDamageTypes. Templates carry them and spawned NPCs get a
+-- copy. details holds structured data about a ledger entry, such as the raw
+-- and final damage of a hit after those defenses.
+ALTER TABLE npc_templates
+    ADD COLUMN IF NOT EXISTS damage_resistances TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS damage_vulnerabilities TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS damage_immunities TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE characters
+    ADD COLUMN IF NOT EXISTS damage_resistances TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS damage_vulnerabilities TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS damage_immunities TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE encounter_ledger
+    ADD COLUMN IF NOT EXISTS details JSONB;
+
+-- +goose Down
+ALTER TABLE encounter_ledger
+    DROP COLUMN IF EXISTS details;
+
+ALTER TABLE characters
+    DROP COLUMN IF EXISTS damage_resistances,
+    DROP COLUMN IF EXISTS damage_vulnerabilities,
+    DROP COLUMN IF EXISTS damage_immunities;
+
+ALTER TABLE npc_templates
+    DROP COLUMN IF EXISTS damage_resistances,
+    DROP COLUMN IF EXISTS damage_vulnerabilities,
+    DROP COLUMN IF EXISTS damage_immunities;
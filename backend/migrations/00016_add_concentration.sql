@@ -0,0 +1,13 @@
+-- +goose Up
+-- concentration is the spell a combatant is concentrating on, NULL when none.
+-- concentration_condition_ids are the encounter_character_conditions rows
+-- that spell sustains on other creatures; they are removed when concentration
+-- ends, whether the caster chooses to end it or fails a Constitution save.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS concentration TEXT,
+    ADD COLUMN IF NOT EXISTS concentration_condition_ids INTEGER[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS concentration,
+    DROP COLUMN IF EXISTS concentration_condition_ids;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Death saving throws for player characters at 0 HP. The tally counts toward
+-- three successes (stable) or three failures (dead); healing resets it.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS death_save_successes INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS death_save_failures INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS stable BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS dead BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS death_save_successes,
+    DROP COLUMN IF EXISTS death_save_failures,
+    DROP COLUMN IF EXISTS stable,
+    DROP COLUMN IF EXISTS dead;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Homebrew conditions ("Marked", "Bleeding", ...) a user defines alongside the
+-- canonical 5e set. They are visible to their owner, and to anyone playing in
+-- an encounter that owner runs. max_level is 0 for binary conditions; a leveled
+-- one may describe each level in level_effects, indexed by level - 1.
+CREATE TABLE IF NOT EXISTS custom_conditions (
+    id            SERIAL PRIMARY KEY,
+    owner_id      TEXT NOT NULL, -- discord_id of the author
+    name          TEXT NOT NULL,
+    description   TEXT NOT NULL DEFAULT '',
+    max_level     INTEGER NOT NULL DEFAULT 0,
+    level_effects TEXT[] NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMP DEFAULT now(),
+    UNIQUE (owner_id, name)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS custom_conditions;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Which edition of the rules an encounter plays: '2014' or '2024'. It decides
+-- the exhaustion table and condition effects. Existing encounters keep the
+-- 2014 rules; new ones take their owner's users.ruleset preference.
+-- Validated in Go.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS ruleset TEXT NOT NULL DEFAULT '2014';
+ALTER TABLE encounters
+    ADD COLUMN IF NOT EXISTS ruleset TEXT NOT NULL DEFAULT '2014';
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN IF EXISTS ruleset;
+ALTER TABLE encounters
+    DROP COLUMN IF EXISTS ruleset;
@@ -0,0 +1,25 @@
+-- +goose Up
+-- Legendary actions and legendary resistances. A template's pools are copied
+-- onto each NPC spawned from it, where the current uses count down from the
+-- max: legendary actions refill at the start of the creature's turn,
+-- legendary resistances only when restored.
+ALTER TABLE npc_templates
+    ADD COLUMN IF NOT EXISTS legendary_actions INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS legendary_resistances INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS legendary_actions INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS legendary_actions_max INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS legendary_resistances INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS legendary_resistances_max INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS legendary_actions,
+    DROP COLUMN IF EXISTS legendary_actions_max,
+    DROP COLUMN IF EXISTS legendary_resistances,
+    DROP COLUMN IF EXISTS legendary_resistances_max;
+
+ALTER TABLE npc_templates
+    DROP COLUMN IF EXISTS legendary_actions,
+    DROP COLUMN IF EXISTS legendary_resistances;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- Limited-use resources: spell slots, ki points, "3/day" abilities. Library
+-- characters and NPC templates list them as JSON (name, max and recharge
+-- rule); each combatant gets its own copy in encounter_resources when it joins
+-- an encounter, where current_uses counts down from max_uses. recharge is
+-- 'short_rest', 'long_rest', 'turn_start' or 'recharge' (a d6 roll of 5-6 at
+-- the start of the creature's turn).
+ALTER TABLE characters
+    ADD COLUMN IF NOT EXISTS resources JSONB NOT NULL DEFAULT '[]';
+
+ALTER TABLE npc_templates
+    ADD COLUMN IF NOT EXISTS resources JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS encounter_resources (
+    id           SERIAL PRIMARY KEY,
+    encounter_id INTEGER NOT NULL,
+    character_id INTEGER NOT NULL,
+    name         TEXT NOT NULL,
+    current_uses INTEGER NOT NULL,
+    max_uses     INTEGER NOT NULL,
+    recharge     TEXT NOT NULL,
+    FOREIGN KEY (encounter_id, character_id)
+        REFERENCES encounter_characters(encounter_id, character_id) ON DELETE CASCADE,
+    UNIQUE (encounter_id, character_id, name)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS encounter_resources;
+
+ALTER TABLE npc_templates
+    DROP COLUMN IF EXISTS resources;
+
+ALTER TABLE characters
+    DROP COLUMN IF EXISTS resources;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Recharge abilities name the lowest d6 that recharges them ("Recharge 5-6"
+-- is 5, "Recharge 6" is 6). 0 means the usual 5; spawned copies carry their
+-- template's threshold. AdvanceTurn rolls for each spent ability as its
+-- creature's turn starts.
+ALTER TABLE encounter_resources
+    ADD COLUMN IF NOT EXISTS recharge_on INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE encounter_resources
+    DROP COLUMN IF EXISTS recharge_on;
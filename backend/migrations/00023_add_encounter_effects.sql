@@ -0,0 +1,21 @@
+-- +goose Up
+-- Timed effects that belong to the battlefield rather than a creature: Wall of
+-- Fire, Cloudkill and the like. owner_id is the combatant that created the
+-- effect, if any. duration_rounds counts down once a round at the start or end
+-- (tick_at) of tick_character_id's turn and the effect is deleted, with a
+-- ledger entry, when it runs out; NULL lasts until removed.
+CREATE TABLE IF NOT EXISTS encounter_effects (
+    id                SERIAL PRIMARY KEY,
+    encounter_id      INTEGER NOT NULL REFERENCES encounters(id) ON DELETE CASCADE,
+    name              TEXT NOT NULL,
+    description       TEXT NOT NULL DEFAULT '',
+    owner_id          INTEGER REFERENCES characters(id) ON DELETE SET NULL,
+    duration_rounds   INTEGER,
+    tick_character_id INTEGER REFERENCES characters(id) ON DELETE SET NULL,
+    tick_at           TEXT NOT NULL DEFAULT 'end',
+    created_at        TIMESTAMP DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS encounter_effects_encounter_idx ON encounter_effects (encounter_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS encounter_effects;
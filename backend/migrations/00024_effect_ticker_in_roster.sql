@@ -0,0 +1,25 @@
+-- +goose Up
+-- A timed effect ticks on a combatant's turn, so its tick combatant must be in
+-- the encounter's roster rather than merely exist: removing the combatant from
+-- the encounter, or deleting the character, now removes the effects that tick
+-- on its turn instead of leaving them to never run out. Effects already
+-- stranded that way are cleared first.
+DELETE FROM encounter_effects e
+WHERE e.duration_rounds IS NOT NULL
+  AND NOT EXISTS (
+      SELECT 1 FROM encounter_characters ec
+      WHERE ec.encounter_id = e.encounter_id AND ec.character_id = e.tick_character_id
+  );
+ALTER TABLE encounter_effects
+    DROP CONSTRAINT IF EXISTS encounter_effects_tick_character_id_fkey,
+    ADD CONSTRAINT encounter_effects_tick_character_fkey FOREIGN KEY (encounter_id, tick_character_id)
+        REFERENCES encounter_characters(encounter_id, character_id) ON DELETE CASCADE,
+    ADD CONSTRAINT encounter_effects_timed_ticker_check
+        CHECK (duration_rounds IS NULL OR tick_character_id IS NOT NULL);
+
+-- +goose Down
+ALTER TABLE encounter_effects
+    DROP CONSTRAINT IF EXISTS encounter_effects_timed_ticker_check,
+    DROP CONSTRAINT IF EXISTS encounter_effects_tick_character_fkey,
+    ADD CONSTRAINT encounter_effects_tick_character_id_fkey FOREIGN KEY (tick_character_id)
+        REFERENCES characters(id) ON DELETE SET NULL;
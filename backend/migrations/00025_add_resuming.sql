@@ -0,0 +1,10 @@
+-- +goose Up
+-- resuming marks a combatant whose turn a rejoin before it interrupted. Its
+-- turn has already started, so the next advance to it resumes the turn without
+-- refilling, recharging or ticking effects again, and clears the mark.
+ALTER TABLE encounter_characters
+    ADD COLUMN IF NOT EXISTS resuming BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE encounter_characters
+    DROP COLUMN IF EXISTS resuming;
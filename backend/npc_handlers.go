@@ -41,6 +41,20 @@ func apiSaveNpcTemplateHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusBadRequest, "NPC name is required")
 		return
 	}
+	if err := nt.DamageDefenses.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Unknown damage type in resistances, vulnerabilities or immunities")
+		return
+	}
+	for _, pool := range []*int{nt.LegendaryActions, nt.LegendaryResistances} {
+		if pool != nil && (*pool < 0 || *pool > dao.MaxLegendaryUses) {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Legendary actions and resistances must be between 0 and %d", dao.MaxLegendaryUses))
+			return
+		}
+	}
+	if err := nt.Resources.Validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid resources: "+err.Error())
+		return
+	}
 
 	// Ownership is always the authenticated caller; never trust a body value.
 	discordID := getDiscordIDFromRequest(r)
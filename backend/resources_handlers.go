@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"go-initiative-tracker/dao"
+	"net/http"
+	"strings"
+)
+
+// resourceRequest is the body of the resource spend and restore endpoints.
+// A restore either names one resource, or names a rest ("short_rest" or
+// "long_rest") to refill everything it restores for character_id, or for the
+// whole encounter when character_id is omitted.
+type resourceRequest struct {
+	EncounterID int    `json:"encounter_id"`
+	ResourceID  int    `json:"resource_id"`
+	CharacterID int    `json:"character_id"`
+	Rest        string `json:"rest"`
+	Amount      int    `json:"amount"`
+	Note        string `json:"note"`
+}
+
+// apiSpendResourceHandler spends uses of a combatant's resource (one unless
+// amount says otherwise) and logs it to the ledger.
+func apiSpendResourceHandler(w http.ResponseWriter, r *http.Request) {
+	handleResource(w, r, true)
+}
+
+// apiRestoreResourceHandler gives back amount uses of a resource, refills it
+// when amount is omitted, or refills whatever a rest restores.
+func apiRestoreResourceHandler(w http.ResponseWriter, r *http.Request) {
+	handleResource(w, r, false)
+}
+
+func handleResource(w http.ResponseWriter, r *http.Request, spend bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req resourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	rest := !spend && req.Rest != ""
+	if req.EncounterID <= 0 || req.CharacterID < 0 || (!rest && req.ResourceID <= 0) {
+		writeJSONError(w, http.StatusBadRequest, "Invalid encounter or resource id")
+		return
+	}
+	if req.Amount < 0 || req.Amount > dao.MaxResourceUses {
+		writeJSONError(w, http.StatusBadRequest, "Invalid amount")
+		return
+	}
+	if !requireEncounterAccess(w, r, req.EncounterID) {
+		return
+	}
+
+	var result map[string]any
+	var err error
+	if rest {
+		var entries []dao.EncounterLedgerEntry
+		entries, err = encounterCharacterDAO.Rest(req.EncounterID, req.CharacterID, req.Rest)
+		result = map[string]any{"status": "success", "entries": entries}
+	} else {
+		change := encounterCharacterDAO.RestoreResource
+		if spend {
+			change = encounterCharacterDAO.SpendResource
+		}
+		var res dao.Resource
+		var entry dao.EncounterLedgerEntry
+		res, entry, err = change(dao.ResourceUse{
+			EncounterID: req.EncounterID,
+			ResourceID:  req.ResourceID,
+			Amount:      req.Amount,
+			Note:        req.Note,
+		})
+		result = map[string]any{"status": "success", "resource": res, "entry": entry}
+	}
+	if err != nil {
+		msg := strings.ToLower(err.Error())
+		switch {
+		case strings.Contains(msg, "not in encounter"):
+			writeJSONError(w, http.StatusNotFound, "Resource not in encounter")
+		case strings.Contains(msg, "unknown rest"):
+			writeJSONError(w, http.StatusBadRequest, "Rest must be short_rest or long_rest")
+		case strings.Contains(msg, "not enough"):
+			writeJSONError(w, http.StatusConflict, "Not enough uses left")
+		default:
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update resource")
+		}
+		return
+	}
+
+	events.publish(req.EncounterID, "character")
+	json.NewEncoder(w).Encode(result)
+}
@@ -1,6 +1,11 @@
 
 
+DROP TABLE IF EXISTS encounter_effects;
+DROP TABLE IF EXISTS encounter_resources;
+DROP TABLE IF EXISTS encounter_turn_history;
+DROP TABLE IF EXISTS encounter_initiative_events;
 DROP TABLE IF EXISTS encounter_character_conditions;
+DROP TABLE IF EXISTS custom_conditions;
 DROP TABLE IF EXISTS encounter_characters;
 DROP TABLE IF EXISTS encounter_users;
 DROP TABLE IF EXISTS encounter_ledger;
@@ -16,7 +21,8 @@ CREATE TABLE users (
 	discord_id TEXT UNIQUE NOT NULL,
 	username TEXT NOT NULL,
 	discriminator TEXT,
-	avatar TEXT
+	avatar TEXT,
+	ruleset TEXT NOT NULL DEFAULT '2014' -- default for new encounters (see migration 00019)
 );
 
 CREATE TYPE stat_block AS (
@@ -34,7 +40,15 @@ CREATE TABLE npc_templates (
 	description TEXT,
 	base_stats stat_block, -- Default stats for this NPC type
 	armor_class INTEGER DEFAULT 10,
-	max_hp INTEGER DEFAULT 10
+	max_hp INTEGER DEFAULT 10,
+	-- Damage defenses, copied onto spawned NPCs (see migration 00015).
+	damage_resistances TEXT[] NOT NULL DEFAULT '{}',
+	damage_vulnerabilities TEXT[] NOT NULL DEFAULT '{}',
+	damage_immunities TEXT[] NOT NULL DEFAULT '{}',
+	-- Legendary pools, copied onto spawned NPCs (see migration 00020).
+	legendary_actions INTEGER NOT NULL DEFAULT 0,
+	legendary_resistances INTEGER NOT NULL DEFAULT 0,
+	resources JSONB NOT NULL DEFAULT '[]' -- limited-use resources (see migration 00021)
 );
 CREATE TABLE characters (
     id SERIAL PRIMARY KEY,
@@ -46,7 +60,12 @@ CREATE TABLE characters (
 	armor_class INTEGER DEFAULT 10, -- AC for the character
 	to_hit_modifier INTEGER DEFAULT 0, -- Attack roll modifier
 	max_hp INTEGER DEFAULT 10, -- Maximum hit points
-	npc_template_id INTEGER REFERENCES npc_templates(id) ON DELETE SET NULL -- For NPCs
+	npc_template_id INTEGER REFERENCES npc_templates(id) ON DELETE SET NULL, -- For NPCs
+	-- Damage defenses (see migration 00015).
+	damage_resistances TEXT[] NOT NULL DEFAULT '{}',
+	damage_vulnerabilities TEXT[] NOT NULL DEFAULT '{}',
+	damage_immunities TEXT[] NOT NULL DEFAULT '{}',
+	resources JSONB NOT NULL DEFAULT '[]' -- limited-use resources (see migration 00021)
 );
   
 -- Encounters
@@ -54,7 +73,13 @@ CREATE TABLE encounters (
 	id SERIAL PRIMARY KEY,
 	name TEXT NOT NULL,
 	owner_id TEXT, -- Discord user ID of DM
-	description TEXT
+	description TEXT,
+	-- Combat state (see migration 00006).
+	round INTEGER NOT NULL DEFAULT 0, -- 0 = combat not started
+	turn_index INTEGER NOT NULL DEFAULT 0, -- active slot in the initiative order
+	combat_started BOOLEAN NOT NULL DEFAULT FALSE,
+	skip_policy TEXT NOT NULL DEFAULT 'none', -- who AdvanceTurn passes over (see migration 00009)
+	ruleset TEXT NOT NULL DEFAULT '2014' -- '2014' or '2024' rules (see migration 00019)
 );
 CREATE TABLE encounter_ledger (
 	id SERIAL PRIMARY KEY,
@@ -64,6 +89,7 @@ CREATE TABLE encounter_ledger (
 	action_type TEXT,
 	hp_change INTEGER,
 	description TEXT,
+	details JSONB, -- structured data, e.g. raw and final damage (see migration 00015)
 	created_at TIMESTAMP DEFAULT now()
 );
 
@@ -75,6 +101,23 @@ CREATE TABLE encounter_characters (
 	initiative INTEGER,
 	current_hp INTEGER,
 	is_active BOOLEAN DEFAULT FALSE,
+	tiebreak_order INTEGER, -- DM's order for tied initiative (see migration 00008)
+	held TEXT, -- 'delay' or 'ready' while out of the rotation (see migration 00010)
+	turn_rank INTEGER NOT NULL DEFAULT 0, -- order within an initiative count after a rejoin
+	grouped BOOLEAN NOT NULL DEFAULT FALSE, -- acts with its template's group (see migration 00012)
+	surprised BOOLEAN NOT NULL DEFAULT FALSE, -- loses its first turn (see migration 00013)
+	temp_hp INTEGER NOT NULL DEFAULT 0, -- temporary hit points, drained first (see migration 00014)
+	concentration TEXT, -- spell being concentrated on (see migration 00016)
+	concentration_condition_ids INTEGER[] NOT NULL DEFAULT '{}', -- conditions that spell sustains
+	death_save_successes INTEGER NOT NULL DEFAULT 0, -- death saves at 0 HP (see migration 00017)
+	death_save_failures INTEGER NOT NULL DEFAULT 0,
+	stable BOOLEAN NOT NULL DEFAULT FALSE,
+	dead BOOLEAN NOT NULL DEFAULT FALSE,
+	legendary_actions INTEGER NOT NULL DEFAULT 0, -- uses left of each legendary pool (see migration 00020)
+	legendary_actions_max INTEGER NOT NULL DEFAULT 0,
+	legendary_resistances INTEGER NOT NULL DEFAULT 0,
+	legendary_resistances_max INTEGER NOT NULL DEFAULT 0,
+	resuming BOOLEAN NOT NULL DEFAULT FALSE, -- turn interrupted by a rejoin, not started again (see migration 00025)
 	PRIMARY KEY (encounter_id, character_id)
 );
 
@@ -100,6 +143,76 @@ CREATE TABLE encounter_character_conditions (
 	UNIQUE (encounter_id, character_id, condition)
 );
 
+-- Snapshots of each AdvanceTurn so a turn can be rewound (see migration 00007).
+CREATE TABLE encounter_turn_history (
+	id           SERIAL PRIMARY KEY,
+	encounter_id INTEGER NOT NULL REFERENCES encounters(id) ON DELETE CASCADE,
+	snapshot     JSONB NOT NULL,
+	created_at   TIMESTAMP DEFAULT now()
+);
+CREATE INDEX encounter_turn_history_encounter_idx ON encounter_turn_history (encounter_id, id);
+
+-- Lair actions and other initiative-count-only entries (see migration 00011).
+CREATE TABLE encounter_initiative_events (
+	id               SERIAL PRIMARY KEY,
+	encounter_id     INTEGER NOT NULL REFERENCES encounters(id) ON DELETE CASCADE,
+	name             TEXT NOT NULL,
+	initiative_count INTEGER NOT NULL DEFAULT 20, -- loses ties to creatures
+	description      TEXT,
+	is_active        BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at       TIMESTAMP DEFAULT now()
+);
+CREATE INDEX encounter_initiative_events_encounter_idx ON encounter_initiative_events (encounter_id);
+
+-- Per-user homebrew conditions, merged into the condition catalog (see
+-- migration 00018).
+CREATE TABLE custom_conditions (
+	id            SERIAL PRIMARY KEY,
+	owner_id      TEXT NOT NULL, -- discord_id of the author
+	name          TEXT NOT NULL,
+	description   TEXT NOT NULL DEFAULT '',
+	max_level     INTEGER NOT NULL DEFAULT 0, -- 0 = binary condition
+	level_effects TEXT[] NOT NULL DEFAULT '{}',
+	created_at    TIMESTAMP DEFAULT now(),
+	UNIQUE (owner_id, name)
+);
+
+-- Each combatant's limited-use resources in an encounter, copied from its
+-- character when it joins (see migration 00021).
+CREATE TABLE encounter_resources (
+	id           SERIAL PRIMARY KEY,
+	encounter_id INTEGER NOT NULL,
+	character_id INTEGER NOT NULL,
+	name         TEXT NOT NULL,
+	current_uses INTEGER NOT NULL,
+	max_uses     INTEGER NOT NULL,
+	recharge     TEXT NOT NULL, -- 'short_rest', 'long_rest', 'turn_start' or 'recharge'
+	recharge_on  INTEGER NOT NULL DEFAULT 0, -- lowest d6 that recharges a 'recharge' ability; 0 is 5 (see migration 00022)
+	FOREIGN KEY (encounter_id, character_id)
+		REFERENCES encounter_characters(encounter_id, character_id) ON DELETE CASCADE,
+	UNIQUE (encounter_id, character_id, name)
+);
+
+-- Timed battlefield effects such as Wall of Fire, ticked by AdvanceTurn (see
+-- migration 00023).
+CREATE TABLE encounter_effects (
+	id                SERIAL PRIMARY KEY,
+	encounter_id      INTEGER NOT NULL REFERENCES encounters(id) ON DELETE CASCADE,
+	name              TEXT NOT NULL,
+	description       TEXT NOT NULL DEFAULT '',
+	owner_id          INTEGER REFERENCES characters(id) ON DELETE SET NULL, -- combatant that created it
+	duration_rounds   INTEGER, -- NULL lasts until removed
+	tick_character_id INTEGER,
+	tick_at           TEXT NOT NULL DEFAULT 'end', -- 'start' or 'end' of tick_character_id's turn
+	created_at        TIMESTAMP DEFAULT now(),
+	-- Removing the tick combatant from the encounter removes the effect (see
+	-- migration 00024).
+	FOREIGN KEY (encounter_id, tick_character_id)
+		REFERENCES encounter_characters(encounter_id, character_id) ON DELETE CASCADE,
+	CHECK (duration_rounds IS NULL OR tick_character_id IS NOT NULL)
+);
+CREATE INDEX encounter_effects_encounter_idx ON encounter_effects (encounter_id);
+
 -- Example Inserts
 
 